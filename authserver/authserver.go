@@ -0,0 +1,671 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package authserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/google/uuid"
+)
+
+/*
+ * This file contains the authorization codes and the tokens issued by the authorization server. The access tokens
+ * are informed to the platform services like the user sessions and removed from them on expiry
+ */
+
+//Error codes of the authorization server as per the oauth2 spec
+const (
+	//ErrorInvalidRequest is returned when a param is missing or invalid
+	ErrorInvalidRequest = "invalid_request"
+	//ErrorInvalidClient is returned when the client authentication fails
+	ErrorInvalidClient = "invalid_client"
+	//ErrorUnauthorizedClient is returned when the app isn't allowed to use the grant type
+	ErrorUnauthorizedClient = "unauthorized_client"
+	//ErrorInvalidGrant is returned when the authorization code or the refresh token is invalid
+	ErrorInvalidGrant = "invalid_grant"
+	//ErrorUnsupportedGrantType is returned for the grant types not supported
+	ErrorUnsupportedGrantType = "unsupported_grant_type"
+	//ErrorUnsupportedResponseType is returned for the response types not supported
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	//ErrorInvalidScope is returned when the scope requested is not supported
+	ErrorInvalidScope = "invalid_scope"
+	//ErrorAccessDenied is returned when the user denies the consent
+	ErrorAccessDenied = "access_denied"
+	//ErrorUnsupportedTokenType is returned when the token can't be revoked
+	ErrorUnsupportedTokenType = "unsupported_token_type"
+	//ErrorServerError is returned when the request couldn't be completed
+	ErrorServerError = "server_error"
+)
+
+//Error is an error of the authorization server
+type Error struct {
+	//Code is the oauth2 error code. Eg. invalid_grant
+	Code string `json:"error"`
+	//Description is the human readable description of the error
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+//Token is the token response of the token endpoint
+type Token struct {
+	//AccessToken is the token with which the app acts on behalf of the user
+	AccessToken string `json:"access_token"`
+	//TokenType is the type of the access token. It is always Bearer
+	TokenType string `json:"token_type"`
+	//ExpiresIn is the number of seconds after which the access token expires
+	ExpiresIn int64 `json:"expires_in"`
+	//RefreshToken is the token with which the app gets a new access token
+	RefreshToken string `json:"refresh_token,omitempty"`
+	//Scope granted by the user
+	Scope string `json:"scope,omitempty"`
+}
+
+//Introspection is the introspection response of a token as per rfc 7662
+type Introspection struct {
+	//Active tells whether the token is active. Rest of the fields are given only for the active tokens
+	Active bool `json:"active"`
+	//Subject is the id of the user authenticated with the token. For the tokens of the apps themselves it is the
+	//id of the user who registered the app
+	Subject string `json:"sub,omitempty"`
+	//UserType is the type of the user authenticated with the token
+	UserType string `json:"user_type,omitempty"`
+	//Email of the user. It is given only if the user or the app has access to it
+	Email string `json:"email,omitempty"`
+	//Scope granted to the app. It is empty for the sessions of the users
+	Scope string `json:"scope,omitempty"`
+	//ClientID is the client id of the app to which the token was issued
+	ClientID string `json:"client_id,omitempty"`
+	//AppID is the id of the app to which the token was issued. It is zero for the sessions of the users
+	AppID uint `json:"app_id,omitempty"`
+	//TokenType is the type of the token. It is always Bearer
+	TokenType string `json:"token_type,omitempty"`
+	//Expires is the unix time at which the token expires. It is zero for the tokens without an expiry
+	//like the sessions of the users and the long lived tokens of the apps
+	Expires int64 `json:"exp,omitempty"`
+}
+
+//liveToken is an access token informed to the platform services
+type liveToken struct {
+	//user informed to the platform services
+	user config.User
+	//expires is the time at which the token is removed from the platform services
+	expires time.Time
+}
+
+//live has the access tokens informed to the platform services mapped to the hash of the token
+var live = struct {
+	tokens map[string]liveToken
+	lock   sync.Mutex
+}{tokens: map[string]liveToken{}}
+
+//random returns a url safe random string of the given number of bytes
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//NewKey returns a random url safe key. Eg. the key of the consent form
+func NewKey() (string, error) {
+	return random(32)
+}
+
+//Hash returns the hex encoded sha256 hash of the code, token or secret. They are random with enough entropy
+//so a plain hash suffices
+func Hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+//NewClientSecret generates a client secret for an app and returns it along with its hash
+func NewClientSecret() (secret string, hash string, err error) {
+	secret, err = random(32)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, Hash(secret), nil
+}
+
+//Client returns the app of the client id. If the app doesn't exist nil is returned
+func Client(appCtx *config.AppContext, clientID string) *config.AppInfo {
+	if _, err := uuid.Parse(clientID); err != nil {
+		return nil
+	}
+	return config.GetAppByUID(*appCtx, clientID)
+}
+
+//AuthenticateClient authenticates the app with the client id and the secret given with the basic auth or
+//posted in the form
+func AuthenticateClient(appCtx *config.AppContext, r *http.Request) (*config.AppInfo, error) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		//the credentials are form url encoded in the basic auth as per the spec
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	app := Client(appCtx, id)
+	if app == nil || len(app.ClientSecretHash) == 0 ||
+		subtle.ConstantTimeCompare([]byte(Hash(secret)), []byte(app.ClientSecretHash)) != 1 {
+		return nil, &Error{Code: ErrorInvalidClient, Description: "Client authentication failed"}
+	}
+	return app, nil
+}
+
+//ValidRedirectURI tells whether the uri is one of the redirect uris registered by the app. The uris are matched exactly
+func ValidRedirectURI(app *config.AppInfo, uri string) bool {
+	return SafeRedirectURI(uri) && contains(strings.Fields(app.RedirectURIs), uri)
+}
+
+//unsafeSchemes are the schemes never allowed in the redirect uris as they run scripts in the browser
+var unsafeSchemes = []string{"javascript", "data", "vbscript"}
+
+//SafeRedirectURI tells whether the uri can be a redirect uri. It has to be an absolute uri without a fragment
+//having the https scheme, the http scheme on a loopback host or one of the custom schemes allowed
+func SafeRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || strings.ContainsAny(uri, "# \t\r\n") {
+		return false
+	}
+	switch scheme := strings.ToLower(u.Scheme); {
+	case contains(unsafeSchemes, scheme):
+		return false
+	case scheme == "https":
+		return len(u.Host) != 0
+	case scheme == "http":
+		ip := net.ParseIP(u.Hostname())
+		return u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback())
+	default:
+		return contains(CustomSchemes, scheme)
+	}
+}
+
+//ParseScope validates the scope requested and returns it normalized. The default scope is given if none is requested
+func ParseScope(scope string) (string, error) {
+	if len(strings.TrimSpace(scope)) == 0 {
+		scope = DefaultScope
+	}
+	result := []string{}
+	for _, s := range strings.Fields(scope) {
+		if !contains(Scopes, s) {
+			return "", &Error{Code: ErrorInvalidScope, Description: "Scope " + s + " is not supported"}
+		}
+		if !contains(result, s) {
+			result = append(result, s)
+		}
+	}
+	return strings.Join(result, " "), nil
+}
+
+//ScopeCovers tells whether the granted scope has all the scopes requested
+func ScopeCovers(granted string, requested string) bool {
+	g := strings.Fields(granted)
+	for _, s := range strings.Fields(requested) {
+		if !contains(g, s) {
+			return false
+		}
+	}
+	return true
+}
+
+//NewCode issues an authorization code to the app for the user who consented to the scope. challenge is the
+//S256 pkce code challenge of the request if the app used pkce. redirectURISupplied tells whether the app gave the
+//redirect uri in the request
+func NewCode(appCtx *config.AppContext, app *config.AppInfo, userID uint, redirectURI string, redirectURISupplied bool, scope string, challenge string) (string, error) {
+	code, err := random(32)
+	if err != nil {
+		return "", err
+	}
+	c := &config.AuthorizationCode{
+		CodeHash:            Hash(code),
+		AppID:               app.ID,
+		UserID:              userID,
+		RedirectURI:         redirectURI,
+		RedirectURISupplied: redirectURISupplied,
+		Scope:               scope,
+		CodeChallenge:       challenge,
+		Expires:             time.Now().Add(CodeTTL),
+	}
+	return code, c.Insert(*appCtx)
+}
+
+//ExchangeCode exchanges the authorization code issued to the app for the tokens. A code used again revokes the
+//tokens issued from it since the code may have been stolen
+func ExchangeCode(appCtx *config.AppContext, app *config.AppInfo, code string, redirectURI string, verifier string) (*Token, error) {
+	/*
+	 * We will get the code issued to the app
+	 * We will consume the code. If it was used already, we will revoke the tokens issued from it
+	 * We will validate the redirect uri and the pkce code verifier
+	 * Then we will issue the tokens
+	 */
+	c := config.GetAuthorizationCode(*appCtx, Hash(code))
+	if len(code) == 0 || c == nil || c.AppID != app.ID {
+		return nil, &Error{Code: ErrorInvalidGrant, Description: "Invalid authorization code"}
+	}
+
+	//consuming the code
+	err := c.Consume(*appCtx)
+	if err == config.ErrCodeNotValid {
+		if c.Used {
+			appCtx.Log.Warn("authorization code", c.ID, "was used again by the app", app.ID)
+			revokeTokens(appCtx, "code_id = ?", c.ID)
+		}
+		return nil, &Error{Code: ErrorInvalidGrant, Description: "Authorization code has expired or has been used"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//validating the redirect uri and the code verifier. The redirect uri is optional if the app didn't give it in
+	//the authorization request
+	if (c.RedirectURISupplied || len(redirectURI) != 0) && c.RedirectURI != redirectURI {
+		return nil, &Error{Code: ErrorInvalidGrant, Description: "Redirect uri doesn't match the authorization request"}
+	}
+	if len(c.CodeChallenge) != 0 {
+		sum := sha256.Sum256([]byte(verifier))
+		if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(c.CodeChallenge)) != 1 {
+			return nil, &Error{Code: ErrorInvalidGrant, Description: "Invalid code verifier"}
+		}
+	}
+
+	//issuing the tokens
+	info := config.GetUserInfoByID(*appCtx, c.UserID)
+	if info == nil {
+		return nil, &Error{Code: ErrorInvalidGrant, Description: "User of the authorization code not found"}
+	}
+	return issue(appCtx, app, delegatedUser(app, info, c.Scope), info.ID, c.ID)
+}
+
+//Refresh issues new tokens to the app for the refresh token and revokes the old ones. scope can narrow down the
+//scope of the old tokens. A refresh token used again revokes the tokens issued from the same authorization code
+func Refresh(appCtx *config.AppContext, app *config.AppInfo, refreshToken string, scope string) (*Token, error) {
+	/*
+	 * We will get the token of the refresh token issued to the app
+	 * We will validate the scope
+	 * We will revoke the old token
+	 * Then we will issue the new tokens
+	 */
+	t := config.GetAppTokenByRefreshToken(*appCtx, Hash(refreshToken))
+	if len(refreshToken) == 0 || t == nil || t.AppID != app.ID || t.RefreshExpires.Before(time.Now()) {
+		return nil, &Error{Code: ErrorInvalidGrant, Description: "Invalid refresh token"}
+	}
+	if t.RevokedAt != nil {
+		if t.CodeID != 0 {
+			appCtx.Log.Warn("revoked refresh token of", t.ID, "was used again by the app", app.ID)
+			revokeTokens(appCtx, "code_id = ?", t.CodeID)
+		}
+		return nil, &Error{Code: ErrorInvalidGrant, Description: "Refresh token has been revoked"}
+	}
+
+	//validating the scope
+	if len(scope) == 0 {
+		scope = t.Scope
+	}
+	scope, err := ParseScope(scope)
+	if err != nil {
+		return nil, err
+	}
+	if !ScopeCovers(t.Scope, scope) {
+		return nil, &Error{Code: ErrorInvalidScope, Description: "Scope exceeds the scope granted by the user"}
+	}
+
+	//revoking the old token
+	err = t.Revoke(*appCtx)
+	if err == config.ErrAppTokenNotActive {
+		return nil, &Error{Code: ErrorInvalidGrant, Description: "Refresh token has been revoked"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	withdraw(appCtx, t.AccessTokenHash)
+
+	//issuing the new tokens
+	info := config.GetUserInfoByID(*appCtx, t.UserID)
+	if info == nil {
+		return nil, &Error{Code: ErrorInvalidGrant, Description: "User of the refresh token not found"}
+	}
+	return issue(appCtx, app, delegatedUser(app, info, scope), info.ID, t.CodeID)
+}
+
+//RevokeUserTokens revokes the tokens issued to the apps on behalf of the user
+func RevokeUserTokens(appCtx *config.AppContext, userID uint) {
+	revokeTokens(appCtx, "user_id = ?", userID)
+}
+
+//RevokeAppTokens revokes the tokens issued to the app
+func RevokeAppTokens(appCtx *config.AppContext, appID uint) {
+	revokeTokens(appCtx, "app_id = ?", appID)
+}
+
+//revokeTokens revokes the active tokens matching the query and removes them from the platform services.
+//Failures are only logged
+func revokeTokens(appCtx *config.AppContext, query string, args ...interface{}) {
+	tokens, err := config.GetActiveAppTokens(*appCtx, query, args...)
+	if err != nil {
+		appCtx.Log.Error("error while getting the app tokens to revoke", err.Error())
+		return
+	}
+	for _, t := range tokens {
+		if err := t.Revoke(*appCtx); err != nil && err != config.ErrAppTokenNotActive {
+			appCtx.Log.Error("error while revoking the app token", t.ID, err.Error())
+			continue
+		}
+		withdraw(appCtx, t.AccessTokenHash)
+	}
+}
+
+//Revoke revokes the access token or the refresh token issued to the app as per rfc 7009. Revoking either of them
+//revokes the other issued along with it. The long lived access token of the app is revoked too. hint is the
+//token_type_hint of the request telling which type of token to look up first. Tokens which are invalid or not
+//issued to the app are ignored so that the app can't learn about the tokens of others
+func Revoke(appCtx *config.AppContext, app *config.AppInfo, token string, hint string) error {
+	/*
+	 * We will look up the token issued by the authorization server
+	 * If found we will revoke it and remove it from the platform services
+	 * Else we will check whether it is the long lived access token of the app
+	 */
+	if len(token) == 0 {
+		return &Error{Code: ErrorInvalidRequest, Description: "Token is missing"}
+	}
+	h := Hash(token)
+	var t *config.AppToken
+	if hint == "refresh_token" {
+		t = config.GetAppTokenByRefreshToken(*appCtx, h)
+	}
+	if t == nil {
+		t = config.GetAppTokenByAccessToken(*appCtx, h)
+	}
+	if t == nil && hint != "refresh_token" {
+		t = config.GetAppTokenByRefreshToken(*appCtx, h)
+	}
+
+	//revoking the issued token
+	if t != nil {
+		if t.AppID != app.ID {
+			appCtx.Log.Warn("app", app.ID, "tried to revoke the token", t.ID, "of the app", t.AppID)
+			return nil
+		}
+		if err := t.Revoke(*appCtx); err != nil && err != config.ErrAppTokenNotActive {
+			return err
+		}
+		withdraw(appCtx, t.AccessTokenHash)
+		appCtx.Log.Info("app", app.ID, "revoked the token", t.ID)
+		return nil
+	}
+
+	//revoking the long lived access token
+	if len(app.AccessToken) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(app.AccessToken)) != 1 {
+		return nil
+	}
+	if app.IsMasterApp {
+		return &Error{Code: ErrorUnsupportedTokenType, Description: "Token of the master app can't be revoked"}
+	}
+	old := app.ToApp()
+	app.AccessToken = ""
+	if err := app.UpdateAccessToken(*appCtx); err != nil {
+		return err
+	}
+	go old.InformAuth(*appCtx, false)
+	appCtx.Log.Info("app", app.ID, "revoked its long lived access token")
+	return nil
+}
+
+//ClientCredentials issues a short lived access token to the app itself for the scope. No refresh token is
+//issued since the app can authenticate again. The master app can't get the tokens as it has the privileges of
+//the platform
+func ClientCredentials(appCtx *config.AppContext, app *config.AppInfo, scope string) (*Token, error) {
+	if app.IsMasterApp {
+		return nil, &Error{Code: ErrorUnauthorizedClient, Description: "Master app can't use the client credentials grant"}
+	}
+	scope, err := ParseScope(scope)
+	if err != nil {
+		return nil, err
+	}
+	return issue(appCtx, app, appUser(app, scope), 0, 0)
+}
+
+//appUser returns the user informed to the platform services for the token issued to the app itself. The token
+//gets only the privileges of a registered app
+func appUser(app *config.AppInfo, scope string) config.User {
+	u := app.ToApp().ToUser()
+	u.UserType = config.RegisteredApp
+	u.AppID = app.ID
+	u.Scope = scope
+	return u
+}
+
+//delegatedUser returns the user informed to the platform services for the token issued to the app on behalf of
+//the user. The apps get the privileges of a registered app for the user. The email is shared only if consented
+func delegatedUser(app *config.AppInfo, info *config.UserInfo, scope string) config.User {
+	u := config.User{
+		ID:        info.ID,
+		UID:       app.UID,
+		AuthAgent: config.CuttleAI,
+		UserType:  config.RegisteredApp,
+		AppID:     app.ID,
+		Scope:     scope,
+	}
+	if ScopeCovers(scope, "email") {
+		u.Email = info.Email
+	}
+	return u
+}
+
+//issue issues the tokens to the app and informs the access token to the platform services as the given user.
+//userID is the id of the user on whose behalf the tokens are issued. It is zero for the tokens issued to the app
+//itself which don't get a refresh token
+func issue(appCtx *config.AppContext, app *config.AppInfo, u config.User, userID uint, codeID uint) (*Token, error) {
+	now := time.Now()
+	access, err := newAccessToken(u, now, now.Add(AccessTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	t := &config.AppToken{
+		AccessTokenHash: Hash(access),
+		AppID:           app.ID,
+		UserID:          userID,
+		Scope:           u.Scope,
+		CodeID:          codeID,
+		Expires:         now.Add(AccessTokenTTL),
+	}
+	if err := t.SetAccessToken(access); err != nil && err != config.ErrTokenStorageDisabled {
+		return nil, err
+	}
+	refresh := ""
+	if userID != 0 {
+		refresh, err = random(32)
+		if err != nil {
+			return nil, err
+		}
+		t.RefreshTokenHash = Hash(refresh)
+		t.RefreshExpires = now.Add(RefreshTokenTTL)
+	}
+	err = t.Insert(*appCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	//informing the access token to the platform services
+	u.AccessToken = access
+	announce(appCtx, t.AccessTokenHash, u, t.Expires)
+	appCtx.Log.Info("issued the token", t.ID, "to the app", app.ID, "for the user", userID)
+
+	return &Token{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(AccessTokenTTL / time.Second),
+		RefreshToken: refresh,
+		Scope:        u.Scope,
+	}, nil
+}
+
+//announce informs the access token to the platform services till it expires
+func announce(appCtx *config.AppContext, hash string, u config.User, expires time.Time) {
+	live.lock.Lock()
+	live.tokens[hash] = liveToken{user: u, expires: expires}
+	live.lock.Unlock()
+	go u.InformAuth(*appCtx, true)
+}
+
+//withdraw removes the access token from the platform services
+func withdraw(appCtx *config.AppContext, hash string) {
+	live.lock.Lock()
+	t, ok := live.tokens[hash]
+	delete(live.tokens, hash)
+	live.lock.Unlock()
+	if ok {
+		go t.user.InformAuth(*appCtx, false)
+	}
+}
+
+//Restore informs the unexpired access tokens issued before a restart to the platform services again. The tokens
+//can be restored only if the token storage is enabled
+func Restore(appCtx *config.AppContext) {
+	/*
+	 * We will get the active tokens which haven't expired
+	 * Then we will announce them as the user of the app they were issued to
+	 */
+	if config.TokenBox == nil {
+		appCtx.Log.Warn("token storage is disabled. The access tokens issued to the apps before the restart can't be restored")
+		return
+	}
+	tokens, err := config.GetActiveAppTokens(*appCtx, "expires > ?", time.Now())
+	if err != nil {
+		appCtx.Log.Error("error while getting the app tokens to restore", err.Error())
+		return
+	}
+	apps := map[uint]*config.AppInfo{}
+	for _, a := range config.GetAllApps(*appCtx) {
+		a := a
+		apps[a.ID] = &a
+	}
+
+	//announcing the tokens
+	count := 0
+	for _, t := range tokens {
+		app, ok := apps[t.AppID]
+		if !ok {
+			continue
+		}
+		access, err := t.Token()
+		if err != nil {
+			appCtx.Log.Error("error while decrypting the app token", t.ID, err.Error())
+			continue
+		}
+		u := appUser(app, t.Scope)
+		if t.UserID != 0 {
+			info := config.GetUserInfoByID(*appCtx, t.UserID)
+			if info == nil {
+				continue
+			}
+			u = delegatedUser(app, info, t.Scope)
+		}
+		u.AccessToken = access
+		announce(appCtx, t.AccessTokenHash, u, t.Expires)
+		count++
+	}
+	appCtx.Log.Info("restored", count, "access tokens of the apps")
+}
+
+//Sweep removes the expired access tokens from the platform services
+func Sweep(appCtx *config.AppContext) {
+	expired := []string{}
+	live.lock.Lock()
+	for k, v := range live.tokens {
+		if v.expires.Before(time.Now()) {
+			expired = append(expired, k)
+		}
+	}
+	live.lock.Unlock()
+	for _, k := range expired {
+		withdraw(appCtx, k)
+	}
+}
+
+//StartSweeper removes the expired access tokens and rotates the signing keys in the background at the sweep
+//interval. The signing keys have to be loaded with RotateKeys before. It returns the function to stop the sweeper
+func StartSweeper(appCtx *config.AppContext) func() {
+	ticker := time.NewTicker(SweepInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				Sweep(appCtx)
+				if err := RotateKeys(appCtx); err != nil {
+					appCtx.Log.Error("error while rotating the signing keys", err.Error())
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+//Introspect returns the introspection of the session token, app token or the access token issued by the
+//authorization server. The token is looked up among the users authenticated in the platform
+func Introspect(token string) Introspection {
+	u, ok := config.LookupAccessToken(token)
+	if !ok {
+		return Introspection{}
+	}
+	result := Introspection{
+		Active:    true,
+		Subject:   strconv.FormatUint(uint64(u.ID), 10),
+		UserType:  u.UserType,
+		Email:     u.Email,
+		Scope:     u.Scope,
+		AppID:     u.AppID,
+		TokenType: "Bearer",
+	}
+	if u.AppID != 0 {
+		result.ClientID = u.UID.String()
+	}
+
+	//the access tokens issued by the authorization server expire
+	live.lock.Lock()
+	t, ok := live.tokens[Hash(token)]
+	live.lock.Unlock()
+	if ok {
+		if t.expires.Before(time.Now()) {
+			return Introspection{}
+		}
+		result.Expires = t.expires.Unix()
+	}
+	return result
+}
+
+//contains tells whether the value is in the list
+func contains(list []string, v string) bool {
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
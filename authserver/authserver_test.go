@@ -0,0 +1,330 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package authserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/log"
+	"github.com/cuttle-ai/auth-service/secretbox"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+/*
+ * This file contains the tests of the redirect uris, scopes, authorization codes and the tokens of the
+ * authorization server. The config is loaded with SKIP_VAULT=true and a DISCOVERY_TOKEN. The records are
+ * stored in an in-memory sqlite database
+ */
+
+//redirectURI is the redirect uri registered by the app of the tests
+const redirectURI = "https://app.example.com/callback"
+
+//newTestContext returns an app context with an in-memory database, the signing keys loaded and an app and a
+//user registered
+func newTestContext(t *testing.T) (*config.AppContext, *config.AppInfo, *config.UserInfo) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every connection opens a new in-memory database
+	db.DB().SetMaxOpenConns(1)
+	db.AutoMigrate(&config.UserInfo{}, &config.AppInfo{}, &config.AuthorizationCode{}, &config.AppToken{}, &config.SigningKey{})
+	appCtx := &config.AppContext{Db: db, Log: log.NewLogger(0)}
+
+	if config.SigningKeyBox == nil {
+		config.SigningKeyBox, err = secretbox.Parse(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RotateKeys(appCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &config.AppInfo{UID: uuid.New(), Name: "test", RedirectURIs: redirectURI}
+	if err := app.Insert(*appCtx); err != nil {
+		t.Fatal(err)
+	}
+	info := &config.UserInfo{Name: "John Doe", Email: "jdoe@example.com", EmailVerified: true}
+	if err := info.Insert(*appCtx); err != nil {
+		t.Fatal(err)
+	}
+	return appCtx, app, info
+}
+
+//errorCode returns the oauth2 error code of the error
+func errorCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+//exchange issues a code for the scope and exchanges it for the tokens
+func exchange(t *testing.T, appCtx *config.AppContext, app *config.AppInfo, info *config.UserInfo, scope string) *Token {
+	code, err := NewCode(appCtx, app, info.ID, redirectURI, true, scope, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := ExchangeCode(appCtx, app, code, redirectURI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+//revoked tells whether the access token has been revoked
+func revoked(t *testing.T, appCtx *config.AppContext, accessToken string) bool {
+	tok := config.GetAppTokenByAccessToken(*appCtx, Hash(accessToken))
+	if tok == nil {
+		t.Fatal("token not found")
+	}
+	return tok.RevokedAt != nil
+}
+
+func TestSafeRedirectURI(t *testing.T) {
+	old := CustomSchemes
+	defer func() { CustomSchemes = old }()
+	//a script scheme has to be rejected even if it is configured
+	CustomSchemes = []string{"com.example.app", "javascript"}
+
+	cases := []struct {
+		uri  string
+		safe bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"https://app.example.com/callback?tenant=1", true},
+		{"http://localhost:8080/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"http://[::1]:3000/callback", true},
+		{"com.example.app:/callback", true},
+		{"http://app.example.com/callback", false},
+		{"http://localhost.example.com/callback", false},
+		{"https:///callback", false},
+		{"/callback", false},
+		{"", false},
+		{"https://app.example.com/callback#token", false},
+		{"https://app.example.com/callback#", false},
+		{" https://app.example.com/callback", false},
+		{"https://app.example.com/call back", false},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"vbscript:msgbox(1)", false},
+		{"org.example.other:/callback", false},
+	}
+	for _, c := range cases {
+		if got := SafeRedirectURI(c.uri); got != c.safe {
+			t.Errorf("SafeRedirectURI(%q) = %v, expected %v", c.uri, got, c.safe)
+		}
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	app := &config.AppInfo{RedirectURIs: redirectURI + " javascript:alert(1) http://app.example.com/callback"}
+	cases := []struct {
+		uri   string
+		valid bool
+	}{
+		{redirectURI, true},
+		{redirectURI + "/", false},
+		{redirectURI + "?a=1", false},
+		{"https://other.example.com/callback", false},
+		{"", false},
+		//registered before the schemes were restricted
+		{"javascript:alert(1)", false},
+		{"http://app.example.com/callback", false},
+	}
+	for _, c := range cases {
+		if got := ValidRedirectURI(app, c.uri); got != c.valid {
+			t.Errorf("ValidRedirectURI(%q) = %v, expected %v", c.uri, got, c.valid)
+		}
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	cases := []struct {
+		scope    string
+		expected string
+		code     string
+	}{
+		{"", DefaultScope, ""},
+		{"email profile", "email profile", ""},
+		{"profile profile", "profile", ""},
+		{"profile admin", "", ErrorInvalidScope},
+	}
+	for _, c := range cases {
+		got, err := ParseScope(c.scope)
+		if errorCode(err) != c.code || got != c.expected {
+			t.Errorf("ParseScope(%q) = %q, %q, expected %q, %q", c.scope, got, errorCode(err), c.expected, c.code)
+		}
+	}
+}
+
+func TestScopeCovers(t *testing.T) {
+	cases := []struct {
+		granted   string
+		requested string
+		covers    bool
+	}{
+		{"profile email", "email", true},
+		{"profile email", "email profile", true},
+		{"profile", "profile email", false},
+		{"", "profile", false},
+		{"profile", "", true},
+	}
+	for _, c := range cases {
+		if got := ScopeCovers(c.granted, c.requested); got != c.covers {
+			t.Errorf("ScopeCovers(%q, %q) = %v, expected %v", c.granted, c.requested, got, c.covers)
+		}
+	}
+}
+
+func TestExchangeCodePKCE(t *testing.T) {
+	appCtx, app, info := newTestContext(t)
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	cases := []struct {
+		verifier string
+		code     string
+	}{
+		{verifier, ""},
+		{"", ErrorInvalidGrant},
+		{verifier + "x", ErrorInvalidGrant},
+		{challenge, ErrorInvalidGrant},
+	}
+	for _, c := range cases {
+		code, err := NewCode(appCtx, app, info.ID, redirectURI, true, "profile", challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ExchangeCode(appCtx, app, code, redirectURI, c.verifier)
+		if errorCode(err) != c.code {
+			t.Errorf("exchange with the verifier %q gave %q, expected %q", c.verifier, errorCode(err), c.code)
+		}
+	}
+}
+
+func TestExchangeCodeRedirectURI(t *testing.T) {
+	appCtx, app, info := newTestContext(t)
+	cases := []struct {
+		supplied bool
+		given    string
+		code     string
+	}{
+		{true, redirectURI, ""},
+		{true, "", ErrorInvalidGrant},
+		{true, "https://other.example.com/callback", ErrorInvalidGrant},
+		//the authorization request used the only registered uri
+		{false, "", ""},
+		{false, redirectURI, ""},
+		{false, "https://other.example.com/callback", ErrorInvalidGrant},
+	}
+	for _, c := range cases {
+		code, err := NewCode(appCtx, app, info.ID, redirectURI, c.supplied, "profile", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ExchangeCode(appCtx, app, code, c.given, "")
+		if errorCode(err) != c.code {
+			t.Errorf("exchange with the redirect uri %q supplied %v gave %q, expected %q", c.given, c.supplied, errorCode(err), c.code)
+		}
+	}
+}
+
+func TestExchangeCodeReuse(t *testing.T) {
+	appCtx, app, info := newTestContext(t)
+	code, err := NewCode(appCtx, app, info.ID, redirectURI, true, "profile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := ExchangeCode(appCtx, app, code, redirectURI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := Refresh(appCtx, app, tok.RefreshToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the code used again has to revoke the tokens issued from it
+	if _, err := ExchangeCode(appCtx, app, code, redirectURI, ""); errorCode(err) != ErrorInvalidGrant {
+		t.Fatalf("code used again gave %q, expected %q", errorCode(err), ErrorInvalidGrant)
+	}
+	if !revoked(t, appCtx, refreshed.AccessToken) {
+		t.Error("token refreshed from the code was not revoked on the code reuse")
+	}
+	if _, err := Refresh(appCtx, app, refreshed.RefreshToken, ""); errorCode(err) != ErrorInvalidGrant {
+		t.Errorf("refresh after the code reuse gave %q, expected %q", errorCode(err), ErrorInvalidGrant)
+	}
+
+	//the code of another app isn't accepted
+	other := &config.AppInfo{UID: uuid.New(), Name: "other", RedirectURIs: redirectURI}
+	if err := other.Insert(*appCtx); err != nil {
+		t.Fatal(err)
+	}
+	code, err = NewCode(appCtx, app, info.ID, redirectURI, true, "profile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExchangeCode(appCtx, other, code, redirectURI, ""); errorCode(err) != ErrorInvalidGrant {
+		t.Errorf("code of another app gave %q, expected %q", errorCode(err), ErrorInvalidGrant)
+	}
+}
+
+func TestRefreshReuse(t *testing.T) {
+	appCtx, app, info := newTestContext(t)
+	first := exchange(t, appCtx, app, info, "profile")
+	second, err := Refresh(appCtx, app, first.RefreshToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked(t, appCtx, first.AccessToken) {
+		t.Error("refreshed token was not revoked")
+	}
+
+	//the old refresh token used again has to revoke the grant
+	if _, err := Refresh(appCtx, app, first.RefreshToken, ""); errorCode(err) != ErrorInvalidGrant {
+		t.Fatalf("refresh token used again gave %q, expected %q", errorCode(err), ErrorInvalidGrant)
+	}
+	if !revoked(t, appCtx, second.AccessToken) {
+		t.Error("token of the grant was not revoked on the refresh token reuse")
+	}
+	if _, err := Refresh(appCtx, app, second.RefreshToken, ""); errorCode(err) != ErrorInvalidGrant {
+		t.Errorf("refresh after the reuse gave %q, expected %q", errorCode(err), ErrorInvalidGrant)
+	}
+}
+
+func TestRefreshScope(t *testing.T) {
+	appCtx, app, info := newTestContext(t)
+	tok := exchange(t, appCtx, app, info, "profile email")
+
+	//narrowing down the scope
+	narrowed, err := Refresh(appCtx, app, tok.RefreshToken, "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if narrowed.Scope != "email" {
+		t.Errorf("expected the scope email, got %q", narrowed.Scope)
+	}
+
+	//the scope can't be widened again
+	if _, err := Refresh(appCtx, app, narrowed.RefreshToken, "profile email"); errorCode(err) != ErrorInvalidScope {
+		t.Errorf("widened scope gave %q, expected %q", errorCode(err), ErrorInvalidScope)
+	}
+	if _, err := Refresh(appCtx, app, narrowed.RefreshToken, "admin"); errorCode(err) != ErrorInvalidScope {
+		t.Errorf("unsupported scope gave %q, expected %q", errorCode(err), ErrorInvalidScope)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package authserver has the oauth2 authorization server with which the registered apps get the tokens to act on
+//behalf of the users. The users consent with their existing login session and the apps exchange the authorization
+//codes for the tokens
+package authserver
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the authorization server
+ */
+
+const (
+	//EnabledKey is the key storing whether the authorization server is enabled
+	EnabledKey = "OAUTH_SERVER_ENABLED"
+	//CodeTTLKey is the key storing the time in milliseconds for which an authorization code is valid
+	CodeTTLKey = "OAUTH_SERVER_CODE_TTL"
+	//AccessTokenTTLKey is the key storing the time in milliseconds for which an access token is valid
+	AccessTokenTTLKey = "OAUTH_SERVER_ACCESS_TOKEN_TTL"
+	//RefreshTokenTTLKey is the key storing the time in milliseconds for which a refresh token is valid
+	RefreshTokenTTLKey = "OAUTH_SERVER_REFRESH_TOKEN_TTL"
+	//KeyRotationIntervalKey is the key storing the time in milliseconds after which a new key signs the access tokens
+	KeyRotationIntervalKey = "OAUTH_SERVER_KEY_ROTATION_INTERVAL"
+	//ScopesKey is the key storing the comma separated scopes the apps can request
+	ScopesKey = "OAUTH_SERVER_SCOPES"
+	//DefaultScopeKey is the key storing the space separated scope granted when the app doesn't request one
+	DefaultScopeKey = "OAUTH_SERVER_DEFAULT_SCOPE"
+	//LoginURLKey is the key storing the url of the login page to which the users without a session are sent
+	LoginURLKey = "OAUTH_SERVER_LOGIN_URL"
+	//IssuerKey is the key storing the public base url of the auth service
+	IssuerKey = "OAUTH_SERVER_ISSUER"
+	//CustomSchemesKey is the key storing the comma separated custom uri schemes the apps can use in the redirect uris
+	CustomSchemesKey = "OAUTH_SERVER_CUSTOM_SCHEMES"
+)
+
+var (
+	//Enabled indicates that the authorization server is enabled. The issuer is required then
+	Enabled = false
+	//CodeTTL is the duration for which an authorization code is valid
+	CodeTTL = time.Duration(time.Minute)
+	//AccessTokenTTL is the duration for which an access token is valid
+	AccessTokenTTL = time.Duration(time.Hour)
+	//RefreshTokenTTL is the duration for which a refresh token is valid
+	RefreshTokenTTL = time.Duration(30 * 24 * time.Hour)
+	//SessionTTL is the duration for which the token of a login session is valid
+	SessionTTL = time.Duration(24 * time.Hour)
+	//KeyRotationInterval is the duration after which a new key signs the tokens. The old key is published
+	//till the tokens signed by it expire
+	KeyRotationInterval = time.Duration(30 * 24 * time.Hour)
+	//SweepInterval is the interval at which the expired access tokens are removed from the platform
+	SweepInterval = time.Duration(time.Minute)
+	//Scopes are the scopes the apps can request
+	Scopes = []string{"profile", "email"}
+	//DefaultScope is the scope granted when the app doesn't request one
+	DefaultScope = "profile"
+	//LoginURL is the url of the login page to which the users without a session are sent with the authorization
+	//request as the return_to query param. Default is the origin of the frontend url
+	LoginURL = ""
+	//Issuer is the public base url of the auth service. It is required if the authorization server is enabled since
+	//it is the issuer of the access tokens and the url to which the users return after the login
+	Issuer = ""
+	//CustomSchemes are the custom uri schemes of the native apps allowed in the redirect uris besides https and
+	//http on the loopback hosts
+	CustomSchemes = []string{}
+)
+
+func init() {
+	for k, v := range map[string]*time.Duration{CodeTTLKey: &CodeTTL, AccessTokenTTLKey: &AccessTokenTTL, RefreshTokenTTLKey: &RefreshTokenTTL, KeyRotationIntervalKey: &KeyRotationInterval} {
+		if t, err := strconv.ParseInt(os.Getenv(k), 10, 64); err == nil && t > 0 {
+			*v = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+	if len(os.Getenv(ScopesKey)) != 0 {
+		Scopes = []string{}
+		for _, s := range strings.Split(os.Getenv(ScopesKey), ",") {
+			if s = strings.TrimSpace(s); len(s) != 0 {
+				Scopes = append(Scopes, s)
+			}
+		}
+	}
+	if len(os.Getenv(DefaultScopeKey)) != 0 {
+		DefaultScope = os.Getenv(DefaultScopeKey)
+	}
+	for _, s := range strings.Split(os.Getenv(CustomSchemesKey), ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); len(s) != 0 {
+			CustomSchemes = append(CustomSchemes, s)
+		}
+	}
+	LoginURL = os.Getenv(LoginURLKey)
+	Issuer = strings.TrimRight(os.Getenv(IssuerKey), "/")
+	Enabled = os.Getenv(EnabledKey) == "true"
+}
+
+//CheckConfig validates the configuration required by the authorization server. It has to be called before serving
+//the requests if the authorization server is enabled
+func CheckConfig() error {
+	if u, err := url.Parse(Issuer); err != nil || !u.IsAbs() || len(u.Host) == 0 {
+		return errors.New("Authorization server issuer " + IssuerKey + " is not set or is not an absolute url")
+	}
+	return nil
+}
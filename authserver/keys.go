@@ -0,0 +1,168 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package authserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/jwt"
+)
+
+/*
+ * This file contains the keys signing the session tokens and the access tokens so that the services can validate
+ * them offline with the published key set. The keys are stored in the database encrypted with the signing key
+ * encryption keys
+ */
+
+//signingKeySize is the size in bits of the rsa signing keys
+const signingKeySize = 2048
+
+//keys has the key signing the access tokens and the key set published for verifying them
+var keys = struct {
+	kid     string
+	signing *rsa.PrivateKey
+	set     jwt.JSONWebKeySet
+	lock    sync.RWMutex
+}{set: jwt.JSONWebKeySet{Keys: []jwt.JSONWebKey{}}}
+
+//RotateKeys loads the signing keys from the database and generates a new one if none of them can sign anymore.
+//The expired keys are deleted. It fails if the signing key encryption keys are not configured
+func RotateKeys(appCtx *config.AppContext) error {
+	/*
+	 * We will load the keys not expired
+	 * If none of them can sign, we will generate a new one
+	 * We will delete the expired keys
+	 * Then we will publish the keys and pick the newest one for signing
+	 */
+	if config.SigningKeyBox == nil {
+		return config.ErrSigningKeysDisabled
+	}
+	now := time.Now()
+	stored, err := config.GetSigningKeys(*appCtx, now)
+	if err != nil {
+		return err
+	}
+
+	//generating a new key
+	if len(stored) == 0 || !stored[0].RetiresAt.After(now) {
+		k, err := newSigningKey(appCtx, now)
+		if err != nil {
+			return err
+		}
+		stored = append([]config.SigningKey{*k}, stored...)
+	}
+
+	//deleting the expired keys
+	if err := config.DeleteExpiredSigningKeys(*appCtx, now); err != nil {
+		appCtx.Log.Error("error while deleting the expired signing keys", err.Error())
+	}
+
+	//publishing the keys
+	set := jwt.JSONWebKeySet{Keys: []jwt.JSONWebKey{}}
+	var signing *rsa.PrivateKey
+	kid := ""
+	for _, s := range stored {
+		k, err := s.Key()
+		if err != nil {
+			appCtx.Log.Error("error while opening the signing key", s.Kid, err.Error())
+			continue
+		}
+		if signing == nil && s.RetiresAt.After(now) {
+			signing, kid = k, s.Kid
+		}
+		set.Keys = append(set.Keys, jwt.NewRSAJSONWebKey(s.Kid, &k.PublicKey))
+	}
+	keys.lock.Lock()
+	keys.signing, keys.kid, keys.set = signing, kid, set
+	keys.lock.Unlock()
+	return nil
+}
+
+//newSigningKey generates a signing key and stores it. The key signs for the rotation interval and is published
+//till the tokens signed by it expire
+func newSigningKey(appCtx *config.AppContext, now time.Time) (*config.SigningKey, error) {
+	k, err := rsa.GenerateKey(rand.Reader, signingKeySize)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := random(16)
+	if err != nil {
+		return nil, err
+	}
+	ttl := AccessTokenTTL
+	if SessionTTL > ttl {
+		ttl = SessionTTL
+	}
+	s := &config.SigningKey{
+		Kid:       kid,
+		RetiresAt: now.Add(KeyRotationInterval),
+		Expires:   now.Add(KeyRotationInterval + ttl + SweepInterval),
+	}
+	err = s.SetPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Insert(*appCtx)
+	if err != nil {
+		return nil, err
+	}
+	appCtx.Log.Info("generated the signing key", kid)
+	return s, nil
+}
+
+//JWKS returns the key set with which the access tokens can be verified
+func JWKS() jwt.JSONWebKeySet {
+	keys.lock.RLock()
+	defer keys.lock.RUnlock()
+	return jwt.JSONWebKeySet{Keys: append([]jwt.JSONWebKey{}, keys.set.Keys...)}
+}
+
+//SessionToken returns the token of the user's login session signed as a jwt. It is valid for the session ttl
+func SessionToken(u config.User) (string, error) {
+	now := time.Now()
+	return newAccessToken(u, now, now.Add(SessionTTL))
+}
+
+//newAccessToken returns an access token for the user signed as a jwt having the user id, user type, app id and the
+//scope. It fails if the signing key couldn't be loaded
+func newAccessToken(u config.User, now time.Time, expires time.Time) (string, error) {
+	keys.lock.RLock()
+	key, kid := keys.signing, keys.kid
+	keys.lock.RUnlock()
+	if key == nil {
+		return "", errors.New("No signing key is available to sign the access token")
+	}
+	jti, err := random(16)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.Claims{
+		"sub":       strconv.FormatUint(uint64(u.ID), 10),
+		"user_type": u.UserType,
+		"app_id":    u.AppID,
+		"iat":       now.Unix(),
+		"exp":       expires.Unix(),
+		"jti":       jti,
+	}
+	if len(Issuer) != 0 {
+		claims["iss"] = Issuer
+	}
+	if u.AppID != 0 {
+		claims["client_id"] = u.UID.String()
+	}
+	if len(u.Scope) != 0 {
+		claims["scope"] = u.Scope
+	}
+	if len(u.Email) != 0 {
+		claims["email"] = u.Email
+	}
+	return jwt.Sign(claims, kid, key)
+}
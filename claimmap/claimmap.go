@@ -0,0 +1,261 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package claimmap has the declarative mapping of the claims/attributes given by the auth agents to the user info.
+//
+//A mapping is a list of rules separated by ;. Each rule maps a field to an expression.
+//	field[!|?]=alternative[,alternative...][|transform...]
+//The field is suffixed with ! if it is required and with ? if it is optional. Fields are optional by default.
+//An alternative is one or more json paths joined with +. The values of the paths are joined with a space.
+//The first alternative with a non empty value is used. Paths are dot separated and array elements are
+//addressed with their index. Eg. emails.0.value
+//Transforms are applied in order. Supported transforms are lower, upper, trim and default(value).
+//Eg.
+//	email!=email,upn|lower;name=given_name+family_name,name;department=org.department|default(none)
+package claimmap
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+ * This file contains the claim mapping language
+ */
+
+//Transform is a transform applied to the mapped value
+type Transform struct {
+	//Name of the transform
+	Name string
+	//Arg is the argument of the transform. Eg. the value of default
+	Arg string
+}
+
+//Rule maps a field to the claims
+type Rule struct {
+	//Field is the name of the field mapped
+	Field string
+	//Alternatives are the paths tried in order. The values of the paths in an alternative are joined
+	Alternatives [][]string
+	//Transforms are applied in order to the value of the first non empty alternative
+	Transforms []Transform
+	//Required fails the mapping if the value is empty
+	Required bool
+}
+
+//Map is a claim mapping
+type Map struct {
+	//Rules of the mapping
+	Rules []Rule
+}
+
+//Error is the error in mapping a field
+type Error struct {
+	//Field which couldn't be mapped
+	Field string
+	//Path at which the error occured. It is empty for missing required fields
+	Path string
+	//Reason of the error
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if len(e.Path) == 0 {
+		return "Couldn't map " + e.Field + ": " + e.Reason
+	}
+	return "Couldn't map " + e.Field + " from " + e.Path + ": " + e.Reason
+}
+
+//transforms has the supported transforms
+var transforms = map[string]func(v string, arg string) string{
+	"lower": func(v string, arg string) string {
+		return strings.ToLower(v)
+	},
+	"upper": func(v string, arg string) string {
+		return strings.ToUpper(v)
+	},
+	"trim": func(v string, arg string) string {
+		return strings.TrimSpace(v)
+	},
+	"default": func(v string, arg string) string {
+		if len(v) == 0 {
+			return arg
+		}
+		return v
+	},
+}
+
+//Parse parses the claim mapping
+func Parse(spec string) (*Map, error) {
+	m := &Map{}
+	fields := map[string]bool{}
+	for _, r := range strings.Split(spec, ";") {
+		r = strings.TrimSpace(r)
+		if len(r) == 0 {
+			continue
+		}
+		rule, err := parseRule(r)
+		if err != nil {
+			return nil, err
+		}
+		if fields[rule.Field] {
+			return nil, errors.New("Field " + rule.Field + " is mapped twice")
+		}
+		fields[rule.Field] = true
+		m.Rules = append(m.Rules, rule)
+	}
+	return m, nil
+}
+
+//parseRule parses a rule of the mapping
+func parseRule(r string) (Rule, error) {
+	/*
+	 * We will split the field and the expression
+	 * We will get the required flag from the field
+	 * Then we will parse the alternatives
+	 * Then the transforms
+	 */
+	rule := Rule{}
+	i := strings.Index(r, "=")
+	if i <= 0 {
+		return rule, errors.New("Invalid claim mapping rule " + r)
+	}
+	rule.Field = strings.TrimSpace(r[:i])
+	expr := strings.Split(r[i+1:], "|")
+
+	//getting the required flag
+	if strings.HasSuffix(rule.Field, "!") {
+		rule.Required = true
+	}
+	rule.Field = strings.TrimSpace(strings.TrimRight(rule.Field, "!?"))
+	if len(rule.Field) == 0 {
+		return rule, errors.New("Field not found in the claim mapping rule " + r)
+	}
+
+	//parsing the alternatives
+	for _, alt := range strings.Split(expr[0], ",") {
+		paths := []string{}
+		for _, p := range strings.Split(alt, "+") {
+			if p = strings.TrimSpace(p); len(p) == 0 {
+				return rule, errors.New("Empty path in the claim mapping rule " + r)
+			}
+			paths = append(paths, p)
+		}
+		rule.Alternatives = append(rule.Alternatives, paths)
+	}
+
+	//parsing the transforms
+	for _, t := range expr[1:] {
+		t = strings.TrimSpace(t)
+		tr := Transform{Name: t}
+		if i := strings.Index(t, "("); i > 0 && strings.HasSuffix(t, ")") {
+			tr = Transform{Name: t[:i], Arg: t[i+1 : len(t)-1]}
+		}
+		if _, ok := transforms[tr.Name]; !ok {
+			return rule, errors.New("Unknown transform " + t + " in the claim mapping rule " + r)
+		}
+		rule.Transforms = append(rule.Transforms, tr)
+	}
+	return rule, nil
+}
+
+//Apply maps the claims and returns the values of the mapped fields. Fields with empty values are left out
+func (m *Map) Apply(claims map[string]interface{}) (map[string]string, error) {
+	result := map[string]string{}
+	for _, r := range m.Rules {
+		v, err := r.value(claims)
+		if err != nil {
+			return nil, err
+		}
+		if len(v) == 0 && r.Required {
+			return nil, &Error{Field: r.Field, Reason: "required value not found"}
+		}
+		if len(v) != 0 {
+			result[r.Field] = v
+		}
+	}
+	return result, nil
+}
+
+//value returns the transformed value of the first non empty alternative of the rule
+func (r Rule) value(claims map[string]interface{}) (string, error) {
+	v := ""
+	for _, alt := range r.Alternatives {
+		parts := []string{}
+		for _, p := range alt {
+			s, err := lookup(claims, p)
+			if err != nil {
+				return "", &Error{Field: r.Field, Path: p, Reason: err.Error()}
+			}
+			if len(s) != 0 {
+				parts = append(parts, s)
+			}
+		}
+		if v = strings.Join(parts, " "); len(v) != 0 {
+			break
+		}
+	}
+	for _, t := range r.Transforms {
+		v = transforms[t.Name](v, t.Arg)
+	}
+	return v, nil
+}
+
+//lookup returns the string value at the given path of the claims. Missing paths give an empty value.
+//Numbers and booleans are formatted while the objects and the arrays are reported as errors
+func lookup(claims map[string]interface{}, path string) (string, error) {
+	v, err := find(claims, path)
+	if err != nil {
+		return "", err
+	}
+	switch c := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return c, nil
+	case bool:
+		return strconv.FormatBool(c), nil
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(c), nil
+	case int64:
+		return strconv.FormatInt(c, 10), nil
+	}
+	return "", fmt.Errorf("value of type %T is not a string", v)
+}
+
+//find returns the value at the given path. A key having dots like the saml attribute urls
+//is matched as a whole before the path is split
+func find(v interface{}, path string) (interface{}, error) {
+	key, rest := path, ""
+	if i := strings.Index(path, "."); i >= 0 {
+		key, rest = path[:i], path[i+1:]
+	}
+	switch c := v.(type) {
+	case map[string]interface{}:
+		if w, ok := c[path]; ok {
+			return w, nil
+		}
+		if len(rest) == 0 {
+			return nil, nil
+		}
+		return find(c[key], rest)
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an index of the array", key)
+		}
+		if i < 0 || i >= len(c) {
+			return nil, nil
+		}
+		if len(rest) == 0 {
+			return c[i], nil
+		}
+		return find(c[i], rest)
+	}
+	return nil, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package claimmap
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+/*
+ * This file contains the tests of the claim mapping language
+ */
+
+//claims returns the claims parsed from the json like the agents get them
+func claims(t *testing.T, s string) map[string]interface{} {
+	c := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestApply(t *testing.T) {
+	m, err := Parse("email!=mail,upn|trim|lower; name=given_name+family_name,login; id=id; dept=org.units.1.name|default(none); team?=team|upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := m.Apply(claims(t, `{
+		"upn": " John.Doe@Example.com ",
+		"given_name": "John",
+		"family_name": "Doe",
+		"id": 1234567,
+		"org": {"units": [{"name": "eng"}, {"name": "platform"}]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"email": "john.doe@example.com",
+		"name":  "John Doe",
+		"id":    "1234567",
+		"dept":  "platform",
+	}
+	if len(result) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+	for k, v := range expected {
+		if result[k] != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, result[k])
+		}
+	}
+}
+
+func TestApplyDefault(t *testing.T) {
+	m, err := Parse("dept=org.dept|default(none)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := m.Apply(claims(t, `{"org": "flat"}`))
+	if err != nil || result["dept"] != "none" {
+		t.Errorf("expected the default, got %v %v", result, err)
+	}
+}
+
+func TestApplyDottedKey(t *testing.T) {
+	m, err := Parse("email!=http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := m.Apply(map[string]interface{}{
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress": "jdoe@example.com",
+	})
+	if err != nil || result["email"] != "jdoe@example.com" {
+		t.Errorf("expected the email, got %v %v", result, err)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	cases := []struct {
+		spec   string
+		claims string
+	}{
+		{"email!=email", `{"name": "John"}`},
+		{"email!=email", `{"email": ""}`},
+		{"name=name", `{"name": {"first": "John"}}`},
+		{"name=names", `{"names": ["John"]}`},
+		{"name=names.first", `{"names": ["John"]}`},
+	}
+	for _, c := range cases {
+		m, err := Parse(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.Apply(claims(t, c.claims))
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("%s with %s: expected a mapping error, got %v", c.spec, c.claims, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"email",
+		"=email",
+		"!=email",
+		"email=",
+		"name=given_name+",
+		"email=email|reverse",
+		"email=email;email=mail",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected %q to be invalid", spec)
+		}
+	}
+}
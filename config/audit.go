@@ -0,0 +1,68 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import "github.com/jinzhu/gorm"
+
+/*
+ * This file contains the model of the audit trail of the users
+ */
+
+//Audit events recorded against the users
+const (
+	//AuditRecoveryCodesGenerated is recorded when a new batch of recovery codes is generated
+	AuditRecoveryCodesGenerated = "RecoveryCodesGenerated"
+	//AuditRecoveryCodeUsed is recorded when a recovery code is used to log in
+	AuditRecoveryCodeUsed = "RecoveryCodeUsed"
+	//AuditIdentityLinked is recorded when an identity is linked to the user
+	AuditIdentityLinked = "IdentityLinked"
+	//AuditIdentityUnlinked is recorded when an identity is unlinked from the user
+	AuditIdentityUnlinked = "IdentityUnlinked"
+	//AuditGrantRevoked is recorded when the grant given by the user to the platform is revoked at the auth agent
+	AuditGrantRevoked = "GrantRevoked"
+	//AuditGrantRevokeFailed is recorded when the grant given by the user couldn't be revoked at the auth agent
+	AuditGrantRevokeFailed = "GrantRevokeFailed"
+	//AuditForcedSignOut is recorded when an admin signs out the user from all the sessions
+	AuditForcedSignOut = "ForcedSignOut"
+	//AuditAccountDeleted is recorded when the user deletes the account
+	AuditAccountDeleted = "AccountDeleted"
+	//AuditInvitationAccepted is recorded when the user completes the sign up with an invitation
+	AuditInvitationAccepted = "InvitationAccepted"
+	//AuditEmailVerified is recorded when the user verifies the ownership of the email with the code mailed
+	AuditEmailVerified = "EmailVerified"
+)
+
+//AuditEvent is the model storing a security relevant event of a user
+type AuditEvent struct {
+	gorm.Model
+	//UserID is the id of the user info the event is recorded against
+	UserID uint `gorm:"index"`
+	//Event is the type of the event
+	Event string
+	//Detail has the additional info of the event
+	Detail string
+	//IP is the remote address of the request which caused the event
+	IP string
+}
+
+//Insert inserts the audit event record to the database
+func (a *AuditEvent) Insert(ctx AppContext) error {
+	return ctx.Db.Create(a).Error
+}
+
+//Audit records the event against the user. Failures are only logged since the action has already happened
+func (u *UserInfo) Audit(ctx AppContext, event string, detail string, ip string) {
+	a := &AuditEvent{UserID: u.ID, Event: event, Detail: detail, IP: ip}
+	if err := a.Insert(ctx); err != nil {
+		ctx.Log.Error("error while recording the audit event", event, "of", u.ID, err.Error())
+	}
+}
+
+//GetAuditEvents will return the audit events of the user with the latest first
+func (u *UserInfo) GetAuditEvents(ctx AppContext) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	err := ctx.Db.Where("user_id = ?", u.ID).Order("created_at desc").Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,310 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/secretbox"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the models of the authorization server issuing the tokens to the registered apps
+ * on behalf of the users
+ */
+
+var (
+	//ErrCodeNotValid is returned when the authorization code was used or has expired in the meantime
+	ErrCodeNotValid = errors.New("Authorization code is no longer valid")
+	//ErrAppTokenNotActive is returned when the app token was revoked in the meantime
+	ErrAppTokenNotActive = errors.New("Token is no longer active")
+	//ErrSigningKeysDisabled is returned when no key is configured for encrypting the signing keys
+	ErrSigningKeysDisabled = errors.New("Signing key encryption keys are not configured")
+)
+
+//SigningKeyEncryptionKeysKey is the key storing the comma separated base64 encoded 32 byte keys with which the
+//keys signing the tokens are encrypted. The first key encrypts and the rest are kept for decrypting the keys
+//encrypted before a key rotation
+const SigningKeyEncryptionKeysKey = "SIGNING_KEY_ENCRYPTION_KEYS"
+
+//SigningKeyBox encrypts the keys signing the tokens. The tokens can't be signed if it is nil
+var SigningKeyBox *secretbox.Box
+
+func init() {
+	if len(os.Getenv(SigningKeyEncryptionKeysKey)) == 0 {
+		return
+	}
+	b, err := secretbox.Parse(os.Getenv(SigningKeyEncryptionKeysKey))
+	if err != nil {
+		log.Fatal("Error while parsing the signing key encryption keys ", err)
+	}
+	SigningKeyBox = b
+}
+
+//AuthorizationCode is the model storing the authorization code issued to an app for the consenting user.
+//Only the hash of the code is stored
+type AuthorizationCode struct {
+	gorm.Model
+	//CodeHash is the hash of the code
+	CodeHash string `gorm:"unique_index"`
+	//AppID is the id of the app to which the code is issued
+	AppID uint
+	//UserID is the id of the user info who consented
+	UserID uint
+	//RedirectURI is the redirect uri with which the code was requested
+	RedirectURI string
+	//RedirectURISupplied indicates that the app gave the redirect uri in the request instead of using its only
+	//registered uri. The app has to give the same uri while exchanging the code then
+	RedirectURISupplied bool
+	//Scope granted by the user
+	Scope string
+	//CodeChallenge is the pkce code challenge of the request. It is empty if the app didn't use pkce
+	CodeChallenge string
+	//Expires is the time after which the code can't be exchanged
+	Expires time.Time
+	//Used indicates that the code has been exchanged
+	Used bool
+}
+
+//Insert inserts the authorization code record to the database
+func (a *AuthorizationCode) Insert(ctx AppContext) error {
+	return ctx.Db.Create(a).Error
+}
+
+//Consume marks the code as used. It returns ErrCodeNotValid if the code was used or has expired in the meantime
+func (a *AuthorizationCode) Consume(ctx AppContext) error {
+	res := ctx.Db.Model(a).Where("id = ? and used = ? and expires > ?", a.ID, false, time.Now()).Updates(map[string]interface{}{
+		"used": true,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrCodeNotValid
+	}
+	a.Used = true
+	return nil
+}
+
+//GetAuthorizationCode returns the authorization code of the given hash from the database
+//If doesn't exist in the db, the method will return nil
+func GetAuthorizationCode(ctx AppContext, codeHash string) (result *AuthorizationCode) {
+	results := []AuthorizationCode{}
+	ctx.Db.Where("code_hash = ?", codeHash).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//AppToken is the model storing the tokens issued to an app on behalf of a user or to the app itself.
+//Only the hashes of the tokens are stored
+type AppToken struct {
+	gorm.Model
+	//AccessTokenHash is the hash of the access token
+	AccessTokenHash string `gorm:"unique_index"`
+	//AccessToken is the access token encrypted with the token box so that it can be informed to the platform
+	//services again after a restart. It is empty if the token storage is disabled
+	AccessToken string `gorm:"type:text" json:"-"`
+	//RefreshTokenHash is the hash of the refresh token. It is empty for the tokens issued with the client credentials
+	RefreshTokenHash string `gorm:"index"`
+	//AppID is the id of the app to which the token is issued
+	AppID uint `gorm:"index"`
+	//UserID is the id of the user info on whose behalf the token is issued. It is zero for the tokens issued
+	//to the app itself with the client credentials
+	UserID uint `gorm:"index"`
+	//Scope granted by the user
+	Scope string
+	//CodeID is the id of the authorization code from which the token or the token it was refreshed from was issued.
+	//It is zero for the tokens issued with the client credentials
+	CodeID uint `gorm:"index"`
+	//Expires is the time after which the access token can't be used
+	Expires time.Time
+	//RefreshExpires is the time after which the refresh token can't be used
+	RefreshExpires time.Time
+	//RevokedAt is the time at which the token was revoked. It is nil for the active tokens
+	RevokedAt *time.Time
+}
+
+//Insert inserts the app token record to the database
+func (a *AppToken) Insert(ctx AppContext) error {
+	return ctx.Db.Create(a).Error
+}
+
+//SetAccessToken encrypts and sets the access token
+func (a *AppToken) SetAccessToken(token string) error {
+	if TokenBox == nil {
+		return ErrTokenStorageDisabled
+	}
+	sealed, err := TokenBox.Seal(token)
+	if err != nil {
+		return err
+	}
+	a.AccessToken = sealed
+	return nil
+}
+
+//Token returns the decrypted access token
+func (a *AppToken) Token() (string, error) {
+	if TokenBox == nil || len(a.AccessToken) == 0 {
+		return "", ErrTokenStorageDisabled
+	}
+	return TokenBox.Open(a.AccessToken)
+}
+
+//Revoke marks the token as revoked. It returns ErrAppTokenNotActive if the token was revoked in the meantime
+func (a *AppToken) Revoke(ctx AppContext) error {
+	now := time.Now()
+	res := ctx.Db.Model(a).Where("id = ? and revoked_at is null", a.ID).Updates(map[string]interface{}{
+		"revoked_at": &now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAppTokenNotActive
+	}
+	a.RevokedAt = &now
+	return nil
+}
+
+//GetAppTokenByAccessToken returns the app token of the given access token hash from the database
+//If doesn't exist in the db, the method will return nil
+func GetAppTokenByAccessToken(ctx AppContext, accessTokenHash string) (result *AppToken) {
+	results := []AppToken{}
+	ctx.Db.Where("access_token_hash = ?", accessTokenHash).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//GetAppTokenByRefreshToken returns the app token of the given refresh token hash from the database
+//If doesn't exist in the db, the method will return nil
+func GetAppTokenByRefreshToken(ctx AppContext, refreshTokenHash string) (result *AppToken) {
+	results := []AppToken{}
+	ctx.Db.Where("refresh_token_hash = ?", refreshTokenHash).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//GetActiveAppTokens returns the tokens not revoked matching the given query from the database. Eg. "user_id = ?"
+func GetActiveAppTokens(ctx AppContext, query string, args ...interface{}) ([]AppToken, error) {
+	tokens := []AppToken{}
+	err := ctx.Db.Where("revoked_at is null").Where(query, args...).Find(&tokens).Error
+	return tokens, err
+}
+
+//AppGrant is the model storing the consent of a user to an app for the scope
+type AppGrant struct {
+	gorm.Model
+	//AppID is the id of the app to which the user consented
+	AppID uint `gorm:"unique_index:idx_app_grant"`
+	//UserID is the id of the user info who consented
+	UserID uint `gorm:"unique_index:idx_app_grant"`
+	//Scope consented by the user
+	Scope string
+}
+
+//Save inserts or updates the scope of the grant in the database
+func (a *AppGrant) Save(ctx AppContext) error {
+	if a.ID == 0 {
+		return ctx.Db.Create(a).Error
+	}
+	return ctx.Db.Model(a).Where("id = ?", a.ID).Updates(map[string]interface{}{
+		"scope": a.Scope,
+	}).Error
+}
+
+//GetAppGrant returns the grant of the user to the app from the database
+//If doesn't exist in the db, the method will return nil
+func GetAppGrant(ctx AppContext, appID uint, userID uint) (result *AppGrant) {
+	results := []AppGrant{}
+	ctx.Db.Where("app_id = ? and user_id = ?", appID, userID).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//GetAppByUID returns the app of the given uid from the database
+//If doesn't exist in the db, the method will return nil
+func GetAppByUID(ctx AppContext, uid string) (result *AppInfo) {
+	results := []AppInfo{}
+	ctx.Db.Where("uid = ?", uid).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//SigningKey is the model storing the keys with which the tokens are signed. The private key is encrypted
+//with the signing key box. A key signs till it retires and is published for verifying the tokens till it expires
+type SigningKey struct {
+	gorm.Model
+	//Kid is the id of the key given in the header of the tokens
+	Kid string `gorm:"unique_index"`
+	//PrivateKey is the encrypted pem encoded rsa private key
+	PrivateKey string `gorm:"type:text" json:"-"`
+	//RetiresAt is the time after which the key doesn't sign the tokens
+	RetiresAt time.Time
+	//Expires is the time after which the key is not published anymore
+	Expires time.Time `gorm:"index"`
+}
+
+//SetPrivateKey encrypts and sets the private key
+func (s *SigningKey) SetPrivateKey(key *rsa.PrivateKey) error {
+	if SigningKeyBox == nil {
+		return ErrSigningKeysDisabled
+	}
+	sealed, err := SigningKeyBox.Seal(string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})))
+	if err != nil {
+		return err
+	}
+	s.PrivateKey = sealed
+	return nil
+}
+
+//Key returns the decrypted private key
+func (s *SigningKey) Key() (*rsa.PrivateKey, error) {
+	if SigningKeyBox == nil {
+		return nil, ErrSigningKeysDisabled
+	}
+	p, err := SigningKeyBox.Open(s.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := pem.Decode([]byte(p))
+	if b == nil {
+		return nil, errors.New("Signing key is malformed")
+	}
+	return x509.ParsePKCS1PrivateKey(b.Bytes)
+}
+
+//Insert inserts the signing key record to the database
+func (s *SigningKey) Insert(ctx AppContext) error {
+	return ctx.Db.Create(s).Error
+}
+
+//GetSigningKeys returns the signing keys not expired at the given time from the database. The newest key is first
+func GetSigningKeys(ctx AppContext, at time.Time) ([]SigningKey, error) {
+	results := []SigningKey{}
+	err := ctx.Db.Where("expires > ?", at).Order("created_at desc").Find(&results).Error
+	return results, err
+}
+
+//DeleteExpiredSigningKeys deletes the signing keys expired before the given time from the database
+func DeleteExpiredSigningKeys(ctx AppContext, before time.Time) error {
+	return ctx.Db.Unscoped().Where("expires <= ?", before).Delete(&SigningKey{}).Error
+}
@@ -7,6 +7,7 @@ package config
 
 import (
 	"log"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -47,6 +48,30 @@ var (
 	ServiceDomain = "127.0.0.1"
 	//IsAuthService to make sure that the configuration belongs to auth service. This will prevent cross initialization across services
 	IsAuthService = false
+	//LocalAccounts enables the login with email and password of the local accounts
+	LocalAccounts = false
+	//LocalSignup enables the signup of new local accounts. It is effective only if the local accounts are enabled
+	LocalSignup = false
+	//MFARequiredUserTypes are the user types who have to verify with a second factor after logging in
+	MFARequiredUserTypes = []string{AdminUser, SuperAdmin}
+	//MFAIssuer is the issuer shown in the authenticator apps for the totp secrets
+	MFAIssuer = "Cuttle.ai"
+	//ReturnToOrigins are the origins to which the users can be redirected after logging in.
+	//Default is the origin of the frontend url
+	ReturnToOrigins = []string{}
+	//RevokeOnLogout enables revoking the grants given by the user at the auth agents when the user logs out
+	RevokeOnLogout = false
+	//InvitationOnly allows the sign up only for the email addresses invited by the admins
+	InvitationOnly = false
+	//InvitationTTL is the duration for which an invitation is valid
+	InvitationTTL = time.Duration(7 * 24 * time.Hour)
+	//InvitationURL is the url of the sign up page linked in the invitation emails. Default is the origin of the frontend url
+	InvitationURL = ""
+	//EmailVerificationRequired makes the users whose email is not verified by the auth agent verify it with a code
+	//mailed to them before their session is authenticated
+	EmailVerificationRequired = true
+	//EmailVerificationTTL is the duration for which an email verification code is valid
+	EmailVerificationTTL = time.Duration(15 * time.Minute)
 )
 
 //SkipVault will skip the vault initialization if set true
@@ -122,6 +147,10 @@ func init() {
 	 * We will init the request cleanup check
 	 * We will init the frontend url
 	 * We will init the service domain
+	 * We will init the local accounts
+	 * We will init the mfa policy
+	 * We will init the revoke on logout
+	 * We will init the invitations
 	 */
 
 	//discovery service url
@@ -220,6 +249,83 @@ func init() {
 	if len(os.Getenv("SERVICE_DOMAIN")) != 0 {
 		ServiceDomain = os.Getenv("SERVICE_DOMAIN")
 	}
+
+	//local accounts
+	LocalAccounts = os.Getenv("LOCAL_ACCOUNTS") == "true"
+	LocalSignup = os.Getenv("LOCAL_SIGNUP") == "true"
+
+	//mfa policy. An empty value disables the enforcement
+	if types, ok := os.LookupEnv("MFA_REQUIRED_USER_TYPES"); ok {
+		MFARequiredUserTypes = []string{}
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); len(t) != 0 {
+				MFARequiredUserTypes = append(MFARequiredUserTypes, t)
+			}
+		}
+	}
+	if len(os.Getenv("MFA_ISSUER")) != 0 {
+		MFAIssuer = os.Getenv("MFA_ISSUER")
+	}
+
+	//revoke on logout
+	RevokeOnLogout = os.Getenv("REVOKE_ON_LOGOUT") == "true"
+
+	//invitations
+	InvitationOnly = os.Getenv("INVITATION_ONLY") == "true"
+	if t, err := strconv.ParseInt(os.Getenv("INVITATION_TTL"), 10, 64); err == nil && t > 0 {
+		InvitationTTL = time.Duration(t * int64(time.Millisecond))
+	}
+	InvitationURL = os.Getenv("INVITATION_URL")
+
+	//email verification
+	EmailVerificationRequired = os.Getenv("EMAIL_VERIFICATION_REQUIRED") != "false"
+	if t, err := strconv.ParseInt(os.Getenv("EMAIL_VERIFICATION_TTL"), 10, 64); err == nil && t > 0 {
+		EmailVerificationTTL = time.Duration(t * int64(time.Millisecond))
+	}
+
+	//origins allowed for the redirect after login
+	for _, o := range strings.Split(os.Getenv("RETURN_TO_ORIGINS"), ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); len(o) != 0 {
+			ReturnToOrigins = append(ReturnToOrigins, o)
+		}
+	}
+	if len(ReturnToOrigins) == 0 {
+		ReturnToOrigins = []string{FrontendOrigin()}
+	}
+}
+
+//FrontendOrigin returns the origin of the frontend url. The frontend url needn't have the scheme, so http is assumed
+func FrontendOrigin() string {
+	if strings.Contains(FrontendURL, "://") {
+		return strings.TrimRight(FrontendURL, "/")
+	}
+	return "http://" + strings.TrimRight(FrontendURL, "/")
+}
+
+//ReturnToAllowed tells whether the users can be redirected to the given url after logging in.
+//The url has to be an absolute http(s) url of one of the allowed origins
+func ReturnToAllowed(returnTo string) bool {
+	u, err := url.Parse(returnTo)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 || u.User != nil {
+		return false
+	}
+	origin := u.Scheme + "://" + u.Host
+	for _, o := range ReturnToOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+//MFARequired tells whether the users of the given user type have to verify with a second factor
+func MFARequired(userType string) bool {
+	for _, t := range MFARequiredUserTypes {
+		if t == userType {
+			return true
+		}
+	}
+	return false
 }
 
 var (
@@ -107,11 +107,16 @@ func init() {
 		log.Fatal("Error while creating the root app context. Connecting to DB failed. ", err)
 	}
 
-	//getting all the authenticated apps from the database
+	//getting all the authenticated apps from the database. There are none if the database is not enabled
+	if rootAppContext.Db == nil {
+		return
+	}
 	apps := GetAllApps(*rootAppContext)
 	appsMap := map[string]App{}
 	for _, v := range apps {
-		appsMap[v.AccessToken] = v.ToApp()
+		if len(v.AccessToken) != 0 {
+			appsMap[v.AccessToken] = v.ToApp()
+		}
 	}
 
 	//storing the authenticated apps in the authentication map
@@ -245,6 +250,17 @@ func (a *AppContext) ConnectToDB() error {
 	}
 	a.Db.AutoMigrate(&UserInfo{})
 	a.Db.AutoMigrate(&AppInfo{})
+	a.Db.AutoMigrate(&WebAuthnCredential{})
+	a.Db.AutoMigrate(&RecoveryCode{})
+	a.Db.AutoMigrate(&AuditEvent{})
+	a.Db.AutoMigrate(&Identity{})
+	a.Db.AutoMigrate(&ProviderToken{})
+	a.Db.AutoMigrate(&Invitation{})
+	a.Db.AutoMigrate(&AuthorizationCode{})
+	a.Db.AutoMigrate(&AppToken{})
+	a.Db.AutoMigrate(&AppGrant{})
+	a.Db.AutoMigrate(&SigningKey{})
+	a.Db.AutoMigrate(&UsedNonce{})
 	return err
 }
 
@@ -81,15 +81,27 @@ func init() {
 	log.Println("Successfully registered with the discovery service")
 }
 
+//rpcServices are the services registered with the rpc server
+var rpcServices = []interface{}{new(RPCAuth)}
+
+//RegisterRPC adds the service to be registered with the rpc server. It has to be called before starting the rpc service
+func RegisterRPC(service interface{}) {
+	rpcServices = append(rpcServices, service)
+}
+
 //StartRPC service will start the rpc service. It helps the services to communicate between each other
 func StartRPC() {
 	/*
-	 * Will register the user auth rpc with rpc package
+	 * Will register the user auth rpc and the other services with rpc package
 	 * We will listen to the http with rpc of auth module
 	 * Then we will start listening to the rpc port
 	 */
-	//Registering the auth model with the rpc package
-	rpc.Register(new(RPCAuth))
+	//Registering the auth model and the other services with the rpc package
+	for _, s := range rpcServices {
+		if err := rpc.Register(s); err != nil {
+			log.Fatal("Error while registering the rpc service", err.Error())
+		}
+	}
 
 	//registering the handler with http
 	rpc.HandleHTTP()
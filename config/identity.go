@@ -0,0 +1,72 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the model of the identities of the users with the auth agents
+ */
+
+//Identity is the model linking the subject of the user at an auth agent to the user info. A user can have
+//identities with multiple auth agents and the users are found by the identity instead of the email
+type Identity struct {
+	gorm.Model
+	//Provider is the auth agent string of the identity. Eg. GOOGLE, OIDC:OKTA
+	Provider string `gorm:"unique_index:idx_identity_provider_subject"`
+	//Subject is the stable id of the user at the auth agent
+	Subject string `gorm:"unique_index:idx_identity_provider_subject"`
+	//UserID is the id of the user info the identity belongs to
+	UserID uint `gorm:"index"`
+	//Email is the email of the user at the auth agent when the identity was linked
+	Email string
+}
+
+//IdentityLink is the pending link of an identity started by a logged in user. The next login with
+//the provider will link the identity to the user instead of logging in
+type IdentityLink struct {
+	//Provider is the auth agent string of the identity being linked
+	Provider string
+	//User is the logged in user who started the link
+	User User
+	//Expires is the time after which the link is ignored
+	Expires time.Time
+}
+
+//Insert inserts the identity record to the database
+func (i *Identity) Insert(ctx AppContext) error {
+	return ctx.Db.Create(i).Error
+}
+
+//Delete deletes the identity of the user and its token from the database
+func (i *Identity) Delete(ctx AppContext) error {
+	err := ctx.Db.Unscoped().Where("identity_id = ?", i.ID).Delete(&ProviderToken{}).Error
+	if err != nil {
+		return err
+	}
+	return ctx.Db.Unscoped().Where("id = ? and user_id = ?", i.ID, i.UserID).Delete(&Identity{}).Error
+}
+
+//GetIdentity returns the identity of the given provider and subject from the database
+//If doesn't exist in the db, the method will return nil
+func GetIdentity(ctx AppContext, provider string, subject string) (result *Identity) {
+	results := []Identity{}
+	ctx.Db.Where("provider = ? and subject = ?", provider, subject).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//GetIdentities will return the identities linked to the user
+func (u *UserInfo) GetIdentities(ctx AppContext) ([]Identity, error) {
+	identities := []Identity{}
+	err := ctx.Db.Where("user_id = ?", u.ID).Find(&identities).Error
+	return identities, err
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the model of the invitations to sign up
+ */
+
+//ErrInvitationNotPending is returned when the invitation was accepted, revoked or has expired in the meantime
+var ErrInvitationNotPending = errors.New("Invitation is no longer valid")
+
+//Invitation is the model storing the invitation of an email address to sign up
+type Invitation struct {
+	gorm.Model
+	//Email is the email address invited
+	Email string `gorm:"index"`
+	//UserType is the user type assigned to the user on accepting the invitation
+	UserType string
+	//InvitedBy is the id of the user info who created the invitation
+	InvitedBy uint
+	//Expires is the time after which the invitation can't be accepted
+	Expires time.Time
+	//AcceptedBy is the id of the user info who accepted the invitation. It is zero for the invitations not accepted
+	AcceptedBy uint
+	//AcceptedAt is the time at which the invitation was accepted. It is nil for the invitations not accepted
+	AcceptedAt *time.Time
+	//RevokedAt is the time at which the invitation was revoked. It is nil for the invitations not revoked
+	RevokedAt *time.Time
+}
+
+//Pending tells whether the invitation can still be accepted
+func (i Invitation) Pending() bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && i.Expires.After(time.Now())
+}
+
+//Insert inserts the invitation record to the database
+func (i *Invitation) Insert(ctx AppContext) error {
+	return ctx.Db.Create(i).Error
+}
+
+//Accept marks the invitation as accepted by the user. It returns ErrInvitationNotPending if the invitation
+//was accepted, revoked or has expired in the meantime
+func (i *Invitation) Accept(ctx AppContext, userID uint) error {
+	now := time.Now()
+	res := ctx.Db.Model(i).Where("id = ? and accepted_at is null and revoked_at is null and expires > ?", i.ID, now).Updates(map[string]interface{}{
+		"accepted_by": userID,
+		"accepted_at": &now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvitationNotPending
+	}
+	i.AcceptedBy = userID
+	i.AcceptedAt = &now
+	return nil
+}
+
+//Revoke marks the invitation as revoked. It returns ErrInvitationNotPending if the invitation was accepted
+//or revoked in the meantime
+func (i *Invitation) Revoke(ctx AppContext) error {
+	now := time.Now()
+	res := ctx.Db.Model(i).Where("id = ? and accepted_at is null and revoked_at is null", i.ID).Updates(map[string]interface{}{
+		"revoked_at": &now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvitationNotPending
+	}
+	i.RevokedAt = &now
+	return nil
+}
+
+//GetInvitations will return all the invitations with the latest first
+func GetInvitations(ctx AppContext) ([]Invitation, error) {
+	invitations := []Invitation{}
+	err := ctx.Db.Order("created_at desc").Find(&invitations).Error
+	return invitations, err
+}
+
+//GetInvitation returns the invitation of the given id from the database
+//If doesn't exist in the db, the method will return nil
+func GetInvitation(ctx AppContext, id uint) (result *Invitation) {
+	results := []Invitation{}
+	ctx.Db.Where("id = ?", id).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//GetPendingInvitation returns the latest pending invitation of the email from the database
+//If doesn't exist in the db, the method will return nil
+func GetPendingInvitation(ctx AppContext, email string) (result *Invitation) {
+	results := []Invitation{}
+	ctx.Db.Where("lower(email) = lower(?) and accepted_at is null and revoked_at is null and expires > ?", email, time.Now()).
+		Order("created_at desc").Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//HasUsers tells whether any user info exists in the database
+func HasUsers(ctx AppContext) bool {
+	count := 0
+	ctx.Db.Model(&UserInfo{}).Count(&count)
+	return count != 0
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+/*
+ * This file contains the metadata of the user
+ */
+
+//Metadata has the extra attributes of the user mapped from the claims given by the auth agents.
+//It is stored as json in the database
+type Metadata map[string]string
+
+//Value returns the json of the metadata to be stored in the database
+func (m Metadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(map[string]string(m))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+//Scan parses the json of the metadata stored in the database
+func (m *Metadata) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return errors.New("Unsupported type for the user metadata")
+	}
+	*m = Metadata{}
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, (*map[string]string)(m))
+}
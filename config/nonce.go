@@ -0,0 +1,36 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"time"
+)
+
+/*
+ * This file contains the model of the nonces of the one time links like the magic links
+ */
+
+//UsedNonce is the model storing the nonce of a used one time link till the link expires. It is shared by the
+//instances of the auth service so that a link can't be used again on another instance or after a restart
+type UsedNonce struct {
+	//Nonce of the link
+	Nonce string `gorm:"primary_key"`
+	//Expires is the time after which the link can't be used anyway and the nonce can be deleted
+	Expires time.Time `gorm:"index"`
+}
+
+//MarkNonceUsed records the nonce as used till it expires. ok is false if the nonce was used already.
+//The expired nonces are deleted on the way
+func MarkNonceUsed(ctx AppContext, nonce string, expires time.Time) (ok bool, err error) {
+	err = ctx.Db.Where("expires < ?", time.Now()).Delete(&UsedNonce{}).Error
+	if err != nil {
+		return false, err
+	}
+	res := ctx.Db.Set("gorm:insert_option", "ON CONFLICT DO NOTHING").Create(&UsedNonce{Nonce: nonce, Expires: expires})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected != 0, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the model of the recovery codes of the users
+ */
+
+//ErrRecoveryCodeUsed is returned when the recovery code was used concurrently
+var ErrRecoveryCodeUsed = errors.New("Recovery code has already been used")
+
+//RecoveryCode is the model storing the hash of a one time recovery code of a user
+type RecoveryCode struct {
+	gorm.Model
+	//UserID is the id of the user info who owns the code
+	UserID uint `gorm:"index"`
+	//CodeHash is the hash of the code
+	CodeHash string `json:"-"`
+	//UsedAt is the time at which the code was used. It is nil for the unused codes
+	UsedAt *time.Time
+}
+
+//MarkUsed marks the recovery code as used. It returns ErrRecoveryCodeUsed if the code was used in the meantime
+func (r *RecoveryCode) MarkUsed(ctx AppContext) error {
+	now := time.Now()
+	res := ctx.Db.Model(r).Where("id = ? and used_at is null", r.ID).Updates(map[string]interface{}{
+		"used_at": &now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRecoveryCodeUsed
+	}
+	r.UsedAt = &now
+	return nil
+}
+
+//GetRecoveryCodes will return the recovery codes of the user
+func (u *UserInfo) GetRecoveryCodes(ctx AppContext) ([]RecoveryCode, error) {
+	codes := []RecoveryCode{}
+	err := ctx.Db.Where("user_id = ?", u.ID).Find(&codes).Error
+	return codes, err
+}
+
+//GetUnusedRecoveryCode returns the unused recovery code of the user with the given hash
+//If doesn't exist in the db, the method will return nil
+func (u *UserInfo) GetUnusedRecoveryCode(ctx AppContext, hash string) (result *RecoveryCode) {
+	results := []RecoveryCode{}
+	ctx.Db.Where("user_id = ? and code_hash = ? and used_at is null", u.ID, hash).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//ReplaceRecoveryCodes replaces the recovery codes of the user with the codes of the given hashes
+func (u *UserInfo) ReplaceRecoveryCodes(ctx AppContext, hashes []string) error {
+	tx := ctx.Db.Begin()
+	if err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, h := range hashes {
+		if err := tx.Create(&RecoveryCode{UserID: u.ID, CodeHash: h}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
@@ -4,6 +4,10 @@
 
 package config
 
+import (
+	"time"
+)
+
 /* this file contains the model definition of http user session */
 
 //Session denotes an existing user session
@@ -14,6 +18,89 @@ type Session struct {
 	Authenticated bool
 	//User with which the app context is associated with
 	User *User
+	//Pending is the step the user has to complete before the authenticated session can be used. Eg. mfa_required
+	Pending string
+	//SecondFactor is the auth agent with which the user verified the session after logging in. Eg. WEBAUTHN
+	SecondFactor string
+	//Link is the identity link started by the user. It is nil if no link is in progress
+	Link *IdentityLink `json:"-"`
+	//EmailVerification is the pending verification of the email of the user logging in. It is nil if no
+	//verification is in progress
+	EmailVerification *EmailVerification `json:"-"`
+	//Authorization is the authorization request of an app waiting for the consent of the user. It is nil if no
+	//consent is pending
+	Authorization *AuthorizationRequest `json:"-"`
+	//OAuthStates are the pending oauth logins of the session mapped to their state
+	OAuthStates map[string]OAuthState `json:"-"`
+	//ReturnTo is the url to which the user is redirected after the login. It is set by the login callbacks
+	//and cleared before the session is saved
+	ReturnTo string `json:"-"`
+}
+
+//OAuthState is a pending oauth login started by the session
+type OAuthState struct {
+	//Provider with which the login was started
+	Provider string
+	//Verifier is the pkce code verifier of the login
+	Verifier string
+	//Nonce is the nonce expected in the id token of the login
+	Nonce string
+	//ReturnTo is the allowed url to which the user is redirected after the login
+	ReturnTo string
+	//Expires is the time after which the login can't be completed
+	Expires time.Time
+}
+
+//EmailVerification is the pending verification of the email of the user logging in. The user has to enter the
+//code mailed to the email before the session is authenticated
+type EmailVerification struct {
+	//UserID is the id of the account whose email is verified
+	UserID uint
+	//Email being verified
+	Email string
+	//CodeHash is the hash of the code mailed to the user
+	CodeHash string
+	//Expires is the time after which the code can't be used
+	Expires time.Time
+	//Attempts is the number of wrong codes entered
+	Attempts int
+	//Sent is the number of codes mailed for the verification
+	Sent int
+	//SignUp is the account to be created once the email is verified. It is nil if the account exists already
+	SignUp *UserInfo
+	//Provider is the auth agent of the identity of the sign up
+	Provider string
+}
+
+//AuthorizationRequest is the authorization request of an app waiting for the consent of the user
+type AuthorizationRequest struct {
+	//Key identifies the request in the consent form so that the consent can't be submitted by other sites
+	Key string
+	//ClientID is the client id of the app
+	ClientID string
+	//RedirectURI to which the user is redirected with the authorization code
+	RedirectURI string
+	//RedirectURISupplied indicates that the app gave the redirect uri in the request
+	RedirectURISupplied bool
+	//Scope requested by the app
+	Scope string
+	//State given by the app. It is returned as such in the redirect
+	State string
+	//CodeChallenge is the S256 pkce code challenge given by the app
+	CodeChallenge string
+	//Expires is the time after which the consent can't be given
+	Expires time.Time
+}
+
+//SessionMFARequired is the pending state of the session till the user verifies with a second factor
+const SessionMFARequired = "mfa_required"
+
+//SessionEmailVerificationRequired is the pending state of the session till the user verifies the ownership of the email
+const SessionEmailVerificationRequired = "email_verification_required"
+
+//IsAuthenticated tells whether the session is authenticated and has no pending steps
+func (s Session) IsAuthenticated() bool {
+	return s.Authenticated && len(s.Pending) == 0
 }
 
 //AuthHeaderKey is the key to be used to store the auth token in the header
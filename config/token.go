@@ -0,0 +1,135 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/secretbox"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/oauth2"
+)
+
+/*
+ * This file contains the model of the tokens given by the auth agents for the identities
+ */
+
+//TokenEncryptionKeysKey is the key storing the comma separated base64 encoded 32 byte keys with which the
+//tokens of the auth agents are encrypted. The first key encrypts and the rest are kept for decrypting the
+//tokens encrypted before a key rotation
+const TokenEncryptionKeysKey = "TOKEN_ENCRYPTION_KEYS"
+
+//ErrTokenStorageDisabled is returned when no key is configured for encrypting the tokens of the auth agents
+var ErrTokenStorageDisabled = errors.New("Token storage is not enabled")
+
+//TokenBox encrypts the tokens of the auth agents. The tokens aren't stored if it is nil
+var TokenBox *secretbox.Box
+
+func init() {
+	if len(os.Getenv(TokenEncryptionKeysKey)) == 0 {
+		log.Println("Token encryption keys not found. The tokens of the auth agents won't be stored")
+		return
+	}
+	b, err := secretbox.Parse(os.Getenv(TokenEncryptionKeysKey))
+	if err != nil {
+		log.Fatal("Error while parsing the token encryption keys ", err)
+	}
+	TokenBox = b
+}
+
+//ProviderToken is the model storing the encrypted token given by the auth agent for an identity
+type ProviderToken struct {
+	gorm.Model
+	//IdentityID is the id of the identity the token belongs to
+	IdentityID uint `gorm:"unique_index"`
+	//UserID is the id of the user info the identity belongs to
+	UserID uint `gorm:"index"`
+	//Provider is the auth agent string of the identity. Eg. GOOGLE
+	Provider string
+	//AccessToken is the encrypted access token
+	AccessToken string `gorm:"type:text" json:"-"`
+	//RefreshToken is the encrypted refresh token. It is empty if the auth agent didn't give one
+	RefreshToken string `gorm:"type:text" json:"-"`
+	//TokenType is the type of the access token. Eg. Bearer
+	TokenType string
+	//Expiry is the time at which the access token expires. It is zero if the token doesn't expire
+	Expiry time.Time `gorm:"index"`
+}
+
+//SetToken encrypts and sets the token. The refresh token is kept if the auth agent didn't give a new one
+func (p *ProviderToken) SetToken(tok *oauth2.Token) error {
+	if TokenBox == nil {
+		return ErrTokenStorageDisabled
+	}
+	access, err := TokenBox.Seal(tok.AccessToken)
+	if err != nil {
+		return err
+	}
+	if len(tok.RefreshToken) != 0 {
+		p.RefreshToken, err = TokenBox.Seal(tok.RefreshToken)
+		if err != nil {
+			return err
+		}
+	}
+	p.AccessToken = access
+	p.TokenType = tok.TokenType
+	p.Expiry = tok.Expiry
+	return nil
+}
+
+//Token returns the decrypted token
+func (p *ProviderToken) Token() (*oauth2.Token, error) {
+	if TokenBox == nil {
+		return nil, ErrTokenStorageDisabled
+	}
+	access, err := TokenBox.Open(p.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := TokenBox.Open(p.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: access, RefreshToken: refresh, TokenType: p.TokenType, Expiry: p.Expiry}, nil
+}
+
+//Save inserts the token record to the database or updates it if the record exists
+func (p *ProviderToken) Save(ctx AppContext) error {
+	if p.ID == 0 {
+		return ctx.Db.Create(p).Error
+	}
+	return ctx.Db.Model(p).Where("id = ?", p.ID).Updates(map[string]interface{}{
+		"access_token":  p.AccessToken,
+		"refresh_token": p.RefreshToken,
+		"token_type":    p.TokenType,
+		"expiry":        p.Expiry,
+	}).Error
+}
+
+//Delete deletes the token record from the database
+func (p *ProviderToken) Delete(ctx AppContext) error {
+	return ctx.Db.Unscoped().Where("id = ?", p.ID).Delete(&ProviderToken{}).Error
+}
+
+//GetProviderToken returns the token of the identity from the database
+//If doesn't exist in the db, the method will return nil
+func (i *Identity) GetProviderToken(ctx AppContext) (result *ProviderToken) {
+	results := []ProviderToken{}
+	ctx.Db.Where("identity_id = ?", i.ID).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//GetExpiringProviderTokens returns the refreshable tokens expiring before the given time
+func GetExpiringProviderTokens(ctx AppContext, before time.Time) ([]ProviderToken, error) {
+	results := []ProviderToken{}
+	err := ctx.Db.Where("refresh_token <> '' and expiry > ? and expiry < ?", time.Time{}, before).Find(&results).Error
+	return results, err
+}
@@ -8,12 +8,14 @@ import (
 	"log"
 	"net/rpc"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/consul/api"
 	"github.com/jinzhu/gorm"
+	"golang.org/x/oauth2"
 )
 
 /*
@@ -35,6 +37,20 @@ const (
 	CuttleApp = "CuttleApp"
 )
 
+//UserTypeRank returns the rank of the user type among the user roles. Higher roles have higher ranks.
+//The types which aren't user roles have the lowest rank
+func UserTypeRank(t string) int {
+	switch t {
+	case SuperAdmin:
+		return 3
+	case AdminUser:
+		return 2
+	case ManagerUser:
+		return 1
+	}
+	return 0
+}
+
 const (
 	//CuttleAI for string as auth agent for registered apps
 	CuttleAI = "CUTTLE.AI"
@@ -50,10 +66,21 @@ type User struct {
 	AccessToken string
 	//AuthAgent has the name of the agent who authenticated the user. Eg. GOOGLE
 	AuthAgent string
+	//IDToken is the openid connect id token given by the auth agent. It is held only till the login completes
+	IDToken string `json:"-"`
+	//ProviderToken is the token given by the auth agent. It is held only till the login completes
+	ProviderToken *oauth2.Token `json:"-"`
+	//Nonce is the nonce expected in the id token. It is held only till the login completes
+	Nonce string `json:"-"`
 	//Email is the email of the user
 	Email string
 	//UserType is the type of user like NormalUser/Manager/Admin/SuperAdmin
 	UserType string
+	//AppID is the id of the app to which the access token was issued on behalf of the user.
+	//It is zero for the sessions of the user
+	AppID uint
+	//Scope granted by the user to the app. It is empty for the sessions of the user
+	Scope string
 }
 
 //App is to store the information about the apps authenticated  in the system
@@ -74,6 +101,11 @@ type App struct {
 	UserID uint
 	//IsMasterApp indicates whether ther app is from cuttle platform
 	IsMasterApp bool
+	//RedirectURIs are the uris to which the authorization server can redirect the users with the authorization codes
+	RedirectURIs []string
+	//ClientSecret is the secret with which the app authenticates at the token endpoint. It is given only when
+	//the secret is generated
+	ClientSecret string `json:",omitempty"`
 }
 
 var users = make(map[string]*User)
@@ -109,6 +141,34 @@ func GetAutenticatedUser(accessToken string) (user User, ok bool) {
 	return
 }
 
+//GetAutenticatedApp will return the autenticated app for a given accesstoken
+//ok parameter will be false if the app is not authenticated for a given access token
+func GetAutenticatedApp(accessToken string) (app App, ok bool) {
+	authenticatedUsers.lock.Lock()
+	app, ok = authenticatedUsers.apps[accessToken]
+	authenticatedUsers.lock.Unlock()
+	return
+}
+
+//LookupAccessToken will return the user authenticated with the given access token. Apps authenticated with their
+//access token are given as users of the app. ok parameter will be false if nothing is authenticated with the token
+func LookupAccessToken(accessToken string) (user User, ok bool) {
+	if len(accessToken) == 0 {
+		return
+	}
+	authenticatedUsers.lock.Lock()
+	defer authenticatedUsers.lock.Unlock()
+	if user, ok = authenticatedUsers.users[accessToken]; ok {
+		return
+	}
+	app, ok := authenticatedUsers.apps[accessToken]
+	if ok {
+		user = app.ToUser()
+		user.AppID = app.ID
+	}
+	return
+}
+
 //SetAuthenticatedUsers sets the authenticated users in the system
 func (a *AuthenticatedUsers) SetAuthenticatedUsers(users map[string]User) {
 	a.lock.Lock()
@@ -176,18 +236,26 @@ func (r *RPCAuth) Unauthenticate(u User, ok *bool) error {
 	return nil
 }
 
-//GetAllAutheticatedUsers will return the list of all the authenticated users
+//GetAllAutheticatedUsers will return the list of all the authenticated users. The registered apps are given only
+//with the short lived tokens issued to them. The master app is given with its token since the platform uses it
 func (r *RPCAuth) GetAllAutheticatedUsers(ok bool, users *map[string]User) error {
 	/*
-	 * We will get all the users
-	 * Also we will get all the apps and add them as users
+	 * We will copy all the users
+	 * Also we will add the master app as a user
 	 */
-	usrs := authenticatedUsers.users
+	authenticatedUsers.lock.Lock()
+	usrs := make(map[string]User, len(authenticatedUsers.users))
+	for k, v := range authenticatedUsers.users {
+		usrs[k] = v
+	}
 	for k, v := range authenticatedUsers.apps {
-		usrs[k] = v.ToUser()
+		if v.IsMasterApp {
+			usrs[k] = v.ToUser()
+		}
 	}
+	authenticatedUsers.lock.Unlock()
 	*users = usrs
-	log.Println("gave the authenticated users list", len(authenticatedUsers.users))
+	log.Println("gave the authenticated users list", len(usrs))
 	return nil
 }
 
@@ -294,14 +362,52 @@ func (a App) ToUser() User {
 //ToAppInfo converts the app to appinfo instance
 func (a App) ToAppInfo() AppInfo {
 	return AppInfo{
-		Model:       gorm.Model{ID: a.ID},
-		UID:         a.UID,
-		AccessToken: a.AccessToken,
-		Email:       a.Email,
-		Description: a.Description,
-		UserID:      a.UserID,
-		Name:        a.Name,
-		IsMasterApp: a.IsMasterApp,
+		Model:        gorm.Model{ID: a.ID},
+		UID:          a.UID,
+		AccessToken:  a.AccessToken,
+		Email:        a.Email,
+		Description:  a.Description,
+		UserID:       a.UserID,
+		Name:         a.Name,
+		IsMasterApp:  a.IsMasterApp,
+		RedirectURIs: strings.Join(a.RedirectURIs, " "),
+	}
+}
+
+//InformAuth will inform other services that the app has been authenticated with its access token
+func (a App) InformAuth(appCtx AppContext, loggedIn bool) {
+	/*
+	 * We will communicate with the consul client
+	 * Then we will get all the services.
+	 * We will then use the auth rpc call to authenticate them.
+	 */
+	if loggedIn {
+		authenticatedUsers.SetAuthenticatedApp(a)
+	} else {
+		authenticatedUsers.DeleteAuthenticatedApp(a)
+	}
+	dConfig := api.DefaultConfig()
+	dConfig.Address = DiscoveryURL
+	dConfig.Token = DiscoveryToken
+	client, err := api.NewClient(dConfig)
+	if err != nil {
+		appCtx.Log.Error("Error while initing the discovery service client", err.Error())
+		return
+	}
+
+	//getting the services list
+	services, err := client.Agent().Services()
+	if err != nil {
+		appCtx.Log.Error("Error while getting the list fo services registered with the application")
+		return
+	}
+
+	//going to use rpc call to authenticate the app
+	for _, v := range services {
+		if _, ok := v.Meta["RPCService"]; ok && v.ID != AuthServiceRPCID {
+			appCtx.Log.Info("Informing auth of app to", v.ID)
+			a.rpcAuth(appCtx, v, loggedIn)
+		}
 	}
 }
 
@@ -429,12 +535,28 @@ type UserInfo struct {
 	Name string `db:"name"`
 	//Picture of the user
 	Picture string `db:"picture"`
+	//EmailVerified indicates that the ownership of the email has been verified by the auth agent or by the user
+	//with a verification code
+	EmailVerified bool
 	//Registered indicates whether the user has registered with the application
 	Registered bool `db:"registered"`
 	//Subscribed indicates that the user is subscribed to the platform newsletter
 	Subscribed bool `db:"subscribed"`
 	//UserType is the type of user like NormalUser/Manager/Admin/SuperAdmin/RegisteredApp
 	UserType string
+	//PasswordHash is the password hash of the local account of the user. It is empty for users without a local account
+	PasswordHash string `json:"-"`
+	//TOTPSecret is the totp secret of the user. It is set on enrollment and used once TOTPEnabled is set
+	TOTPSecret string `json:"-"`
+	//TOTPEnabled indicates that the user has confirmed the totp enrollment
+	TOTPEnabled bool
+	//TOTPLastStep is the step of the last totp code used. Codes of the earlier steps are rejected
+	TOTPLastStep int64 `json:"-"`
+	//Subject is the stable id of the user at the auth agent who gave the info. It is not stored in the user info
+	//but in the identity of the user. It is empty for the first party logins which are found by the email
+	Subject string `gorm:"-" json:"-"`
+	//Metadata has the extra attributes of the user mapped from the claims given by the auth agent
+	Metadata Metadata `gorm:"type:text"`
 }
 
 //Get returns the userinfo model from the database
@@ -448,17 +570,85 @@ func (u UserInfo) Get(ctx AppContext) (result *UserInfo) {
 	return
 }
 
+//GetUserInfoByID returns the userinfo model of the given id from the database
+//If doesn't exist in the db, the method will return nil
+func GetUserInfoByID(ctx AppContext, id uint) (result *UserInfo) {
+	results := []UserInfo{}
+	ctx.Db.Where("id = ?", id).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
 //Insert inserts the user info record to the database
 func (u *UserInfo) Insert(ctx AppContext) error {
 	return ctx.Db.Create(u).Error
 }
 
-//Update updates the userinfo model based on the email
+//Update updates the userinfo model based on the id
 func (u *UserInfo) Update(ctx AppContext) error {
-	return ctx.Db.Model(&u).Where("email = ?", u.Email).Updates(map[string]interface{}{
+	return ctx.Db.Model(u).Where("id = ?", u.ID).Updates(map[string]interface{}{
 		"name":       u.Name,
 		"picture":    u.Picture,
 		"registered": u.Registered,
+		"subscribed": u.Subscribed,
+	}).Error
+}
+
+//UpdateProfile updates the name, the picture and the metadata of the userinfo model based on the id
+func (u *UserInfo) UpdateProfile(ctx AppContext) error {
+	return ctx.Db.Model(&u).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"name":     u.Name,
+		"picture":  u.Picture,
+		"metadata": u.Metadata,
+	}).Error
+}
+
+//UpdatePassword updates the password hash of the userinfo model based on the id
+func (u *UserInfo) UpdatePassword(ctx AppContext) error {
+	return ctx.Db.Model(&u).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"password_hash": u.PasswordHash,
+	}).Error
+}
+
+//UpdateTOTP updates the totp enrollment of the userinfo model based on the id
+func (u *UserInfo) UpdateTOTP(ctx AppContext) error {
+	return ctx.Db.Model(&u).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"totp_secret":    u.TOTPSecret,
+		"totp_enabled":   u.TOTPEnabled,
+		"totp_last_step": u.TOTPLastStep,
+	}).Error
+}
+
+//Delete deletes the userinfo model along with its identities, tokens, passkeys and recovery codes from the database.
+//The audit trail of the user is kept
+func (u *UserInfo) Delete(ctx AppContext) error {
+	tx := ctx.Db.Begin()
+	for _, m := range []interface{}{&ProviderToken{}, &Identity{}, &WebAuthnCredential{}, &RecoveryCode{}, &AppToken{}, &AppGrant{}, &AuthorizationCode{}} {
+		if err := tx.Unscoped().Where("user_id = ?", u.ID).Delete(m).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Unscoped().Where("id = ?", u.ID).Delete(&UserInfo{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+//UpdateEmailVerified updates the email verified flag of the userinfo model based on the id
+func (u *UserInfo) UpdateEmailVerified(ctx AppContext) error {
+	return ctx.Db.Model(&u).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"email_verified": u.EmailVerified,
+	}).Error
+}
+
+//UpdateUserType updates the user type of the userinfo model based on the id
+func (u *UserInfo) UpdateUserType(ctx AppContext) error {
+	return ctx.Db.Model(&u).Where("id = ?", u.ID).Updates(map[string]interface{}{
+		"user_type": u.UserType,
 	}).Error
 }
 
@@ -498,19 +688,24 @@ type AppInfo struct {
 	UserID uint
 	//IsMasterApp indicates the app is from cuttle platform itself
 	IsMasterApp bool
+	//RedirectURIs are the space separated redirect uris of the app
+	RedirectURIs string
+	//ClientSecretHash is the hash of the client secret of the app
+	ClientSecretHash string `json:"-"`
 }
 
 //ToApp converts the appInfo into app instance
 func (a AppInfo) ToApp() App {
 	return App{
-		ID:          a.ID,
-		UID:         a.UID,
-		AccessToken: a.AccessToken,
-		Email:       a.Email,
-		Description: a.Description,
-		Name:        a.Name,
-		UserID:      a.UserID,
-		IsMasterApp: a.IsMasterApp,
+		ID:           a.ID,
+		UID:          a.UID,
+		AccessToken:  a.AccessToken,
+		Email:        a.Email,
+		Description:  a.Description,
+		Name:         a.Name,
+		UserID:       a.UserID,
+		IsMasterApp:  a.IsMasterApp,
+		RedirectURIs: strings.Fields(a.RedirectURIs),
 	}
 }
 
@@ -540,8 +735,23 @@ func (a *AppInfo) Delete(ctx AppContext) error {
 //Update updates the userinfo model based on the uid -- name and description
 func (a *AppInfo) Update(ctx AppContext) error {
 	return ctx.Db.Model(a).Where("uid = ? and user_id = ?", a.UID, a.UserID).Updates(map[string]interface{}{
-		"name":        a.Name,
-		"description": a.Description,
-		"email":       a.Email,
+		"name":          a.Name,
+		"description":   a.Description,
+		"email":         a.Email,
+		"redirect_uris": a.RedirectURIs,
+	}).Error
+}
+
+//UpdateAccessToken updates the long lived access token of the app based on the uid
+func (a *AppInfo) UpdateAccessToken(ctx AppContext) error {
+	return ctx.Db.Model(a).Where("uid = ? and user_id = ?", a.UID, a.UserID).Updates(map[string]interface{}{
+		"access_token": a.AccessToken,
+	}).Error
+}
+
+//UpdateClientSecret updates the client secret hash of the app based on the uid
+func (a *AppInfo) UpdateClientSecret(ctx AppContext) error {
+	return ctx.Db.Model(a).Where("uid = ? and user_id = ?", a.UID, a.UserID).Updates(map[string]interface{}{
+		"client_secret_hash": a.ClientSecretHash,
 	}).Error
 }
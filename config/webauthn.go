@@ -0,0 +1,70 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the model of the webauthn credentials of the users
+ */
+
+//WebAuthnCredential is the model storing a passkey registered by a user. A user can have multiple passkeys
+type WebAuthnCredential struct {
+	gorm.Model
+	//UserID is the id of the user info who registered the credential
+	UserID uint `gorm:"index"`
+	//CredentialID is the base64url encoded credential id
+	CredentialID string `gorm:"unique_index"`
+	//PublicKey is the cose encoded public key of the credential
+	PublicKey []byte `json:"-"`
+	//SignCount is the last sign count seen for the credential
+	SignCount uint32
+	//Name is the name given by the user to the credential
+	Name string
+	//LastUsedAt is the time at which the credential was last used
+	LastUsedAt *time.Time
+}
+
+//Insert inserts the webauthn credential record to the database
+func (w *WebAuthnCredential) Insert(ctx AppContext) error {
+	return ctx.Db.Create(w).Error
+}
+
+//Delete deletes the webauthn credential of the user from the database
+func (w *WebAuthnCredential) Delete(ctx AppContext) error {
+	return ctx.Db.Where("id = ? and user_id = ?", w.ID, w.UserID).Delete(&WebAuthnCredential{}).Error
+}
+
+//UpdateSignCount updates the sign count and the last used time of the webauthn credential
+func (w *WebAuthnCredential) UpdateSignCount(ctx AppContext) error {
+	now := time.Now()
+	w.LastUsedAt = &now
+	return ctx.Db.Model(w).Where("id = ?", w.ID).Updates(map[string]interface{}{
+		"sign_count":   w.SignCount,
+		"last_used_at": w.LastUsedAt,
+	}).Error
+}
+
+//GetWebAuthnCredential returns the webauthn credential of the given credential id from the database
+//If doesn't exist in the db, the method will return nil
+func GetWebAuthnCredential(ctx AppContext, credentialID string) (result *WebAuthnCredential) {
+	results := []WebAuthnCredential{}
+	ctx.Db.Where("credential_id = ?", credentialID).Find(&results)
+	if len(results) != 0 {
+		result = &results[0]
+	}
+	return
+}
+
+//GetWebAuthnCredentials will return the webauthn credentials registered by the user
+func (u *UserInfo) GetWebAuthnCredentials(ctx AppContext) ([]WebAuthnCredential, error) {
+	creds := []WebAuthnCredential{}
+	err := ctx.Db.Where("user_id = ?", u.ID).Find(&creds).Error
+	return creds, err
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package domainpolicy
+
+import (
+	"os"
+	"strings"
+)
+
+/*
+ * This file contains the configuration of the domain policy
+ */
+
+const (
+	//AllowedDomainsKey is the key storing the comma separated email domains allowed to sign up
+	AllowedDomainsKey = "SIGNUP_ALLOWED_DOMAINS"
+	//DeniedDomainsKey is the key storing the comma separated email domains not allowed to sign up
+	DeniedDomainsKey = "SIGNUP_DENIED_DOMAINS"
+	//AllowedEmailsKey is the key storing the comma separated email addresses allowed to sign up
+	AllowedEmailsKey = "SIGNUP_ALLOWED_EMAILS"
+	//DeniedEmailsKey is the key storing the comma separated email addresses not allowed to sign up
+	DeniedEmailsKey = "SIGNUP_DENIED_EMAILS"
+	//HostedDomainsKey is the key storing the comma separated google workspace hosted domains allowed to log in
+	HostedDomainsKey = "OAUTH2_GOOGLE_HOSTED_DOMAINS"
+)
+
+//Default is the domain policy of the auth service
+var Default = &Policy{}
+
+func init() {
+	Default = &Policy{
+		AllowedDomains: list(os.Getenv(AllowedDomainsKey)),
+		DeniedDomains:  list(os.Getenv(DeniedDomainsKey)),
+		AllowedEmails:  list(os.Getenv(AllowedEmailsKey)),
+		DeniedEmails:   list(os.Getenv(DeniedEmailsKey)),
+		HostedDomains:  list(os.Getenv(HostedDomainsKey)),
+	}
+}
+
+//list returns the trimmed non empty values in the comma separated list
+func list(values string) []string {
+	result := []string{}
+	for _, v := range strings.Split(values, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package domainpolicy has the policy of the email domains, the email addresses and the google workspace
+//hosted domains with which the users can sign up.
+//
+//Denied addresses are rejected first. Allowed addresses are accepted regardless of their domain. Then the denied
+//domains are rejected and if allowed domains are given, only they are accepted. Domains match exactly while
+//the domains prefixed with *. match their subdomains. Eg. *.example.com matches eng.example.com
+package domainpolicy
+
+import (
+	"strings"
+)
+
+/*
+ * This file contains the domain policy
+ */
+
+//Policy is the domain policy. An empty policy allows everyone
+type Policy struct {
+	//AllowedDomains are the email domains allowed to sign up. All the domains are allowed if it is empty
+	AllowedDomains []string
+	//DeniedDomains are the email domains not allowed to sign up
+	DeniedDomains []string
+	//AllowedEmails are the email addresses allowed to sign up irrespective of their domain
+	AllowedEmails []string
+	//DeniedEmails are the email addresses not allowed to sign up
+	DeniedEmails []string
+	//HostedDomains are the google workspace hosted domains allowed to log in with google.
+	//All the google accounts are allowed if it is empty
+	HostedDomains []string
+}
+
+//Error is returned when the policy rejects a user. The reason can be shown to the user
+type Error struct {
+	//Reason of the rejection
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return e.Reason
+}
+
+//CheckEmail checks whether the email address is allowed to sign up
+func (p *Policy) CheckEmail(email string) error {
+	/*
+	 * We will check the denied and allowed addresses
+	 * Then we will check the denied and allowed domains
+	 */
+	email = strings.ToLower(strings.TrimSpace(email))
+	i := strings.LastIndex(email, "@")
+	if i <= 0 || i == len(email)-1 {
+		return &Error{Reason: "A valid email address is required to sign up"}
+	}
+	domain := email[i+1:]
+
+	//checking the addresses
+	if contains(p.DeniedEmails, email) {
+		return &Error{Reason: "Your email address isn't allowed to sign up. Please contact your administrator"}
+	}
+	if contains(p.AllowedEmails, email) {
+		return nil
+	}
+
+	//checking the domains
+	if matchDomain(p.DeniedDomains, domain) {
+		return &Error{Reason: "Email addresses of " + domain + " aren't allowed to sign up. Please contact your administrator"}
+	}
+	if len(p.AllowedDomains) != 0 && !matchDomain(p.AllowedDomains, domain) {
+		return &Error{Reason: "Email addresses of " + domain + " aren't allowed to sign up. Please sign up with your work email"}
+	}
+	return nil
+}
+
+//CheckHostedDomain checks whether the google workspace hosted domain is allowed to log in.
+//hd is empty for the consumer google accounts
+func (p *Policy) CheckHostedDomain(hd string) error {
+	if len(p.HostedDomains) == 0 || contains(p.HostedDomains, strings.ToLower(hd)) {
+		return nil
+	}
+	if len(hd) == 0 {
+		return &Error{Reason: "Personal Google accounts aren't allowed. Please log in with your work account"}
+	}
+	return &Error{Reason: "Google accounts of " + hd + " aren't allowed. Please log in with your work account"}
+}
+
+//contains tells whether the value is in the list ignoring the case
+func contains(list []string, v string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, v) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchDomain tells whether the domain matches any domain in the list. Domains prefixed with *. match
+//their subdomains
+func matchDomain(list []string, domain string) bool {
+	for _, d := range list {
+		d = strings.ToLower(d)
+		if strings.HasPrefix(d, "*.") {
+			if strings.HasSuffix(domain, d[1:]) {
+				return true
+			}
+			continue
+		}
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
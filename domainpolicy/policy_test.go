@@ -0,0 +1,73 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package domainpolicy
+
+import (
+	"testing"
+)
+
+/*
+ * This file contains the tests of the domain policy
+ */
+
+func TestEmptyPolicy(t *testing.T) {
+	p := &Policy{}
+	if err := p.CheckEmail("anyone@gmail.com"); err != nil {
+		t.Error(err)
+	}
+	if err := p.CheckHostedDomain(""); err != nil {
+		t.Error(err)
+	}
+	if err := p.CheckEmail("not-an-email"); err == nil {
+		t.Error("expected invalid email to fail")
+	}
+}
+
+func TestCheckEmail(t *testing.T) {
+	p := &Policy{
+		AllowedDomains: []string{"example.com", "*.example.org"},
+		DeniedDomains:  []string{"contractors.example.org"},
+		AllowedEmails:  []string{"Partner@Gmail.com"},
+		DeniedEmails:   []string{"former@example.com"},
+	}
+	cases := []struct {
+		email string
+		ok    bool
+	}{
+		{"alice@example.com", true},
+		{"ALICE@EXAMPLE.COM", true},
+		{"bob@eng.example.org", true},
+		{"bob@example.org", false},
+		{"carol@contractors.example.org", false},
+		{"partner@gmail.com", true},
+		{"someone@gmail.com", false},
+		{"former@example.com", false},
+		{"mallory@notexample.com", false},
+	}
+	for _, c := range cases {
+		err := p.CheckEmail(c.email)
+		if c.ok && err != nil {
+			t.Errorf("%s: expected to be allowed, got %v", c.email, err)
+		}
+		if !c.ok {
+			if _, isPolicy := err.(*Error); !isPolicy {
+				t.Errorf("%s: expected a policy error, got %v", c.email, err)
+			}
+		}
+	}
+}
+
+func TestCheckHostedDomain(t *testing.T) {
+	p := &Policy{HostedDomains: []string{"example.com"}}
+	if err := p.CheckHostedDomain("Example.com"); err != nil {
+		t.Error(err)
+	}
+	if err := p.CheckHostedDomain(""); err == nil {
+		t.Error("expected consumer account to fail")
+	}
+	if err := p.CheckHostedDomain("other.com"); err == nil {
+		t.Error("expected other hosted domain to fail")
+	}
+}
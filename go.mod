@@ -14,15 +14,19 @@ replace github.com/cuttle-ai/go-sdk => ../go-sdk/
 
 require (
 	cloud.google.com/go v0.37.4 // indirect
+	github.com/beevik/etree v1.1.0
 	github.com/cuttle-ai/brain v0.0.0-00010101000000-000000000000
 	github.com/cuttle-ai/configs v0.0.0-20190824112953-7860fdfd0dae
 	github.com/cuttle-ai/db-toolkit v0.0.0-00010101000000-000000000000
 	github.com/cuttle-ai/go-sdk v0.0.0-00010101000000-000000000000
+	github.com/go-ldap/ldap/v3 v3.1.10
 	github.com/google/uuid v1.1.1
 	github.com/hashicorp/consul/api v1.4.0
 	github.com/inconshreveable/log15 v0.0.0-20180818164646-67afb5ed74ec // indirect
 	github.com/jinzhu/gorm v1.9.12
+	github.com/russellhaering/goxmldsig v1.1.0
 	github.com/xeonx/timeago v1.0.0-rc4 // indirect
+	golang.org/x/crypto v0.0.0-20191205180655-e7c4368fe9dd
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	gopkg.in/fsnotify/fsnotify.v1 v1.4.7 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
@@ -0,0 +1,114 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+/*
+ * This file contains the json web key definitions
+ */
+
+//JSONWebKey is a public key in the json web key format
+type JSONWebKey struct {
+	//Kty is the key type. RSA or EC
+	Kty string `json:"kty"`
+	//Kid is the id of the key
+	Kid string `json:"kid,omitempty"`
+	//Use is the intended use of the key. sig for signing keys
+	Use string `json:"use,omitempty"`
+	//Alg is the algorithm with which the key is intended to be used
+	Alg string `json:"alg,omitempty"`
+	//N is the modulus of the rsa key
+	N string `json:"n,omitempty"`
+	//E is the exponent of the rsa key
+	E string `json:"e,omitempty"`
+	//Crv is the curve of the ec key
+	Crv string `json:"crv,omitempty"`
+	//X is the x coordinate of the ec key
+	X string `json:"x,omitempty"`
+	//Y is the y coordinate of the ec key
+	Y string `json:"y,omitempty"`
+}
+
+//JSONWebKeySet is a set of json web keys
+type JSONWebKeySet struct {
+	//Keys in the set
+	Keys []JSONWebKey `json:"keys"`
+}
+
+//Key returns the key with the given key id from the set. ok will be false if not found
+func (s JSONWebKeySet) Key(kid string) (key JSONWebKey, ok bool) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return
+}
+
+//PublicKey returns the public key of the json web key
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.New("Unsupported curve " + k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("Unsupported key type " + k.Kty)
+}
+
+//NewRSAJSONWebKey returns the json web key of the rsa public key for verifying the tokens signed with RS256
+func NewRSAJSONWebKey(kid string, key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: RS256,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+//decodeBigInt decodes a base64 url encoded big endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
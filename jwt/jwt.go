@@ -0,0 +1,245 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package jwt has the utilities for parsing and verifying json web tokens signed
+//with RSA or ECDSA keys and for signing them with RSA keys
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the json web token parsing, verification and signing utils
+ */
+
+const (
+	//RS256 is the RSASSA-PKCS1-v1_5 using SHA-256 signing algorithm
+	RS256 = "RS256"
+	//RS384 is the RSASSA-PKCS1-v1_5 using SHA-384 signing algorithm
+	RS384 = "RS384"
+	//RS512 is the RSASSA-PKCS1-v1_5 using SHA-512 signing algorithm
+	RS512 = "RS512"
+	//ES256 is the ECDSA using P-256 and SHA-256 signing algorithm
+	ES256 = "ES256"
+	//ES384 is the ECDSA using P-384 and SHA-384 signing algorithm
+	ES384 = "ES384"
+)
+
+//Header is the jose header of the token
+type Header struct {
+	//Alg is the algorithm with which the token is signed
+	Alg string `json:"alg"`
+	//Kid is the id of the key with which the token is signed
+	Kid string `json:"kid,omitempty"`
+	//Typ is the type of the token
+	Typ string `json:"typ,omitempty"`
+}
+
+//Claims are the claims in the token payload
+type Claims map[string]interface{}
+
+//Token is a parsed json web token
+type Token struct {
+	//Header of the token
+	Header Header
+	//Claims of the token
+	Claims Claims
+	//signingInput is the header and payload part of the token on which the signature was computed
+	signingInput string
+	//signature of the token
+	signature []byte
+}
+
+//Parse will parse the given compact serialized token. It won't verify the signature of the token
+func Parse(raw string) (*Token, error) {
+	/*
+	 * We will split the token into its three parts
+	 * Then decode the header
+	 * Then decode the claims
+	 * Then decode the signature
+	 */
+	//splitting the token
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Token is malformed")
+	}
+	t := &Token{signingInput: parts[0] + "." + parts[1]}
+
+	//decoding the header
+	err := decodeSegment(parts[0], &t.Header)
+	if err != nil {
+		return nil, errors.New("Token header is malformed " + err.Error())
+	}
+
+	//decoding the claims
+	err = decodeSegment(parts[1], &t.Claims)
+	if err != nil {
+		return nil, errors.New("Token claims are malformed " + err.Error())
+	}
+
+	//decoding the signature
+	t.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("Token signature is malformed " + err.Error())
+	}
+	return t, nil
+}
+
+//Sign will sign the claims with the rsa private key using RS256 and return the compact serialized token.
+//kid is the id of the key set in the header so that the verifiers can find the public key
+func Sign(claims Claims, kid string, key *rsa.PrivateKey) (string, error) {
+	/*
+	 * We will encode the header and the claims
+	 * Then sign them and append the signature
+	 */
+	//encoding the header and the claims
+	header, err := encodeSegment(Header{Alg: RS256, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := header + "." + payload
+
+	//signing
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest.Sum(nil))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+//encodeSegment encodes a json segment of the token in base64 url encoding
+func encodeSegment(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//decodeSegment decodes a base64 url encoded json segment of the token
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+//Verify will verify the signature of the token with the given public key
+func (t *Token) Verify(key crypto.PublicKey) error {
+	/*
+	 * We will find the hash for the algorithm
+	 * Then verify the signature based on the key type
+	 */
+	//getting the hash for the algorithm
+	h, err := hashOf(t.Header.Alg)
+	if err != nil {
+		return err
+	}
+	hasher := h.New()
+	hasher.Write([]byte(t.signingInput))
+	digest := hasher.Sum(nil)
+
+	//verifying the signature based on the key type
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(t.Header.Alg, "RS") {
+			return errors.New("Token algorithm " + t.Header.Alg + " doesn't match the rsa key")
+		}
+		return rsa.VerifyPKCS1v15(k, h, digest, t.signature)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(t.Header.Alg, "ES") {
+			return errors.New("Token algorithm " + t.Header.Alg + " doesn't match the ecdsa key")
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return errors.New("Token signature has invalid length")
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("Token signature is invalid")
+		}
+		return nil
+	}
+	return errors.New("Unsupported key type for verifying the token")
+}
+
+//hashOf returns the hash function for the given signing algorithm
+func hashOf(alg string) (crypto.Hash, error) {
+	switch alg {
+	case RS256, ES256:
+		return crypto.SHA256, nil
+	case RS384, ES384:
+		return crypto.SHA384, nil
+	case RS512:
+		return crypto.SHA512, nil
+	}
+	return 0, errors.New("Unsupported token algorithm " + alg)
+}
+
+//String returns the string value of the claim. If the claim doesn't exist or is not a string empty string is returned
+func (c Claims) String(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+//Time returns the numeric date claim as time. ok will be false if the claim doesn't exist or is not a number
+func (c Claims) Time(key string) (t time.Time, ok bool) {
+	v, ok := c[key].(float64)
+	if !ok {
+		return
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+//HasAudience checks whether the audience claim has the given audience.
+//Audience claim can be a single string or an array of strings
+func (c Claims) HasAudience(aud string) bool {
+	switch v := c["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//ValidateTime validates the expiry, not before and issued at claims of the token with the allowed clock skew
+func (c Claims) ValidateTime(now time.Time, skew time.Duration) error {
+	exp, ok := c.Time("exp")
+	if !ok {
+		return errors.New("Token has no expiry")
+	}
+	if now.Add(-skew).After(exp) {
+		return errors.New("Token has expired")
+	}
+	if nbf, ok := c.Time("nbf"); ok && now.Add(skew).Before(nbf) {
+		return errors.New("Token is not valid yet")
+	}
+	if iat, ok := c.Time("iat"); ok && now.Add(skew).Before(iat) {
+		return errors.New("Token is issued in the future")
+	}
+	return nil
+}
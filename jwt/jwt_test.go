@@ -0,0 +1,74 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * This file contains the tests of the json web token signing and verification
+ */
+
+func TestSign(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := Sign(Claims{"sub": "42", "exp": time.Now().Add(time.Minute).Unix()}, "key-1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the token has to verify with the public key given in the key set
+	tok, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Header.Alg != RS256 || tok.Header.Kid != "key-1" {
+		t.Errorf("unexpected header %+v", tok.Header)
+	}
+	jwk, ok := JSONWebKeySet{Keys: []JSONWebKey{NewRSAJSONWebKey("key-1", &key.PublicKey)}}.Key(tok.Header.Kid)
+	if !ok {
+		t.Fatal("key not found in the key set")
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tok.Verify(pub); err != nil {
+		t.Fatal(err)
+	}
+	if tok.Claims.String("sub") != "42" {
+		t.Errorf("expected sub 42, got %s", tok.Claims.String("sub"))
+	}
+	if err := tok.Claims.ValidateTime(time.Now(), 0); err != nil {
+		t.Error(err)
+	}
+
+	//a tampered payload mustn't verify
+	parts := strings.Split(raw, ".")
+	forged, _ := encodeSegment(Claims{"sub": "1"})
+	tampered, err := Parse(parts[0] + "." + forged + "." + parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tampered.Verify(pub) == nil {
+		t.Error("tampered token was verified")
+	}
+
+	//a different key mustn't verify
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Verify(&other.PublicKey) == nil {
+		t.Error("token was verified with a different key")
+	}
+}
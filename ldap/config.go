@@ -0,0 +1,140 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package ldap has the implementation of the ldap/active directory bind authentication
+package ldap
+
+/*
+ * This file contains the configuration of the ldap directory
+ */
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//URLKey is the key storing the url of the ldap server. Eg. ldaps://ldap.example.com:636
+	URLKey = "LDAP_URL"
+	//StartTLSKey is the key storing whether to upgrade the connection with start tls
+	StartTLSKey = "LDAP_START_TLS"
+	//BindDNKey is the key storing the template of the user's bind dn. %s is replaced with the username.
+	//Eg. uid=%s,ou=people,dc=example,dc=com or %s@corp.example.com for active directory
+	BindDNKey = "LDAP_BIND_DN"
+	//ServiceBindDNKey is the key storing the dn of the service account used to search the user's dn.
+	//Used only when the user's bind dn template is not given
+	ServiceBindDNKey = "LDAP_SERVICE_BIND_DN"
+	//ServiceBindPasswordKey is the key storing the password of the service account
+	ServiceBindPasswordKey = "LDAP_SERVICE_BIND_PASSWORD"
+	//SearchBaseDNKey is the key storing the base dn under which the users are searched
+	SearchBaseDNKey = "LDAP_SEARCH_BASE_DN"
+	//SearchFilterKey is the key storing the search filter of the user. %s is replaced with the escaped username.
+	//Default is (uid=%s)
+	SearchFilterKey = "LDAP_SEARCH_FILTER"
+	//AttributeEmailKey is the key storing the email attribute. Default is mail
+	AttributeEmailKey = "LDAP_ATTRIBUTE_EMAIL"
+	//AttributeNameKey is the key storing the name attribute. Default is cn
+	AttributeNameKey = "LDAP_ATTRIBUTE_NAME"
+	//AttributePictureKey is the key storing the picture url attribute
+	AttributePictureKey = "LDAP_ATTRIBUTE_PICTURE"
+	//TimeoutKey is the key storing the timeout of the ldap operations in milliseconds
+	TimeoutKey = "LDAP_TIMEOUT"
+)
+
+//Config is the configuration of the ldap directory
+type Config struct {
+	//URL of the ldap server
+	URL string
+	//StartTLS indicates whether to upgrade the connection with start tls
+	StartTLS bool
+	//BindDN is the template of the user's bind dn
+	BindDN string
+	//ServiceBindDN is the dn of the service account used to search the user's dn
+	ServiceBindDN string
+	//ServiceBindPassword is the password of the service account
+	ServiceBindPassword string
+	//SearchBaseDN is the base dn under which the users are searched
+	SearchBaseDN string
+	//SearchFilter is the search filter of the user
+	SearchFilter string
+	//AttributeEmail is the email attribute of the user
+	AttributeEmail string
+	//AttributeName is the name attribute of the user
+	AttributeName string
+	//AttributePicture is the picture url attribute of the user
+	AttributePicture string
+	//Timeout of the ldap operations
+	Timeout time.Duration
+}
+
+//Directory is the configured ldap directory. It will be nil if the ldap login is not configured
+var Directory *Config
+
+//LoadConfig will load the config required for the ldap login
+func LoadConfig() error {
+	/*
+	 * We will set the url
+	 * Then the bind dn template or the service account
+	 * Then the search config
+	 * Then the attributes
+	 * Then the timeout
+	 */
+	c := &Config{
+		URL:                 os.Getenv(URLKey),
+		StartTLS:            os.Getenv(StartTLSKey) == "true",
+		BindDN:              os.Getenv(BindDNKey),
+		ServiceBindDN:       os.Getenv(ServiceBindDNKey),
+		ServiceBindPassword: os.Getenv(ServiceBindPasswordKey),
+		SearchBaseDN:        os.Getenv(SearchBaseDNKey),
+		SearchFilter:        envOr(SearchFilterKey, "(uid=%s)"),
+		AttributeEmail:      envOr(AttributeEmailKey, "mail"),
+		AttributeName:       envOr(AttributeNameKey, "cn"),
+		AttributePicture:    os.Getenv(AttributePictureKey),
+		Timeout:             time.Duration(10000 * time.Millisecond),
+	}
+	if len(c.URL) == 0 {
+		return errors.New("LDAP url not found")
+	}
+	if len(c.BindDN) == 0 && len(c.ServiceBindDN) == 0 {
+		return errors.New("LDAP bind dn template or service bind dn not found")
+	}
+	if len(c.BindDN) != 0 && strings.Count(c.BindDN, "%s") != 1 {
+		return errors.New("LDAP bind dn template must have exactly one %s")
+	}
+	if len(c.SearchBaseDN) == 0 {
+		return errors.New("LDAP search base dn not found")
+	}
+	if strings.Count(c.SearchFilter, "%s") != 1 {
+		return errors.New("LDAP search filter must have exactly one %s")
+	}
+	if t, err := strconv.ParseInt(os.Getenv(TimeoutKey), 10, 64); err == nil {
+		c.Timeout = time.Duration(t * int64(time.Millisecond))
+	}
+
+	Directory = c
+	return nil
+}
+
+//envOr returns the value of the environment variable or the default if not set
+func envOr(key string, def string) string {
+	if v := os.Getenv(key); len(v) != 0 {
+		return v
+	}
+	return def
+}
+
+func init() {
+	//ldap login is optional. We will load the config only if the url is configured
+	if len(os.Getenv(URLKey)) == 0 {
+		return
+	}
+	err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+/*
+ * This file contains the ldap bind authentication
+ */
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+//ErrInvalidCredentials is returned when the username or password is wrong
+var ErrInvalidCredentials = errors.New("Invalid username or password")
+
+//Conn is the connection to the ldap server. It is satisfied by the go-ldap connection and
+//can be replaced by an in-process stand in while testing
+type Conn interface {
+	//Bind authenticates the connection with the given dn and password
+	Bind(username, password string) error
+	//Search searches the directory with the given request
+	Search(req *goldap.SearchRequest) (*goldap.SearchResult, error)
+	//Close closes the connection
+	Close()
+}
+
+//Dial connects to the ldap server of the given config
+var Dial = func(c *Config) (Conn, error) {
+	conn, err := goldap.DialURL(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(c.Timeout)
+	if c.StartTLS {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		err = conn.StartTLS(&tls.Config{ServerName: u.Hostname()})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+//Entry is the directory entry of an authenticated user
+type Entry struct {
+	//DN of the user
+	DN string
+	//Email of the user
+	Email string
+	//Name of the user
+	Name string
+	//Picture url of the user
+	Picture string
+}
+
+//Authenticate binds to the directory as the user with the given username and password and
+//returns the user's entry
+func (c *Config) Authenticate(username, password string) (*Entry, error) {
+	/*
+	 * We will reject empty credentials since ldap treats an empty password as an anonymous bind
+	 * We will connect to the ldap server
+	 * If we have a bind dn template we will bind as the user and search the user's entry
+	 * Else we will bind as the service account, search the user's entry and then bind as the user
+	 * Then we will map the attributes to the entry
+	 */
+	//rejecting the empty credentials
+	if len(strings.TrimSpace(username)) == 0 || len(password) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	//connecting to the server
+	conn, err := Dial(c)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var e *goldap.Entry
+	if len(c.BindDN) != 0 {
+		//binding as the user and searching the entry
+		err = bind(conn, fmt.Sprintf(c.BindDN, EscapeDN(username)), password)
+		if err != nil {
+			return nil, err
+		}
+		e, err = c.search(conn, username)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		//searching the entry with the service account and binding as the user
+		err = conn.Bind(c.ServiceBindDN, c.ServiceBindPassword)
+		if err != nil {
+			return nil, err
+		}
+		e, err = c.search(conn, username)
+		if err != nil {
+			return nil, err
+		}
+		err = bind(conn, e.DN, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//mapping the attributes
+	entry := &Entry{
+		DN:      e.DN,
+		Email:   e.GetAttributeValue(c.AttributeEmail),
+		Name:    e.GetAttributeValue(c.AttributeName),
+		Picture: e.GetAttributeValue(c.AttributePicture),
+	}
+	if len(entry.Email) == 0 {
+		return nil, errors.New("Email attribute " + c.AttributeEmail + " not found for " + e.DN)
+	}
+	if len(entry.Name) == 0 {
+		entry.Name = username
+	}
+	return entry, nil
+}
+
+//bind binds as the user and translates the invalid credentials error
+func bind(conn Conn, dn string, password string) error {
+	err := conn.Bind(dn, password)
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+//search searches the entry of the user with the search filter
+func (c *Config) search(conn Conn, username string) (*goldap.Entry, error) {
+	attributes := []string{"dn", c.AttributeEmail, c.AttributeName}
+	if len(c.AttributePicture) != 0 {
+		attributes = append(attributes, c.AttributePicture)
+	}
+	res, err := conn.Search(goldap.NewSearchRequest(
+		c.SearchBaseDN,
+		goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 2, int(c.Timeout.Seconds()), false,
+		fmt.Sprintf(c.SearchFilter, goldap.EscapeFilter(username)),
+		attributes,
+		nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return res.Entries[0], nil
+}
+
+//EscapeDN escapes the special characters in a distinguished name attribute value as per RFC 4514
+func EscapeDN(value string) string {
+	b := &strings.Builder{}
+	for k, r := range value {
+		switch {
+		case strings.ContainsRune(`\,+"<>;=`, r),
+			k == 0 && (r == ' ' || r == '#'),
+			k == len(value)-1 && r == ' ':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
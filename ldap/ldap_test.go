@@ -0,0 +1,146 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+/*
+ * This file contains the tests of the ldap bind authentication against an in-process directory
+ */
+
+//directory is an in-process stand in for the ldap server
+type directory struct {
+	//passwords of the dns
+	passwords map[string]string
+	//entries mapped to the search filter which finds them
+	entries map[string]*goldap.Entry
+	//bound is the dn with which the connection is bound
+	bound string
+	//filters are the search filters received
+	filters []string
+}
+
+func (d *directory) Bind(username, password string) error {
+	if p, ok := d.passwords[username]; !ok || p != password {
+		return goldap.NewError(goldap.LDAPResultInvalidCredentials, nil)
+	}
+	d.bound = username
+	return nil
+}
+
+func (d *directory) Search(req *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	if len(d.bound) == 0 {
+		return nil, goldap.NewError(goldap.LDAPResultInsufficientAccessRights, nil)
+	}
+	d.filters = append(d.filters, req.Filter)
+	res := &goldap.SearchResult{}
+	if e, ok := d.entries[req.Filter]; ok {
+		res.Entries = append(res.Entries, e)
+	}
+	return res, nil
+}
+
+func (d *directory) Close() {}
+
+func newDirectory() *directory {
+	dn := "uid=jdoe,ou=people,dc=example,dc=com"
+	return &directory{
+		passwords: map[string]string{
+			dn:                            "secret",
+			"cn=reader,dc=example,dc=com": "reader-secret",
+		},
+		entries: map[string]*goldap.Entry{
+			"(uid=jdoe)": goldap.NewEntry(dn, map[string][]string{
+				"mail":    {"jdoe@example.com"},
+				"cn":      {"John Doe"},
+				"jpegURL": {"https://example.com/jdoe.jpg"},
+			}),
+		},
+	}
+}
+
+//withDirectory makes the dial connect to the given directory. The returned func restores the dial
+func withDirectory(d *directory) func() {
+	dial := Dial
+	Dial = func(c *Config) (Conn, error) {
+		return d, nil
+	}
+	return func() {
+		Dial = dial
+	}
+}
+
+func testConfig() *Config {
+	return &Config{
+		URL:              "ldap://localhost",
+		BindDN:           "uid=%s,ou=people,dc=example,dc=com",
+		SearchBaseDN:     "ou=people,dc=example,dc=com",
+		SearchFilter:     "(uid=%s)",
+		AttributeEmail:   "mail",
+		AttributeName:    "cn",
+		AttributePicture: "jpegURL",
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	defer withDirectory(newDirectory())()
+	e, err := testConfig().Authenticate("jdoe", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Email != "jdoe@example.com" || e.Name != "John Doe" || e.Picture != "https://example.com/jdoe.jpg" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestAuthenticateWithServiceAccount(t *testing.T) {
+	defer withDirectory(newDirectory())()
+	c := testConfig()
+	c.BindDN = ""
+	c.ServiceBindDN = "cn=reader,dc=example,dc=com"
+	c.ServiceBindPassword = "reader-secret"
+	e, err := c.Authenticate("jdoe", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.DN != "uid=jdoe,ou=people,dc=example,dc=com" {
+		t.Errorf("unexpected dn %s", e.DN)
+	}
+	if _, err = c.Authenticate("jdoe", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+}
+
+func TestAuthenticateInvalidCredentials(t *testing.T) {
+	defer withDirectory(newDirectory())()
+	cases := []struct {
+		username string
+		password string
+	}{
+		{"jdoe", "wrong"},
+		{"jdoe", ""},
+		{"", "secret"},
+		{"nobody", "secret"},
+	}
+	for _, c := range cases {
+		if _, err := testConfig().Authenticate(c.username, c.password); err != ErrInvalidCredentials {
+			t.Errorf("%q/%q: expected invalid credentials, got %v", c.username, c.password, err)
+		}
+	}
+}
+
+func TestAuthenticateEscapesUsername(t *testing.T) {
+	d := newDirectory()
+	d.passwords[`uid=x\,dc\=evil*)(uid\=*,ou=people,dc=example,dc=com`] = "secret"
+	defer withDirectory(d)()
+	testConfig().Authenticate("x,dc=evil*)(uid=*", "secret")
+	if len(d.filters) != 1 || d.filters[0] != `(uid=x,dc=evil\2a\29\28uid=\2a)` {
+		t.Errorf("search filter is not escaped %v", d.filters)
+	}
+}
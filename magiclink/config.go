@@ -0,0 +1,78 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package magiclink
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/mailer"
+)
+
+/*
+ * This file contains the configuration of the magic links
+ */
+
+const (
+	//URLKey is the key storing the public url of the link verification endpoint. Magic links are enabled when it is set
+	URLKey = "MAGIC_LINK_URL"
+	//SecretKey is the key storing the secret with which the links are signed. It has to be at least
+	//MinSecretLength bytes and the same across the instances of the auth service
+	SecretKey = "MAGIC_LINK_SECRET"
+	//TTLKey is the key storing the validity of the links in milliseconds
+	TTLKey = "MAGIC_LINK_TTL"
+	//ThrottleWindowKey is the key storing the throttle window of an address in milliseconds
+	ThrottleWindowKey = "MAGIC_LINK_THROTTLE_WINDOW"
+	//ThrottleLimitKey is the key storing the number of links that can be sent to an address in the throttle window
+	ThrottleLimitKey = "MAGIC_LINK_THROTTLE_LIMIT"
+)
+
+//MinSecretLength is the minimum length in bytes of the secret with which the links are signed
+const MinSecretLength = 32
+
+//Default has the magic links of the auth service. It is nil if the magic links are not enabled
+var Default *Links
+
+func init() {
+	/*
+	 * If the link url is not set or the mail sender is not configured, we won't enable the magic links
+	 * We will make sure the secret is given since the links have to verify on all the instances
+	 * Then we will init the ttl and the throttle
+	 */
+	if len(os.Getenv(URLKey)) == 0 {
+		return
+	}
+	if mailer.Default == nil {
+		log.Println("Magic links are not enabled since no mail sender is configured")
+		return
+	}
+	l := &Links{
+		LinkURL:        os.Getenv(URLKey),
+		Secret:         []byte(os.Getenv(SecretKey)),
+		TTL:            time.Duration(15 * time.Minute),
+		ThrottleWindow: time.Duration(15 * time.Minute),
+		ThrottleLimit:  3,
+		Sender:         mailer.Default,
+	}
+
+	//secret
+	if len(l.Secret) < MinSecretLength {
+		log.Fatal("Magic links are enabled but ", SecretKey, " is not set or is shorter than ", MinSecretLength, " bytes")
+	}
+
+	//ttl and the throttle
+	if t, err := strconv.Atoi(os.Getenv(TTLKey)); err == nil && t > 0 {
+		l.TTL = time.Duration(t) * time.Millisecond
+	}
+	if t, err := strconv.Atoi(os.Getenv(ThrottleWindowKey)); err == nil && t > 0 {
+		l.ThrottleWindow = time.Duration(t) * time.Millisecond
+	}
+	if t, err := strconv.Atoi(os.Getenv(ThrottleLimitKey)); err == nil && t > 0 {
+		l.ThrottleLimit = t
+	}
+	Default = l
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package magiclink has the implementation of the passwordless login with a link sent to the email of the user.
+//The links are signed, short lived and can be used only once
+package magiclink
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/mailer"
+)
+
+/*
+ * This file contains the issuing and verification of the magic links
+ */
+
+var (
+	//ErrInvalidLink is returned when the link is malformed or its signature doesn't match
+	ErrInvalidLink = errors.New("Invalid login link")
+	//ErrExpired is returned when the link has expired
+	ErrExpired = errors.New("Login link has expired")
+	//ErrUsed is returned when the link has already been used
+	ErrUsed = errors.New("Login link has already been used")
+	//ErrThrottled is returned when too many links were requested for the address
+	ErrThrottled = errors.New("Too many login links requested. Please try again later")
+)
+
+//now returns the current time. It is swapped in the tests
+var now = time.Now
+
+//UsedStore records the nonces of the used links. It is shared by the instances of the auth service so that a
+//link can be used only once across the instances and the restarts
+type UsedStore interface {
+	//MarkUsed records the nonce as used till the link expires. It returns ErrUsed if the nonce was used already
+	MarkUsed(nonce string, expires time.Time) error
+}
+
+//Links issues and verifies the magic links
+type Links struct {
+	//Secret is the key with which the links are signed
+	Secret []byte
+	//LinkURL is the url to which the token is appended as the token query param
+	LinkURL string
+	//TTL is the duration for which a link is valid
+	TTL time.Duration
+	//ThrottleWindow is the window in which at most ThrottleLimit links can be sent to an address
+	ThrottleWindow time.Duration
+	//ThrottleLimit is the number of links that can be sent to an address in the ThrottleWindow
+	ThrottleLimit int
+	//Sender sends the emails with the links
+	Sender mailer.Sender
+	//Used records the nonces of the used links. The nonces are kept in memory if it is nil
+	Used UsedStore
+
+	//used has the nonces of the used links mapped to their expiry when no store is given
+	used map[string]time.Time
+	//sent has the times at which the links were sent to an address
+	sent map[string][]time.Time
+	lock sync.Mutex
+}
+
+//Send sends a login link to the email. It returns ErrThrottled if too many links were requested for the email
+func (l *Links) Send(email string) error {
+	/*
+	 * We will check the throttle of the address
+	 * We will create the signed token
+	 * Then we will send the link to the address
+	 */
+	email = normalize(email)
+	if len(email) == 0 {
+		return ErrInvalidLink
+	}
+
+	//checking the throttle
+	if !l.allow(email) {
+		return ErrThrottled
+	}
+
+	//creating the token
+	t, err := l.token(email, now().Add(l.TTL))
+	if err != nil {
+		return err
+	}
+
+	//sending the link
+	link := l.LinkURL + "?" + url.Values{"token": {t}}.Encode()
+	return l.Sender.Send(mailer.Message{
+		To:      email,
+		Subject: "Your login link",
+		Body: "Use the following link to log in. The link is valid for " + l.TTL.String() +
+			" and can be used only once.\r\n\r\n" + link + "\r\n\r\n" +
+			"If you didn't request this link, you can ignore this email.\r\n",
+	})
+}
+
+//Verify verifies the token of the link and returns the email for which the link was issued.
+//The link can't be used again once verified
+func (l *Links) Verify(token string) (string, error) {
+	/*
+	 * We will split the token into payload and signature and verify the signature
+	 * We will decode the payload and check the expiry
+	 * Then we will mark the link as used if it was not used already
+	 */
+	//verifying the signature
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidLink
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, l.sign(parts[0])) {
+		return "", ErrInvalidLink
+	}
+
+	//decoding the payload
+	p, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidLink
+	}
+	fields := strings.Split(string(p), "\n")
+	if len(fields) != 3 {
+		return "", ErrInvalidLink
+	}
+	exp, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidLink
+	}
+	expires := time.Unix(exp, 0)
+	if !now().Before(expires) {
+		return "", ErrExpired
+	}
+
+	//marking the link as used
+	if err := l.markUsed(fields[2], expires); err != nil {
+		return "", err
+	}
+	return fields[0], nil
+}
+
+//markUsed records the nonce of the link as used in the store or in memory if there is no store.
+//It returns ErrUsed if the nonce was used already
+func (l *Links) markUsed(nonce string, expires time.Time) error {
+	if l.Used != nil {
+		return l.Used.MarkUsed(nonce, expires)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.used == nil {
+		l.used = map[string]time.Time{}
+	}
+	for k, v := range l.used {
+		if v.Before(now()) {
+			delete(l.used, k)
+		}
+	}
+	if _, ok := l.used[nonce]; ok {
+		return ErrUsed
+	}
+	l.used[nonce] = expires
+	return nil
+}
+
+//token returns the signed token for the email expiring at the given time
+func (l *Links) token(email string, expires time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	p := base64.RawURLEncoding.EncodeToString([]byte(email + "\n" + strconv.FormatInt(expires.Unix(), 10) +
+		"\n" + base64.RawURLEncoding.EncodeToString(nonce)))
+	return p + "." + base64.RawURLEncoding.EncodeToString(l.sign(p)), nil
+}
+
+//sign returns the hmac of the payload
+func (l *Links) sign(payload string) []byte {
+	m := hmac.New(sha256.New, l.Secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+//allow records a link being sent to the address and reports whether it is within the throttle limit
+func (l *Links) allow(email string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.sent == nil {
+		l.sent = map[string][]time.Time{}
+	}
+	n := now()
+	for k, v := range l.sent {
+		recent := v[:0]
+		for _, t := range v {
+			if n.Sub(t) < l.ThrottleWindow {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(l.sent, k)
+			continue
+		}
+		l.sent[k] = recent
+	}
+	if len(l.sent[email]) >= l.ThrottleLimit {
+		return false
+	}
+	l.sent[email] = append(l.sent[email], n)
+	return true
+}
+
+//normalize returns the email in the form it is stored
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
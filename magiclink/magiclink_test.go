@@ -0,0 +1,148 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package magiclink
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/mailer"
+)
+
+/*
+ * This file contains the tests of the magic links
+ */
+
+func testLinks() (*Links, *mailer.MemorySender) {
+	s := &mailer.MemorySender{}
+	return &Links{
+		Secret:         []byte("test-secret"),
+		LinkURL:        "https://auth.example.com/auth/magic/verify",
+		TTL:            time.Minute,
+		ThrottleWindow: time.Minute,
+		ThrottleLimit:  2,
+		Sender:         s,
+	}, s
+}
+
+//tokenOf returns the token from the last link sent to the address
+func tokenOf(t *testing.T, s *mailer.MemorySender, to string) string {
+	m, ok := s.Last(to)
+	if !ok {
+		t.Fatal("no link sent to", to)
+	}
+	for _, line := range strings.Split(m.Body, "\r\n") {
+		if strings.HasPrefix(line, "https://") {
+			u, err := url.Parse(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatal("no link found in", m.Body)
+	return ""
+}
+
+//withNow makes now return the given time. The returned func restores now
+func withNow(n time.Time) func() {
+	old := now
+	now = func() time.Time {
+		return n
+	}
+	return func() {
+		now = old
+	}
+}
+
+func TestSendAndVerify(t *testing.T) {
+	l, s := testLinks()
+	if err := l.Send(" JDoe@Example.com "); err != nil {
+		t.Fatal(err)
+	}
+	tok := tokenOf(t, s, "jdoe@example.com")
+	email, err := l.Verify(tok)
+	if err != nil || email != "jdoe@example.com" {
+		t.Fatalf("expected the email of the link, got %q %v", email, err)
+	}
+	if _, err = l.Verify(tok); err != ErrUsed {
+		t.Errorf("expected the replayed link to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	l, s := testLinks()
+	if err := l.Send("jdoe@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer withNow(time.Now().Add(2 * time.Minute))()
+	if _, err := l.Verify(tokenOf(t, s, "jdoe@example.com")); err != ErrExpired {
+		t.Errorf("expected the link to be expired, got %v", err)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	l, s := testLinks()
+	if err := l.Send("jdoe@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	tok := tokenOf(t, s, "jdoe@example.com")
+	other, _ := l.token("admin@example.com", time.Now().Add(time.Minute))
+	forged := strings.Split(other, ".")[0] + "." + strings.Split(tok, ".")[1]
+	for _, c := range []string{"", "abc", forged, tok + "x"} {
+		if _, err := l.Verify(c); err != ErrInvalidLink {
+			t.Errorf("%q: expected an invalid link, got %v", c, err)
+		}
+	}
+}
+
+func TestSendThrottled(t *testing.T) {
+	l, _ := testLinks()
+	for i := 0; i < 2; i++ {
+		if err := l.Send("jdoe@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Send("jdoe@example.com"); err != ErrThrottled {
+		t.Errorf("expected the address to be throttled, got %v", err)
+	}
+	if err := l.Send("other@example.com"); err != nil {
+		t.Errorf("expected other address not to be throttled, got %v", err)
+	}
+	defer withNow(time.Now().Add(2 * time.Minute))()
+	if err := l.Send("jdoe@example.com"); err != nil {
+		t.Errorf("expected the throttle to reset after the window, got %v", err)
+	}
+}
+
+//memoryStore is a used store shared by the links of the tests
+type memoryStore map[string]time.Time
+
+func (m memoryStore) MarkUsed(nonce string, expires time.Time) error {
+	if _, ok := m[nonce]; ok {
+		return ErrUsed
+	}
+	m[nonce] = expires
+	return nil
+}
+
+func TestVerifySharedStore(t *testing.T) {
+	store := memoryStore{}
+	l, s := testLinks()
+	other, _ := testLinks()
+	l.Used, other.Used = store, store
+	if err := l.Send("jdoe@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	tok := tokenOf(t, s, "jdoe@example.com")
+	if email, err := other.Verify(tok); err != nil || email != "jdoe@example.com" {
+		t.Fatalf("expected the link to verify on another instance, got %q %v", email, err)
+	}
+	if _, err := l.Verify(tok); err != ErrUsed {
+		t.Errorf("expected the link used on another instance to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package mailer has the mail senders used by the auth service to send out emails like the login links.
+//SMTP sender is used in production and the in memory sender in the tests
+package mailer
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+ * This file contains the definition of the mail sender
+ */
+
+//Message is an email message
+type Message struct {
+	//To is the email address of the recipient
+	To string
+	//Subject of the email
+	Subject string
+	//Body is the plain text body of the email
+	Body string
+}
+
+//Sender sends the email messages
+type Sender interface {
+	//Send sends the message
+	Send(m Message) error
+}
+
+//Default is the sender used by the auth service. It is nil if no mail sender is configured
+var Default Sender
+
+//Validate checks whether the message can be sent. Headers are rejected if they have line breaks
+//to prevent header injection
+func (m Message) Validate() error {
+	if len(m.To) == 0 {
+		return errors.New("Recipient of the message is empty")
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("Message headers can't have line breaks")
+	}
+	return nil
+}
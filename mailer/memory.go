@@ -0,0 +1,47 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package mailer
+
+import "sync"
+
+/*
+ * This file contains the in memory mail sender
+ */
+
+//MemorySender keeps the messages sent in memory instead of delivering them. It is meant for the tests
+type MemorySender struct {
+	messages []Message
+	lock     sync.Mutex
+}
+
+//Send stores the message
+func (s *MemorySender) Send(m Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+//Messages returns the messages sent so far
+func (s *MemorySender) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Message{}, s.messages...)
+}
+
+//Last returns the last message sent to the given address. ok will be false if no message was sent to the address
+func (s *MemorySender) Last(to string) (m Message, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i], true
+		}
+	}
+	return Message{}, false
+}
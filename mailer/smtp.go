@@ -0,0 +1,96 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package mailer
+
+import (
+	"bytes"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"time"
+)
+
+/*
+ * This file contains the smtp mail sender
+ */
+
+const (
+	//SMTPHostKey is the key storing the host of the smtp server. The smtp sender is enabled when it is set
+	SMTPHostKey = "SMTP_HOST"
+	//SMTPPortKey is the key storing the port of the smtp server
+	SMTPPortKey = "SMTP_PORT"
+	//SMTPUsernameKey is the key storing the username for authenticating with the smtp server
+	SMTPUsernameKey = "SMTP_USERNAME"
+	//SMTPPasswordKey is the key storing the password for authenticating with the smtp server
+	SMTPPasswordKey = "SMTP_PASSWORD"
+	//SMTPFromKey is the key storing the from address of the emails
+	SMTPFromKey = "SMTP_FROM"
+)
+
+//SMTPSender sends the messages through an smtp server. The connection is upgraded with StartTLS when
+//the server supports it
+type SMTPSender struct {
+	//Host of the smtp server
+	Host string
+	//Port of the smtp server
+	Port string
+	//Username for authenticating with the smtp server. Authentication is skipped if empty
+	Username string
+	//Password for authenticating with the smtp server
+	Password string
+	//From is the from address of the emails
+	From string
+}
+
+//Send sends the message through the smtp server
+func (s *SMTPSender) Send(m Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if len(s.Username) != 0 {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{m.To}, s.message(m))
+}
+
+//message returns the message in the rfc 5322 format
+func (s *SMTPSender) message(m Message) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(m.Body)
+	return b.Bytes()
+}
+
+func init() {
+	/*
+	 * If the smtp host is not set we won't configure the sender
+	 * Then we will init the smtp sender from the environment
+	 */
+	if len(os.Getenv(SMTPHostKey)) == 0 {
+		return
+	}
+	s := &SMTPSender{
+		Host:     os.Getenv(SMTPHostKey),
+		Port:     "587",
+		Username: os.Getenv(SMTPUsernameKey),
+		Password: os.Getenv(SMTPPasswordKey),
+		From:     os.Getenv(SMTPFromKey),
+	}
+	if len(os.Getenv(SMTPPortKey)) != 0 {
+		s.Port = os.Getenv(SMTPPortKey)
+	}
+	if len(s.From) == 0 {
+		s.From = "no-reply@" + s.Host
+	}
+	Default = s
+}
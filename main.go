@@ -13,11 +13,12 @@ import (
 
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 
+	"github.com/cuttle-ai/auth-service/authserver"
 	"github.com/cuttle-ai/auth-service/config"
 	"github.com/cuttle-ai/auth-service/log"
+	"github.com/cuttle-ai/auth-service/oauth"
 	"github.com/cuttle-ai/auth-service/routes"
 
-	_ "github.com/cuttle-ai/auth-service/oauth/google"
 	_ "github.com/cuttle-ai/auth-service/routes/auth"
 )
 
@@ -29,7 +30,11 @@ func main() {
 	/*
 	 * Create a new Server mux
 	 * Create a default server
+	 * Load the keys signing the tokens
+	 * Check the config of the authorization server
 	 * Init the routes
+	 * Start refreshing the tokens of the auth agents
+	 * Start removing the expired tokens of the apps
 	 * Now listen and serve
 	 * Listen to the os signals for exit
 	 * Graceful exit when command comes
@@ -46,9 +51,36 @@ func main() {
 		MaxHeaderBytes: 1 << 20,
 	}
 
+	//loading the keys signing the tokens
+	if err := authserver.RotateKeys(config.NewAppContext(log.NewLogger(0))); err != nil {
+		log.Fatal("Couldn't load the keys signing the tokens. ", err.Error())
+	}
+
+	//checking the config of the authorization server
+	if authserver.Enabled {
+		if err := authserver.CheckConfig(); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
 	//inited the routes
 	routes.InitRoutes(m)
 
+	//refreshing the tokens of the auth agents in the background
+	if config.TokenBox != nil {
+		stopRefresher := oauth.StartTokenRefresher(config.NewAppContext(log.NewLogger(0)))
+		defer stopRefresher()
+	}
+
+	//informing the tokens issued to the apps before the restart to the platform again
+	if authserver.Enabled {
+		authserver.Restore(config.NewAppContext(log.NewLogger(0)))
+	}
+
+	//removing the expired tokens of the apps from the platform in the background
+	stopSweeper := authserver.StartSweeper(config.NewAppContext(log.NewLogger(0)))
+	defer stopSweeper()
+
 	//listen and serve to the server
 	go func() {
 		log.Info("Starting the server at :" + config.Port)
@@ -0,0 +1,298 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package github has the implmentations for github oauth
+// and github user profile fetch
+package github
+
+/*
+ * This file contains the github oauth utils
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/claimmap"
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+//Config is the github oauth2 config. It will be nil if the github oauth is not configured
+var Config *oauth2.Config
+
+//UserInfoMap has the user info map required for the github user profile
+var UserInfoMap = oauth.UserInfoMap{
+	Name:    "name",
+	Email:   "email",
+	Picture: "avatar_url",
+}
+
+//UserInfoURL is the url to hit to get the user info from github
+var UserInfoURL = "https://api.github.com/user"
+
+//UserEmailsURL is the url to hit to get the email addresses of the user from github.
+//It is used when the user has kept the primary email private and to check whether the public email is verified
+var UserEmailsURL = "https://api.github.com/user/emails"
+
+//UserInfoTimeout is the timeout for the fetching the user info from github auth agent
+var UserInfoTimeout = time.Duration(10000 * time.Millisecond)
+
+const (
+	//RedirectURLKey is the key storing for the github oauth redirect url
+	RedirectURLKey = "OAUTH2_GITHUB_REDIRECT_URL"
+	//ClientIDKey is the key storing for the github oauth client id
+	ClientIDKey = "OAUTH2_GITHUB_CLIENT_ID"
+	//ClientSecretKey is the key storing for the github oauth client secret
+	ClientSecretKey = "OAUTH2_GITHUB_CLIENT_SECRET"
+	//UserInfoURLKey is the key storing the url which gives github user info
+	UserInfoURLKey = "OAUTH2_GITHUB_USER_INFO_URL"
+	//UserEmailsURLKey is the key storing the url which gives github user's email addresses
+	UserEmailsURLKey = "OAUTH2_GITHUB_USER_EMAILS_URL"
+	//UserInfoNameKey is the key storing the name key in the github user info
+	UserInfoNameKey = "OAUTH2_GITHUB_USER_INFO_NAME"
+	//UserInfoEmailKey is the key storing the email key in the github user info
+	UserInfoEmailKey = "OAUTH2_GITHUB_USER_INFO_EMAIL"
+	//UserInfoPictureKey is the key storing the picture key in the github user info
+	UserInfoPictureKey = "OAUTH2_GITHUB_USER_INFO_PICTURE"
+	//UserInfoTimeoutKey is the key storing the user info github fetch timeout
+	UserInfoTimeoutKey = "OAUTH2_GITHUB_USER_INFO_TIMEOUT"
+	//ClaimMapKey is the key storing the claim mapping of the github user info. It overrides the user info keys
+	ClaimMapKey = "OAUTH2_GITHUB_CLAIM_MAP"
+)
+
+//LoadConfig will load the config required for the github oauth
+func LoadConfig() error {
+	/*
+	 * We will set the redirect url
+	 * Then client id
+	 * Then client secret
+	 * Then will initialize the github config
+	 * Then we will override the defaults for user info if given
+	 * 		URL
+	 * 		Emails URL
+	 * 		Name
+	 *		Email
+	 *		Picture
+	 *		Timeout
+	 */
+	//setting the oauth2 config
+	redirectURL := os.Getenv(RedirectURLKey)
+	if len(redirectURL) == 0 {
+		return errors.New("Github OAuth2 redirect url not found")
+	}
+	clientID := os.Getenv(ClientIDKey)
+	if len(clientID) == 0 {
+		return errors.New("Github OAuth2 client id not found")
+	}
+	clientsecret := os.Getenv(ClientSecretKey)
+	if len(clientsecret) == 0 {
+		return errors.New("Github OAuth2 client secret not found")
+	}
+
+	//setting the user info configs
+	if len(os.Getenv(UserInfoURLKey)) != 0 {
+		UserInfoURL = os.Getenv(UserInfoURLKey)
+	}
+	if len(os.Getenv(UserEmailsURLKey)) != 0 {
+		UserEmailsURL = os.Getenv(UserEmailsURLKey)
+	}
+	if len(os.Getenv(UserInfoNameKey)) != 0 {
+		UserInfoMap.Name = os.Getenv(UserInfoNameKey)
+	}
+	if len(os.Getenv(UserInfoEmailKey)) != 0 {
+		UserInfoMap.Email = os.Getenv(UserInfoEmailKey)
+	}
+	if len(os.Getenv(UserInfoPictureKey)) != 0 {
+		UserInfoMap.Picture = os.Getenv(UserInfoPictureKey)
+	}
+	if spec := os.Getenv(ClaimMapKey); len(spec) != 0 {
+		claims, err := claimmap.Parse(spec)
+		if err != nil {
+			return errors.New("Github OAuth2 claim mapping is invalid. " + err.Error())
+		}
+		UserInfoMap.Claims = claims
+	}
+	userInfoTimeout := os.Getenv(UserInfoTimeoutKey)
+	if len(userInfoTimeout) != 0 {
+		//if successful convert timeout
+		if t, err := strconv.ParseInt(userInfoTimeout, 10, 64); err == nil {
+			UserInfoTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	Config = &oauth2.Config{
+		RedirectURL:  redirectURL,
+		ClientID:     clientID,
+		ClientSecret: clientsecret,
+		Endpoint:     github.Endpoint,
+		Scopes: []string{
+			"read:user",
+			"user:email",
+		},
+	}
+
+	return nil
+}
+
+//oauth2Config returns the oauth2 config of the provider
+func oauth2Config(ctx context.Context) (*oauth2.Config, error) {
+	return Config, nil
+}
+
+func init() {
+	//github oauth is optional. We will load the config only if the client id is configured
+	if len(os.Getenv(ClientIDKey)) == 0 {
+		log.Println("Github OAuth2 client id not found. Github oauth is disabled")
+		return
+	}
+	err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	oauth.Register(&oauth.Provider{
+		Name:  oauth.GITHUB,
+		Label: "Github",
+		LoginURL: func(ctx context.Context, appCtx *config.AppContext, returnTo string) (string, error) {
+			return oauth.AuthCodeURL(appCtx, oauth.GITHUB, returnTo, Config)
+		},
+		CallbackPath: "/auth/github",
+		Callback:     oauth.OAuth2Callback(oauth.GITHUB, oauth2Config, &Agent{}),
+		OAuth2Config: oauth2Config,
+		Agent:        &Agent{},
+		Linkable:     true,
+	})
+}
+
+//Agent is the github oauth agent
+type Agent struct{}
+
+//email is an email address of the user as given by the github emails api
+type email struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+//Info returns the returns github user's info
+func (a *Agent) Info(ctx context.Context, appCtx *config.AppContext) (*config.UserInfo, error) {
+	/*
+	 * We will set the context first since we have a network call
+	 * We will hit the github api for user info
+	 * Then will set the properties based on the github user info api's response
+	 * If the email is private, we will fetch the verified primary email of the user
+	 * Else we will check whether the public email is verified
+	 * We will set the user model email with the info email
+	 */
+	//setting the conext since we have network call
+	u := appCtx.Session.User
+	newCtx, cancel := context.WithTimeout(ctx, UserInfoTimeout)
+	defer cancel()
+
+	//hitting the github apis
+	ui := map[string]interface{}{}
+	err := get(newCtx, UserInfoURL, u.AccessToken, &ui)
+	if err != nil {
+		appCtx.Log.Error("Error while getting the userinfo from the github")
+		return nil, err
+	}
+
+	//mapping the api response to the userinfo model
+	info, err := UserInfoMap.UserInfo(ui)
+	if err != nil {
+		appCtx.Log.Error("Error while mapping the user info from the github user info api")
+		return nil, err
+	}
+	//Github users needn't have a name, so we fallback to the login
+	if len(info.Name) == 0 {
+		info.Name, _ = ui["login"].(string)
+	}
+	//setting the subject. Github gives the numeric user id which doesn't change on renaming the login
+	if id, ok := ui["id"].(float64); ok {
+		info.Subject = strconv.FormatInt(int64(id), 10)
+	}
+
+	//if the email is private we will get the primary email from the emails api
+	if len(info.Email) == 0 {
+		info.Email, err = primaryEmail(newCtx, u.AccessToken)
+		if err != nil {
+			appCtx.Log.Error("Error while getting the user's primary email from the github emails api")
+			return nil, err
+		}
+		info.EmailVerified = true
+	} else {
+		info.EmailVerified, err = verifiedEmail(newCtx, u.AccessToken, info.Email)
+		if err != nil {
+			//the email is left unverified so that the user verifies it with us
+			appCtx.Log.Warn("Couldn't check whether the github user's email is verified", err.Error())
+		}
+	}
+
+	//setting the email of the user with info email
+	u.Email = info.Email
+	return info, nil
+}
+
+//primaryEmail returns the verified primary email of the user from the github emails api
+func primaryEmail(ctx context.Context, accessToken string) (string, error) {
+	emails := []email{}
+	err := get(ctx, UserEmailsURL, accessToken, &emails)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return e.Email, nil
+		}
+	}
+	return "", errors.New("No verified primary email found for the github user")
+}
+
+//verifiedEmail tells whether the given email is a verified email of the user as per the github emails api
+func verifiedEmail(ctx context.Context, accessToken string, address string) (bool, error) {
+	emails := []email{}
+	err := get(ctx, UserEmailsURL, accessToken, &emails)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range emails {
+		if e.Verified && strings.EqualFold(e.Email, address) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//get will do a get request to the github api with the access token and parse the json response to the result
+func get(ctx context.Context, u string, accessToken string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Github api " + u + " responded with status " + res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+//Name returns the github string as the name of the auth agent
+func (a *Agent) Name() string {
+	return oauth.GITHUB
+}
@@ -21,8 +21,11 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/cuttle-ai/auth-service/claimmap"
 	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/domainpolicy"
 	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/oauth/oidc"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
@@ -36,6 +39,12 @@ var UserInfoMap oauth.UserInfoMap
 //UserInfoURL is the url to hit to get the user info from google
 var UserInfoURL string
 
+//RevokeURL is the url at which the grants given by the users are revoked
+var RevokeURL = "https://oauth2.googleapis.com/revoke"
+
+//UserInfoFallback enables getting the user info from the user info url when google doesn't give an id token
+var UserInfoFallback bool
+
 //UserInfoTimeout is the timeout for the fetching the user info from google auth agent
 var UserInfoTimeout = time.Duration(10000 * time.Millisecond)
 
@@ -60,6 +69,17 @@ const (
 	UserInfoPictureKey = "OAUTH2_GOOGLE_USER_INFO_PICTURE"
 	//UserInfoTimeoutKey is the key storing the user info google fetch timeout
 	UserInfoTimeoutKey = "OAUTH2_GOOGLE_USER_INFO_TIMEOUT"
+	//ClaimMapKey is the key storing the claim mapping of the google user info. It overrides the user info keys
+	ClaimMapKey = "OAUTH2_GOOGLE_CLAIM_MAP"
+	//UserInfoFallbackKey is the key storing whether to get the user info from the user info url when google
+	//doesn't give an id token
+	UserInfoFallbackKey = "OAUTH2_GOOGLE_USER_INFO_FALLBACK"
+	//KeysURLKey is the key storing the url of the json web key set with which google signs the id tokens
+	KeysURLKey = "OAUTH2_GOOGLE_JWKS_URL"
+	//KeysRefreshIntervalKey is the key storing the interval in milliseconds at which the json web key set is refreshed
+	KeysRefreshIntervalKey = "OAUTH2_GOOGLE_JWKS_REFRESH_INTERVAL"
+	//RevokeURLKey is the key storing the url at which the grants given by the users are revoked
+	RevokeURLKey = "OAUTH2_GOOGLE_REVOKE_URL"
 )
 
 //LoadConfig will load the config required for the google oauth
@@ -72,10 +92,14 @@ func LoadConfig() error {
 	 * Then user email scope
 	 * Then will initialize the google config
 	 * Then we will set the configs for user info
+	 *		Fallback
 	 * 		URL
+	 *		Claim mapping
 	 * 		Name
 	 *		Email
 	 *		Picture
+	 * Then we will set the json web key set of the id tokens
+	 * Then we will set the revoke url
 	 */
 	//setting the oauth2 config
 	redirectURL := os.Getenv(RedirectURLKey)
@@ -100,20 +124,31 @@ func LoadConfig() error {
 	}
 
 	// //setting the user info configs
+	//the user info url is required only if the user info fallback is enabled
+	UserInfoFallback = os.Getenv(UserInfoFallbackKey) == "true"
 	userInfoURL := os.Getenv(UserInfoURLKey)
-	if len(userInfoURL) == 0 {
+	if len(userInfoURL) == 0 && UserInfoFallback {
 		return errors.New("Google OAuth2 user info url not found")
 	}
+	var claims *claimmap.Map
+	if spec := os.Getenv(ClaimMapKey); len(spec) != 0 {
+		c, err := claimmap.Parse(spec)
+		if err != nil {
+			return errors.New("Google OAuth2 claim mapping is invalid. " + err.Error())
+		}
+		claims = c
+	}
+	//the user info keys are required only if the claim mapping is not given
 	userInfoName := os.Getenv(UserInfoNameKey)
-	if len(userInfoName) == 0 {
+	if len(userInfoName) == 0 && claims == nil {
 		return errors.New("Google OAuth2 user info name key not found")
 	}
 	userInfoEmail := os.Getenv(UserInfoEmailKey)
-	if len(userInfoEmail) == 0 {
+	if len(userInfoEmail) == 0 && claims == nil {
 		return errors.New("Google OAuth2 user info email key not found")
 	}
 	userInfoPicture := os.Getenv(UserInfoPictureKey)
-	if len(userInfoPicture) == 0 {
+	if len(userInfoPicture) == 0 && claims == nil {
 		return errors.New("Google OAuth2 user info picture key not found")
 	}
 	userInfoTimeout := os.Getenv(UserInfoTimeoutKey)
@@ -130,6 +165,7 @@ func LoadConfig() error {
 		ClientSecret: clientsecret,
 		Endpoint:     google.Endpoint,
 		Scopes: []string{
+			"openid",
 			profileScope,
 			emailScope,
 		},
@@ -139,18 +175,72 @@ func LoadConfig() error {
 		Name:    userInfoName,
 		Email:   userInfoEmail,
 		Picture: userInfoPicture,
+		Claims:  claims,
 	}
 
 	UserInfoURL = userInfoURL
 
+	//setting the json web key set
+	if len(os.Getenv(KeysURLKey)) != 0 {
+		KeysURL = os.Getenv(KeysURLKey)
+	}
+	if t, err := strconv.ParseInt(os.Getenv(KeysRefreshIntervalKey), 10, 64); err == nil && t > 0 {
+		KeysRefreshInterval = time.Duration(t * int64(time.Millisecond))
+	}
+	Keys = oidc.NewKeySet(KeysURL)
+
+	//setting the revoke url
+	if len(os.Getenv(RevokeURLKey)) != 0 {
+		RevokeURL = os.Getenv(RevokeURLKey)
+	}
+
 	return nil
 }
 
+//authCodeOptions returns the options of the google login url. The account chooser is limited to the hosted domain
+//if only one is allowed by the domain policy and to the workspace accounts if more are allowed
+func authCodeOptions() []oauth2.AuthCodeOption {
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	hds := domainpolicy.Default.HostedDomains
+	if len(hds) == 1 {
+		opts = append(opts, oauth2.SetAuthURLParam("hd", hds[0]))
+	} else if len(hds) > 1 {
+		opts = append(opts, oauth2.SetAuthURLParam("hd", "*"))
+	}
+	return opts
+}
+
+//oauth2Config returns the oauth2 config of the provider
+func oauth2Config(ctx context.Context) (*oauth2.Config, error) {
+	return Config, nil
+}
+
 func init() {
+	/*
+	 * We will load the config if the google login is not switched off
+	 * Then we will register the provider
+	 */
+	if !oauth.Enabled(oauth.GOOGLE) {
+		log.Println("Google login is disabled")
+		return
+	}
 	err := LoadConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
+	Keys.RefreshEvery(KeysRefreshInterval)
+	oauth.Register(&oauth.Provider{
+		Name:  oauth.GOOGLE,
+		Label: "Google",
+		LoginURL: func(ctx context.Context, appCtx *config.AppContext, returnTo string) (string, error) {
+			return oauth.AuthCodeURL(appCtx, oauth.GOOGLE, returnTo, Config, authCodeOptions()...)
+		},
+		CallbackPath: "/auth/google",
+		Callback:     oauth.OAuth2Callback(oauth.GOOGLE, oauth2Config, &Agent{}),
+		OAuth2Config: oauth2Config,
+		Agent:        &Agent{},
+		Linkable:     true,
+	})
 }
 
 //Agent is the google oauth agent
@@ -158,71 +248,105 @@ type Agent struct{}
 
 //Info returns the returns google user's info
 func (a *Agent) Info(ctx context.Context, appCtx *config.AppContext) (*config.UserInfo, error) {
+	/*
+	 * We will get the claims from the verified id token
+	 * If google didn't give an id token and the fallback is enabled, we will get the claims from the user info api
+	 * We will check the hosted domain of the account against the domain policy
+	 * Then will set the properties based on the claims
+	 * We will set the user model email with the info email
+	 */
+	u := appCtx.Session.User
+	var claims map[string]interface{}
+	var err error
+	if len(u.IDToken) != 0 || !UserInfoFallback {
+		claims, err = VerifyIDToken(ctx, u.IDToken, u.Nonce)
+		if err != nil {
+			appCtx.Log.Error("Error while verifying the id token issued by google")
+			return nil, err
+		}
+	} else {
+		appCtx.Log.Info("Id token not found. Fetching the user info from google")
+		claims, err = userInfo(ctx, u.AccessToken)
+		if err != nil {
+			appCtx.Log.Error("Error while getting the userinfo from the google")
+			return nil, err
+		}
+	}
+
+	//checking the hosted domain
+	hd, _ := claims["hd"].(string)
+	err = domainpolicy.Default.CheckHostedDomain(hd)
+	if err != nil {
+		appCtx.Log.Warn("Google account of the hosted domain", hd, "is denied by the domain policy")
+		return nil, err
+	}
+
+	//mapping the claims to the userinfo model
+	info, err := UserInfoMap.UserInfo(claims)
+	if err != nil {
+		appCtx.Log.Error("Error while mapping the user info from the google claims")
+		return nil, err
+	}
+	if len(info.Email) == 0 {
+		appCtx.Log.Error("Error while getting the user's email from the google claims")
+		return nil, errors.New("Email not found in the google user info")
+	}
+	//setting the subject. The v2 user info api gives it as id and the openid connect one as sub
+	info.Subject, _ = claims["sub"].(string)
+	if len(info.Subject) == 0 {
+		info.Subject, _ = claims["id"].(string)
+	}
+
+	//setting the email of the user with info email
+	u.Email = info.Email
+	return info, nil
+}
+
+//Revoke revokes the grant given by the user at google
+func (a *Agent) Revoke(ctx context.Context, tok *oauth2.Token) error {
+	return oauth.RevokeToken(ctx, RevokeURL, "", "", tok)
+}
+
+//userInfo gets the user info from the google user info api. The access token is sent in the authorization header
+//and removed from the query of the user info url if it was configured there
+func userInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
 	/*
 	 * We will set the context first since we have a network call
 	 * We will hit the google api for user info
-	 * If any error happens we will return after logging it
 	 * Then we will parse the api response
-	 * Then will set the properties based on the google user info api's response
-	 * We will set the user model email with the info email
 	 */
-	//setting the conext since we have network call
-	u := appCtx.Session.User
 	newCtx, cancel := context.WithTimeout(ctx, UserInfoTimeout)
 	defer cancel()
 
 	//hitting the google apis
-	req, err := http.NewRequestWithContext(newCtx, http.MethodGet, UserInfoURL+url.QueryEscape(u.AccessToken), nil)
+	u, err := url.Parse(UserInfoURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Del("access_token")
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(newCtx, http.MethodGet, u.String(), nil)
 	if err != nil {
-		//handling the error while setting the request with context
-		appCtx.Log.Error("Error while setting the request to fetch the userinfo from google")
 		return nil, err
 	}
-	client := http.DefaultClient
-	res, err := client.Do(req)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		//handling the error while getting the info from the google
-		appCtx.Log.Error("Error while getting the userinfo from the google")
-		appCtx.Log.Error("Status code is ", req.Response.StatusCode)
 		return nil, err
 	}
 	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Google user info api responded with status " + res.Status)
+	}
 
 	//parsing the api response
 	ui := map[string]interface{}{}
-	//handling the error while parsing the api response
-	if err := json.NewDecoder(res.Body).Decode(&ui); err != nil {
-		appCtx.Log.Error("Error while parsing the userinfo api response from google")
+	err = json.NewDecoder(res.Body).Decode(&ui)
+	if err != nil {
 		return nil, err
 	}
-
-	//setting the info from the api response in the userinfo model
-	info := &config.UserInfo{}
-	//setting the name
-	v, ok := ui[UserInfoMap.Name]
-	if !ok {
-		appCtx.Log.Error("Error while getting the user's name from the google user info api")
-		return nil, errors.New("Key not found " + UserInfoMap.Name)
-	}
-	info.Name = v.(string)
-	//setting the email
-	v, ok = ui[UserInfoMap.Email]
-	if !ok {
-		appCtx.Log.Error("Error while getting the user's email from the google user info api")
-		return nil, errors.New("Key not found " + UserInfoMap.Email)
-	}
-	info.Email = v.(string)
-	//setting the picture
-	v, ok = ui[UserInfoMap.Picture]
-	if !ok {
-		appCtx.Log.Error("Error while getting the user's pciture from the google user info api")
-		return nil, errors.New("Key not found " + UserInfoMap.Picture)
-	}
-	info.Picture = v.(string)
-
-	//setting the email of the user with info email
-	u.Email = info.Email
-	return info, nil
+	return ui, nil
 }
 
 //Name returns the google string as the name of the auth agent
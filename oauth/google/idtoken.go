@@ -0,0 +1,78 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package google
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/jwt"
+	"github.com/cuttle-ai/auth-service/oauth/oidc"
+)
+
+/*
+ * This file contains the verification of the id tokens issued by google
+ */
+
+//Issuers are the issuer identifiers with which google issues the id tokens
+var Issuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+//KeysURL is the url of the json web key set with which google signs the id tokens
+var KeysURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+//KeysRefreshInterval is the interval at which the json web key set is refreshed in the background
+var KeysRefreshInterval = time.Duration(time.Hour)
+
+//ClockSkew is the allowed clock skew while validating the time claims of the id tokens
+var ClockSkew = time.Duration(time.Minute)
+
+//Keys is the cached json web key set of google
+var Keys *oidc.KeySet
+
+//VerifyIDToken verifies the signature and the claims of the id token issued by google and returns its claims.
+//nonce is the nonce expected in the token
+func VerifyIDToken(ctx context.Context, raw string, nonce string) (jwt.Claims, error) {
+	/*
+	 * We will verify the signature of the token against the cached key set
+	 * Then we will validate the issuer, audience and the time claims
+	 * Then we will check the nonce
+	 */
+	if len(raw) == 0 {
+		return nil, errors.New("No id token found for the user")
+	}
+	t, err := Keys.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	//validating the claims
+	issued := false
+	for _, iss := range Issuers {
+		if t.Claims.String("iss") == iss {
+			issued = true
+			break
+		}
+	}
+	if !issued {
+		return nil, errors.New("Id token issuer " + t.Claims.String("iss") + " is not google")
+	}
+	if !t.Claims.HasAudience(Config.ClientID) {
+		return nil, errors.New("Id token is not issued for the client " + Config.ClientID)
+	}
+	if azp := t.Claims.String("azp"); len(azp) != 0 && azp != Config.ClientID {
+		return nil, errors.New("Id token is authorized for another party " + azp)
+	}
+	err = t.Claims.ValidateTime(time.Now(), ClockSkew)
+	if err != nil {
+		return nil, err
+	}
+
+	//checking the nonce
+	if t.Claims.String("nonce") != nonce {
+		return nil, errors.New("Invalid nonce in the id token")
+	}
+	return t.Claims, nil
+}
@@ -19,6 +19,24 @@ import (
 const (
 	//GOOGLE is google oauth agent string
 	GOOGLE = "GOOGLE"
+	//GITHUB is github oauth agent string
+	GITHUB = "GITHUB"
+	//OIDC is the prefix of the openid connect oauth agent strings. The agent string is OIDC:<ISSUER NAME>
+	OIDC = "OIDC"
+	//SAML is the prefix of the saml auth agent strings. The agent string is SAML:<IDP NAME>
+	SAML = "SAML"
+	//LDAP is ldap directory auth agent string
+	LDAP = "LDAP"
+	//LOCAL is the auth agent string of the local accounts logging in with email and password
+	LOCAL = "LOCAL"
+	//MAGICLINK is the auth agent string of the users logging in with a link sent to their email
+	MAGICLINK = "MAGIC_LINK"
+	//WEBAUTHN is the auth agent string of the users logging in with a passkey
+	WEBAUTHN = "WEBAUTHN"
+	//TOTP is the auth agent string of the users verifying with a totp code as the second factor
+	TOTP = "TOTP"
+	//RECOVERYCODE is the auth agent string of the users verifying with a recovery code instead of the second factor
+	RECOVERYCODE = "RECOVERY_CODE"
 )
 
 //Agent is the oauth agent interface to be implmented. OAuth agents are google, facebook etc.
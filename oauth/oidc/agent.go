@@ -0,0 +1,197 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package oidc
+
+/*
+ * This file contains the openid connect oauth agent
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/jwt"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"golang.org/x/oauth2"
+)
+
+//ClockSkew is the allowed clock skew while validating the time claims of the id token
+var ClockSkew = time.Duration(time.Minute)
+
+//Agent is the openid connect oauth agent of an issuer
+type Agent struct {
+	//Issuer of the agent
+	Issuer *Issuer
+}
+
+//AgentName returns the auth agent name of the issuer with the given name
+func AgentName(issuer string) string {
+	return oauth.OIDC + ":" + issuer
+}
+
+//Info returns the user's info from the id token issued by the issuer
+func (a *Agent) Info(ctx context.Context, appCtx *config.AppContext) (*config.UserInfo, error) {
+	/*
+	 * We will verify the id token of the user and its nonce
+	 * Then will set the properties based on the claims
+	 * If the email isn't part of the claims we will fetch the claims from the user info endpoint
+	 * We will set the user model email with the info email
+	 */
+	u := appCtx.Session.User
+	claims, err := a.Issuer.VerifyIDToken(ctx, u.IDToken)
+	if err != nil {
+		appCtx.Log.Error("Error while verifying the id token issued by", a.Issuer.Name)
+		return nil, err
+	}
+	if claims.String("nonce") != u.Nonce {
+		appCtx.Log.Error("Nonce of the id token issued by", a.Issuer.Name, "doesn't match the login")
+		return nil, errors.New("Invalid nonce in the id token")
+	}
+
+	//setting the info from the claims
+	info, err := a.Issuer.UserInfoMap.UserInfo(claims)
+
+	//fetching the claims from the user info endpoint if the email or a required claim is missing
+	if err != nil || len(info.Email) == 0 {
+		appCtx.Log.Info("Claims missing in the id token. Fetching the user info from", a.Issuer.Name)
+		claims, err = a.Issuer.userInfo(ctx, u.AccessToken, claims.String("sub"))
+		if err != nil {
+			appCtx.Log.Error("Error while getting the user info from", a.Issuer.Name)
+			return nil, err
+		}
+		info, err = a.Issuer.UserInfoMap.UserInfo(claims)
+		if err != nil {
+			appCtx.Log.Error("Error while mapping the user info claims of", a.Issuer.Name)
+			return nil, err
+		}
+	}
+	info.Subject = claims.String("sub")
+	if len(info.Email) == 0 {
+		appCtx.Log.Error("Error while getting the user's email from the claims of", a.Issuer.Name)
+		return nil, errors.New("Email not found in the claims of " + a.Issuer.Name)
+	}
+
+	//setting the email of the user with info email
+	u.Email = info.Email
+	return info, nil
+}
+
+//Name returns the auth agent name of the issuer
+func (a *Agent) Name() string {
+	return AgentName(a.Issuer.Name)
+}
+
+//Revoke revokes the grant given by the user at the revocation endpoint of the issuer
+func (a *Agent) Revoke(ctx context.Context, tok *oauth2.Token) error {
+	d, err := a.Issuer.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	if len(d.RevocationEndpoint) == 0 {
+		return oauth.ErrRevokeNotSupported
+	}
+	return oauth.RevokeToken(ctx, d.RevocationEndpoint, a.Issuer.ClientID, a.Issuer.ClientSecret, tok)
+}
+
+//VerifyIDToken verifies the signature and the claims of the id token issued by the issuer
+func (i *Issuer) VerifyIDToken(ctx context.Context, raw string) (jwt.Claims, error) {
+	/*
+	 * We will get the discovery document of the issuer
+	 * Then we will verify the signature of the token against the issuer's key set
+	 * Then the algorithm is supported by the issuer
+	 * Then we will validate the issuer, audience and the time claims
+	 */
+	if len(raw) == 0 {
+		return nil, errors.New("No id token found for the user")
+	}
+	d, err := i.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//verifying the signature
+	t, err := i.keys.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	//checking the algorithm
+	if len(d.IDTokenSigningAlgs) != 0 {
+		supported := false
+		for _, alg := range d.IDTokenSigningAlgs {
+			if alg == t.Header.Alg {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return nil, errors.New("Id token algorithm " + t.Header.Alg + " not supported by the issuer")
+		}
+	}
+
+	//validating the claims
+	if t.Claims.String("iss") != d.Issuer {
+		return nil, errors.New("Id token issuer " + t.Claims.String("iss") + " doesn't match " + d.Issuer)
+	}
+	if !t.Claims.HasAudience(i.ClientID) {
+		return nil, errors.New("Id token is not issued for the client " + i.ClientID)
+	}
+	if azp := t.Claims.String("azp"); len(azp) != 0 && azp != i.ClientID {
+		return nil, errors.New("Id token is authorized for another party " + azp)
+	}
+	err = t.Claims.ValidateTime(time.Now(), ClockSkew)
+	if err != nil {
+		return nil, err
+	}
+	return t.Claims, nil
+}
+
+//userInfo will fetch the claims from the user info endpoint of the issuer
+func (i *Issuer) userInfo(ctx context.Context, accessToken string, sub string) (jwt.Claims, error) {
+	/*
+	 * We will get the user info endpoint from the discovery document
+	 * Then we will hit the endpoint with the access token
+	 * Then we will make sure the subject matches the id token
+	 */
+	d, err := i.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.UserInfoEndpoint) == 0 {
+		return nil, errors.New("Issuer doesn't have a user info endpoint")
+	}
+
+	//hitting the user info endpoint
+	newCtx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(newCtx, http.MethodGet, d.UserInfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("User info endpoint responded with status " + res.Status)
+	}
+	claims := jwt.Claims{}
+	err = json.NewDecoder(res.Body).Decode(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	//matching the subject
+	if claims.String("sub") != sub {
+		return nil, errors.New("Subject of the user info doesn't match the id token")
+	}
+	return claims, nil
+}
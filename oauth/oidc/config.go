@@ -0,0 +1,210 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package oidc has the implementation of a generic openid connect oauth agent.
+//Any number of issuers like Okta, Keycloak, Azure AD or Auth0 can be configured as named issuers
+package oidc
+
+/*
+ * This file contains the configuration of the openid connect issuers
+ */
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/claimmap"
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+)
+
+const (
+	//IssuersKey is the key storing the comma separated names of the openid connect issuers
+	IssuersKey = "OIDC_ISSUERS"
+	//TimeoutKey is the key storing the timeout for the network calls to the issuers in milliseconds
+	TimeoutKey = "OIDC_TIMEOUT"
+)
+
+//Suffixes of the keys storing the config of an issuer. The key of a config is
+//OIDC_<ISSUER NAME>_<SUFFIX>. Eg. OIDC_OKTA_CLIENT_ID
+const (
+	//IssuerURLSuffix is the suffix of the key storing the issuer url
+	IssuerURLSuffix = "ISSUER_URL"
+	//ClientIDSuffix is the suffix of the key storing the client id
+	ClientIDSuffix = "CLIENT_ID"
+	//ClientSecretSuffix is the suffix of the key storing the client secret
+	ClientSecretSuffix = "CLIENT_SECRET"
+	//RedirectURLSuffix is the suffix of the key storing the redirect url
+	RedirectURLSuffix = "REDIRECT_URL"
+	//ScopesSuffix is the suffix of the key storing the space separated scopes. Default is openid email profile
+	ScopesSuffix = "SCOPES"
+	//LabelSuffix is the suffix of the key storing the display label of the issuer. Default is the issuer name
+	LabelSuffix = "LABEL"
+	//UserInfoNameSuffix is the suffix of the key storing the name claim. Default is name
+	UserInfoNameSuffix = "USER_INFO_NAME"
+	//UserInfoEmailSuffix is the suffix of the key storing the email claim. Default is email
+	UserInfoEmailSuffix = "USER_INFO_EMAIL"
+	//UserInfoPictureSuffix is the suffix of the key storing the picture claim. Default is picture
+	UserInfoPictureSuffix = "USER_INFO_PICTURE"
+	//ClaimMapSuffix is the suffix of the key storing the claim mapping. It overrides the user info claims
+	ClaimMapSuffix = "CLAIM_MAP"
+)
+
+//Timeout is the timeout for the network calls to the issuers
+var Timeout = time.Duration(10000 * time.Millisecond)
+
+//Issuers has the configured openid connect issuers mapped to their name
+var Issuers = map[string]*Issuer{}
+
+//Issuer is an openid connect issuer
+type Issuer struct {
+	//Name of the issuer
+	Name string
+	//Label is the display label of the issuer
+	Label string
+	//URL of the issuer. The discovery document is available at URL/.well-known/openid-configuration
+	URL string
+	//ClientID is the oauth client id registered with the issuer
+	ClientID string
+	//ClientSecret is the oauth client secret registered with the issuer
+	ClientSecret string
+	//RedirectURL is the callback url registered with the issuer
+	RedirectURL string
+	//Scopes to be requested from the issuer
+	Scopes []string
+	//UserInfoMap maps the claims of the issuer to the user info
+	UserInfoMap oauth.UserInfoMap
+	//discovery is the cached discovery document of the issuer
+	discovery *Discovery
+	//keys is the json web key set of the issuer
+	keys *KeySet
+	//lock for the discovery document
+	lock sync.Mutex
+}
+
+//LoadConfig will load the config of all the openid connect issuers
+func LoadConfig() error {
+	/*
+	 * We will set the timeout
+	 * We will get the list of issuer names
+	 * Then we will load each issuer's config
+	 */
+	//setting the timeout
+	if t, err := strconv.ParseInt(os.Getenv(TimeoutKey), 10, 64); err == nil {
+		Timeout = time.Duration(t * int64(time.Millisecond))
+	}
+
+	//getting the list of issuers
+	issuers := map[string]*Issuer{}
+	for _, name := range strings.Split(os.Getenv(IssuersKey), ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		iss, err := loadIssuer(name)
+		if err != nil {
+			return err
+		}
+		issuers[iss.Name] = iss
+	}
+
+	Issuers = issuers
+	return nil
+}
+
+//loadIssuer loads the config of the issuer with the given name
+func loadIssuer(name string) (*Issuer, error) {
+	name = strings.ToUpper(name)
+	env := func(suffix string) string {
+		return os.Getenv("OIDC_" + name + "_" + suffix)
+	}
+	envOr := func(suffix string, def string) string {
+		if v := env(suffix); len(v) != 0 {
+			return v
+		}
+		return def
+	}
+
+	iss := &Issuer{
+		Name:         name,
+		Label:        envOr(LabelSuffix, name),
+		URL:          env(IssuerURLSuffix),
+		ClientID:     env(ClientIDSuffix),
+		ClientSecret: env(ClientSecretSuffix),
+		RedirectURL:  env(RedirectURLSuffix),
+		Scopes:       strings.Fields(envOr(ScopesSuffix, "openid email profile")),
+		UserInfoMap: oauth.UserInfoMap{
+			Name:    envOr(UserInfoNameSuffix, "name"),
+			Email:   envOr(UserInfoEmailSuffix, "email"),
+			Picture: envOr(UserInfoPictureSuffix, "picture"),
+		},
+	}
+	if spec := env(ClaimMapSuffix); len(spec) != 0 {
+		claims, err := claimmap.Parse(spec)
+		if err != nil {
+			return nil, errors.New("OpenID Connect claim mapping is invalid for " + name + ". " + err.Error())
+		}
+		iss.UserInfoMap.Claims = claims
+	}
+	if len(iss.URL) == 0 {
+		return nil, errors.New("OpenID Connect issuer url not found for " + name)
+	}
+	if len(iss.ClientID) == 0 {
+		return nil, errors.New("OpenID Connect client id not found for " + name)
+	}
+	if len(iss.ClientSecret) == 0 {
+		return nil, errors.New("OpenID Connect client secret not found for " + name)
+	}
+	if len(iss.RedirectURL) == 0 {
+		return nil, errors.New("OpenID Connect redirect url not found for " + name)
+	}
+	return iss, nil
+}
+
+//Provider returns the login provider of the issuer
+func (i *Issuer) Provider() *oauth.Provider {
+	a := &Agent{Issuer: i}
+	return &oauth.Provider{
+		Name:  AgentName(i.Name),
+		Label: i.Label,
+		LoginURL: func(ctx context.Context, appCtx *config.AppContext, returnTo string) (string, error) {
+			conf, err := i.OAuth2Config(ctx)
+			if err != nil {
+				return "", err
+			}
+			return oauth.AuthCodeURL(appCtx, AgentName(i.Name), returnTo, conf)
+		},
+		CallbackPath: "/auth/oidc/" + strings.ToLower(i.Name),
+		Callback:     oauth.OAuth2Callback(AgentName(i.Name), i.OAuth2Config, a),
+		OAuth2Config: i.OAuth2Config,
+		Agent:        a,
+		Linkable:     true,
+	}
+}
+
+func init() {
+	/*
+	 * We will load the config
+	 * Then we will register the issuers in the order of their names
+	 */
+	err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	names := []string{}
+	for name := range Issuers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		oauth.Register(Issuers[name].Provider())
+	}
+}
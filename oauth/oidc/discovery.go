@@ -0,0 +1,219 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package oidc
+
+/*
+ * This file contains the discovery document and json web key set fetch of the issuers
+ */
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/jwt"
+	"golang.org/x/oauth2"
+)
+
+//KeysRefreshInterval is the minimum interval between two fetches of the json web key set
+//when an unknown key id is encountered
+var KeysRefreshInterval = time.Duration(time.Minute)
+
+//Discovery is the openid connect discovery document of an issuer
+type Discovery struct {
+	//Issuer is the issuer identifier
+	Issuer string `json:"issuer"`
+	//AuthorizationEndpoint is the url of the authorization endpoint
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	//TokenEndpoint is the url of the token endpoint
+	TokenEndpoint string `json:"token_endpoint"`
+	//UserInfoEndpoint is the url of the user info endpoint
+	UserInfoEndpoint string `json:"userinfo_endpoint"`
+	//JWKSURI is the url of the json web key set of the issuer
+	JWKSURI string `json:"jwks_uri"`
+	//RevocationEndpoint is the url of the token revocation endpoint. It is empty if the issuer doesn't support it
+	RevocationEndpoint string `json:"revocation_endpoint"`
+	//IDTokenSigningAlgs are the algorithms supported by the issuer for signing the id token
+	IDTokenSigningAlgs []string `json:"id_token_signing_alg_values_supported"`
+}
+
+//Discover returns the discovery document of the issuer. The document is fetched once and cached
+func (i *Issuer) Discover(ctx context.Context) (*Discovery, error) {
+	/*
+	 * If we have the discovery document cached, we will return it
+	 * We will fetch the discovery document
+	 * Will validate the issuer in the document
+	 * Then cache the document and initialize the key set of the issuer
+	 */
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.discovery != nil {
+		return i.discovery, nil
+	}
+
+	//fetching the discovery document
+	d := &Discovery{}
+	err := getJSON(ctx, strings.TrimSuffix(i.URL, "/")+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, err
+	}
+
+	//validating the issuer. It has to match the configured url exactly including any trailing slash
+	if d.Issuer != i.URL {
+		return nil, errors.New("Issuer in the discovery document " + d.Issuer + " doesn't match " + i.URL)
+	}
+
+	//caching the document
+	i.discovery = d
+	i.keys = NewKeySet(d.JWKSURI)
+	return d, nil
+}
+
+//OAuth2Config returns the oauth2 config of the issuer based on its discovery document
+func (i *Issuer) OAuth2Config(ctx context.Context) (*oauth2.Config, error) {
+	d, err := i.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Config{
+		ClientID:     i.ClientID,
+		ClientSecret: i.ClientSecret,
+		RedirectURL:  i.RedirectURL,
+		Scopes:       i.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  d.AuthorizationEndpoint,
+			TokenURL: d.TokenEndpoint,
+		},
+	}, nil
+}
+
+//KeySet is the json web key set fetched from a remote url
+type KeySet struct {
+	//URL of the key set
+	URL string
+	//keys is the last fetched key set
+	keys jwt.JSONWebKeySet
+	//fetched is the time at which the key set was last fetched
+	fetched time.Time
+	lock    sync.Mutex
+}
+
+//NewKeySet returns a new key set for the given url
+func NewKeySet(url string) *KeySet {
+	return &KeySet{URL: url}
+}
+
+//Refresh will fetch the key set from its url
+func (k *KeySet) Refresh(ctx context.Context) error {
+	keys := jwt.JSONWebKeySet{}
+	err := getJSON(ctx, k.URL, &keys)
+	if err != nil {
+		return err
+	}
+	k.lock.Lock()
+	k.keys = keys
+	k.fetched = time.Now()
+	k.lock.Unlock()
+	return nil
+}
+
+//RefreshEvery refreshes the key set in the background at the given interval so that the rotated keys
+//are known before the tokens signed with them arrive. It returns the func which stops the refresh
+func (k *KeySet) RefreshEvery(interval time.Duration) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := k.Refresh(context.Background()); err != nil {
+				log.Println("Error while refreshing the json web key set from", k.URL, err.Error())
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+//Key returns the public key with the given key id. If the key is not found in
+//the cached set, the set will be fetched again
+func (k *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	/*
+	 * We will look for the key in the cached set
+	 * If not found and the set wasn't fetched recently, we will refresh the set
+	 * Then we will look for the key again
+	 */
+	k.lock.Lock()
+	key, ok := k.keys.Key(kid)
+	stale := time.Since(k.fetched) > KeysRefreshInterval
+	k.lock.Unlock()
+	if ok {
+		return key.PublicKey()
+	}
+
+	//refreshing the set if not refreshed recently
+	if !stale {
+		return nil, errors.New("Key " + kid + " not found in the key set")
+	}
+	err := k.Refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.lock.Lock()
+	key, ok = k.keys.Key(kid)
+	k.lock.Unlock()
+	if !ok {
+		return nil, errors.New("Key " + kid + " not found in the key set")
+	}
+	return key.PublicKey()
+}
+
+//Verify parses the raw token and verifies its signature against the key set
+func (k *KeySet) Verify(ctx context.Context, raw string) (*jwt.Token, error) {
+	t, err := jwt.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.Key(ctx, t.Header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	err = t.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+//getJSON will do a get request to the given url and parse the json response to the result
+func getJSON(ctx context.Context, u string, result interface{}) error {
+	newCtx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(newCtx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New(u + " responded with status " + res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
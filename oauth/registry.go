@@ -0,0 +1,210 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"golang.org/x/oauth2"
+)
+
+/*
+ * This file contains the registry of the login providers. Providers register themselves in their init
+ * and the login urls and the callback routes are generated from the registry
+ */
+
+const (
+	//ProvidersEnabledKey is the key storing the comma separated names of the providers to be enabled.
+	//All the configured providers are enabled if it is not set
+	ProvidersEnabledKey = "AUTH_PROVIDERS_ENABLED"
+	//ProvidersDisabledKey is the key storing the comma separated names of the providers to be disabled
+	ProvidersDisabledKey = "AUTH_PROVIDERS_DISABLED"
+)
+
+//LoginURLFunc returns the url to which the user is sent to log in with the provider. returnTo is the allowed url
+//to which the user is redirected after the login. It is empty if the user has to be redirected to the frontend
+type LoginURLFunc func(ctx context.Context, appCtx *config.AppContext, returnTo string) (string, error)
+
+//CallbackFunc completes the login at the callback of the provider and returns the user info. It sets the user
+//of the session with the auth agent string of the provider and the url to return to
+type CallbackFunc func(ctx context.Context, appCtx *config.AppContext, r *http.Request) (*config.UserInfo, error)
+
+//Provider is a login provider
+type Provider struct {
+	//Name is the auth agent string of the provider. Eg. GOOGLE, OIDC:OKTA
+	Name string
+	//Label is the display label of the provider
+	Label string
+	//LoginURL builds the login url. It is nil for the providers without a login redirect like ldap
+	LoginURL LoginURLFunc
+	//CallbackPath is the path of the callback route of the provider
+	CallbackPath string
+	//Callback completes the login at the callback route
+	Callback CallbackFunc
+	//OAuth2Config returns the oauth2 config with which the tokens of the provider are refreshed.
+	//It is nil for the providers without oauth2 tokens
+	OAuth2Config func(ctx context.Context) (*oauth2.Config, error)
+	//Agent fetches the user info of the logged in users. It is nil if the provider can't refresh the user info
+	Agent Agent
+	//Linkable tells whether the identities of the provider can be linked by the logged in users
+	Linkable bool
+	//Repost tells that the provider posts to the callback cross site, so the browser doesn't send the session
+	//cookie. The browser is asked to post the callback again from the auth service
+	Repost bool
+}
+
+//CallbackError is returned by the callbacks for the failures to be reported to the user with the given status
+type CallbackError struct {
+	//Status is the http status of the response
+	Status int
+	//Message is the message shown to the user
+	Message string
+	//Err is the underlying error
+	Err error
+}
+
+func (c *CallbackError) Error() string {
+	if c.Err == nil {
+		return c.Message
+	}
+	return c.Message + ": " + c.Err.Error()
+}
+
+//registry has the registered providers
+var registry = struct {
+	providers []*Provider
+	byName    map[string]*Provider
+	lock      sync.RWMutex
+}{byName: map[string]*Provider{}}
+
+//enabled and disabled are the provider names configured to be enabled and disabled
+var enabled, disabled []string
+
+func init() {
+	enabled = names(os.Getenv(ProvidersEnabledKey))
+	disabled = names(os.Getenv(ProvidersDisabledKey))
+}
+
+//Enabled tells whether the provider of the given name is switched on in the config. A provider can be switched
+//by its full name or its prefix. Eg. OIDC switches all the openid connect issuers
+func Enabled(name string) bool {
+	if matches(disabled, name) {
+		return false
+	}
+	return len(enabled) == 0 || matches(enabled, name)
+}
+
+//Register registers the provider if it is enabled. Registering a provider twice will panic
+func Register(p *Provider) {
+	if !Enabled(p.Name) {
+		log.Println("Login provider", p.Name, "is disabled")
+		return
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if _, ok := registry.byName[p.Name]; ok {
+		panic("login provider " + p.Name + " is registered twice")
+	}
+	registry.providers = append(registry.providers, p)
+	registry.byName[p.Name] = p
+}
+
+//Providers returns the registered providers in the order of registration
+func Providers() []*Provider {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return append([]*Provider{}, registry.providers...)
+}
+
+//GetProvider returns the registered provider of the given auth agent string. It returns nil if not registered
+func GetProvider(name string) *Provider {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return registry.byName[name]
+}
+
+//GetAgent returns the agent of the given auth agent string. It returns nil if the provider is not registered
+//or has no agent
+func GetAgent(name string) Agent {
+	p := GetProvider(name)
+	if p == nil {
+		return nil
+	}
+	return p.Agent
+}
+
+//OAuth2Callback returns the callback of an oauth2 provider. It exchanges the code for the token and
+//gets the user info from the agent
+func OAuth2Callback(name string, conf func(ctx context.Context) (*oauth2.Config, error), agent Agent) CallbackFunc {
+	return func(ctx context.Context, appCtx *config.AppContext, r *http.Request) (*config.UserInfo, error) {
+		/*
+		 * We will check the state of the callback
+		 * We will get the oauth2 config
+		 * Will get the token from the auth agent's exchange with the pkce code verifier
+		 * We will get user info from the auth agent with the user of the token
+		 * Then we will set the user in the session. It is set only if the login succeeds so that a failed
+		 * login doesn't leave the token in the session
+		 */
+		st, err := ConsumeState(appCtx, name, r.URL.Query().Get("state"))
+		if err != nil {
+			return nil, &CallbackError{Status: http.StatusForbidden, Message: err.Error(), Err: err}
+		}
+		c, err := conf(ctx)
+		if err != nil {
+			return nil, &CallbackError{Status: http.StatusBadGateway, Message: "Sorry couldn't complete your oauth", Err: err}
+		}
+
+		//we will get the token from the auth agent's exchange
+		tok, err := c.Exchange(ctx, r.URL.Query().Get("code"), oauth2.SetAuthURLParam("code_verifier", st.Verifier))
+		if err != nil {
+			return nil, &CallbackError{Status: http.StatusForbidden, Message: "Sorry couldn't complete your oauth", Err: err}
+		}
+
+		//getting the user info from the auth agent. The agents read the user from a copy of the app context
+		user := &config.User{AccessToken: tok.AccessToken, AuthAgent: name, Nonce: st.Nonce, ProviderToken: tok}
+		if idToken, ok := tok.Extra("id_token").(string); ok {
+			user.IDToken = idToken
+		}
+		infoCtx := *appCtx
+		infoCtx.Session.User = user
+		info, err := Info(ctx, &infoCtx, agent)
+		if err != nil {
+			return nil, &CallbackError{Status: http.StatusForbidden, Message: "Sorry couldn't complete your oauth", Err: err}
+		}
+
+		//we will set the user
+		appCtx.Session.User = user
+		appCtx.Session.ReturnTo = st.ReturnTo
+		return info, nil
+	}
+}
+
+//names returns the trimmed non empty names in the comma separated list
+func names(list string) []string {
+	result := []string{}
+	for _, n := range strings.Split(list, ",") {
+		if n = strings.TrimSpace(n); len(n) != 0 {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+//matches tells whether the name or its prefix is in the list
+func matches(list []string, name string) bool {
+	prefix := strings.SplitN(name, ":", 2)[0]
+	for _, n := range list {
+		if strings.EqualFold(n, name) || strings.EqualFold(n, prefix) {
+			return true
+		}
+	}
+	return false
+}
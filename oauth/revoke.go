@@ -0,0 +1,111 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"golang.org/x/oauth2"
+)
+
+/*
+ * This file contains the revocation of the grants given by the users to the platform at the auth agents
+ */
+
+//Reasons for revoking the grants recorded in the audit trail
+const (
+	//RevokeOnLogout is the reason for revoking the grants when the user logs out
+	RevokeOnLogout = "logout"
+	//RevokeOnAccountDeletion is the reason for revoking the grants when the user deletes the account
+	RevokeOnAccountDeletion = "account deletion"
+	//RevokeOnForcedSignOut is the reason for revoking the grants when an admin signs out the user
+	RevokeOnForcedSignOut = "forced sign out"
+)
+
+//RevokeTimeout is the timeout for revoking a grant at the auth agent
+var RevokeTimeout = time.Duration(10 * time.Second)
+
+//ErrRevokeNotSupported is returned by the revokers when the auth agent doesn't support revoking the grants
+var ErrRevokeNotSupported = errors.New("Auth agent doesn't support revoking the grants")
+
+//Revoker is the optional capability of the agents which can revoke the grant given by the user to the platform
+type Revoker interface {
+	//Revoke revokes the grant of the token at the auth agent
+	Revoke(ctx context.Context, tok *oauth2.Token) error
+}
+
+//RevokeToken revokes the token at the rfc 7009 revocation endpoint. The refresh token is revoked if present
+//since it revokes the whole grant, else the access token. The client is authenticated if the client id is given
+func RevokeToken(ctx context.Context, endpoint string, clientID string, clientSecret string, tok *oauth2.Token) error {
+	/*
+	 * We will build the revocation request
+	 * Then we will send it to the endpoint
+	 */
+	form := url.Values{"token": {tok.AccessToken}, "token_type_hint": {"access_token"}}
+	if len(tok.RefreshToken) != 0 {
+		form = url.Values{"token": {tok.RefreshToken}, "token_type_hint": {"refresh_token"}}
+	}
+	newCtx, cancel := context.WithTimeout(ctx, RevokeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(newCtx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(clientID) != 0 {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	//sending the request
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("Revocation endpoint responded with status " + res.Status)
+	}
+	return nil
+}
+
+//RevokeGrants revokes the grants of the identities of the user at the auth agents which support it. The stored
+//tokens of the revoked grants are deleted. The results are recorded in the audit trail with the reason
+func RevokeGrants(ctx context.Context, appCtx *config.AppContext, info *config.UserInfo, reason string, ip string) {
+	ids, err := info.GetIdentities(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while getting the identities of", info.ID, "for revoking the grants", err.Error())
+		return
+	}
+	for i := range ids {
+		revoker, ok := GetAgent(ids[i].Provider).(Revoker)
+		pt := ids[i].GetProviderToken(*appCtx)
+		if !ok || pt == nil {
+			continue
+		}
+		tok, err := pt.Token()
+		if err == nil {
+			err = revoker.Revoke(ctx, tok)
+		}
+		if err == ErrRevokeNotSupported {
+			continue
+		}
+		if err != nil {
+			appCtx.Log.Error("error while revoking the grant of", ids[i].Provider, "for", info.ID, err.Error())
+			info.Audit(*appCtx, config.AuditGrantRevokeFailed, ids[i].Provider+" on "+reason+": "+err.Error(), ip)
+			continue
+		}
+		if err := pt.Delete(*appCtx); err != nil {
+			appCtx.Log.Error("error while deleting the revoked token of the identity", ids[i].ID, err.Error())
+		}
+		appCtx.Log.Info("revoked the grant of", ids[i].Provider, "for", info.ID, "on", reason)
+		info.Audit(*appCtx, config.AuditGrantRevoked, ids[i].Provider+" on "+reason, ip)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"golang.org/x/oauth2"
+)
+
+/*
+ * This file contains the state, pkce and nonce of the oauth logins. The states are stored in the session and
+ * checked on the callback so that the login can't be forged by another site
+ */
+
+//StateTimeout is the time within which the oauth login has to be completed
+var StateTimeout = time.Duration(10 * time.Minute)
+
+//MaxStates is the maximum number of pending oauth logins kept in a session. The oldest ones are dropped
+const MaxStates = 16
+
+var (
+	//ErrInvalidState is returned when the state of the callback wasn't issued to the session for the provider
+	ErrInvalidState = errors.New("Invalid oauth state")
+	//ErrStateExpired is returned when the state of the callback has expired
+	ErrStateExpired = errors.New("Oauth state has expired")
+)
+
+//random returns a url safe random string of the given number of bytes
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//NewState creates a state with a pkce code verifier and a nonce for the login with the provider and
+//stores it in the session along with the allowed url to return to. The caller has to save the session
+func NewState(appCtx *config.AppContext, provider string, returnTo string) (string, config.OAuthState, error) {
+	/*
+	 * We will generate the state, the code verifier and the nonce
+	 * We will copy the pending states of the session dropping the expired and the oldest ones
+	 * Then we will add the new state to the session
+	 */
+	st := config.OAuthState{Provider: provider, ReturnTo: returnTo, Expires: time.Now().Add(StateTimeout)}
+	key, err := random(32)
+	if err == nil {
+		st.Verifier, err = random(32)
+	}
+	if err == nil {
+		st.Nonce, err = random(16)
+	}
+	if err != nil {
+		return "", st, err
+	}
+
+	//copying the pending states. The map is copied since the stored session shares it
+	states := map[string]config.OAuthState{}
+	var oldest string
+	for k, v := range appCtx.Session.OAuthStates {
+		if v.Expires.Before(time.Now()) {
+			continue
+		}
+		states[k] = v
+		if len(oldest) == 0 || v.Expires.Before(states[oldest].Expires) {
+			oldest = k
+		}
+	}
+	if len(states) >= MaxStates {
+		delete(states, oldest)
+	}
+
+	//adding the new state
+	states[key] = st
+	appCtx.Session.OAuthStates = states
+	return key, st, nil
+}
+
+//ConsumeState removes the given state from the session and returns it if it was issued for the provider and
+//hasn't expired. The caller has to save the session
+func ConsumeState(appCtx *config.AppContext, provider string, key string) (config.OAuthState, error) {
+	st, ok := appCtx.Session.OAuthStates[key]
+	if !ok || len(key) == 0 || st.Provider != provider {
+		return st, ErrInvalidState
+	}
+	states := map[string]config.OAuthState{}
+	for k, v := range appCtx.Session.OAuthStates {
+		if k != key {
+			states[k] = v
+		}
+	}
+	appCtx.Session.OAuthStates = states
+	if st.Expires.Before(time.Now()) {
+		return st, ErrStateExpired
+	}
+	return st, nil
+}
+
+//AuthCodeURL returns the auth code url of the oauth2 config with a new state, the pkce challenge and the nonce
+//for the login with the provider. The caller has to save the session
+func AuthCodeURL(appCtx *config.AppContext, provider string, returnTo string, conf *oauth2.Config, opts ...oauth2.AuthCodeOption) (string, error) {
+	key, st, err := NewState(appCtx, provider, returnTo)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(st.Verifier))
+	opts = append(opts,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("nonce", st.Nonce),
+	)
+	return conf.AuthCodeURL(key, opts...), nil
+}
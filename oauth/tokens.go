@@ -0,0 +1,264 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package oauth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/log"
+	"golang.org/x/oauth2"
+)
+
+/*
+ * This file contains the storage and the refresh of the tokens given by the providers. The tokens are stored
+ * encrypted per identity, refreshed in the background before they expire and given to the platform services
+ * through the rpc
+ */
+
+const (
+	//TokenRefreshIntervalKey is the key storing the interval in milliseconds at which the expiring tokens are refreshed
+	TokenRefreshIntervalKey = "OAUTH2_TOKEN_REFRESH_INTERVAL"
+	//TokenRefreshWindowKey is the key storing the time in milliseconds before the expiry at which a token is refreshed
+	TokenRefreshWindowKey = "OAUTH2_TOKEN_REFRESH_WINDOW"
+)
+
+var (
+	//TokenRefreshInterval is the interval at which the expiring tokens are refreshed in the background
+	TokenRefreshInterval = time.Duration(time.Minute)
+	//TokenRefreshWindow is the time before the expiry at which a token is refreshed
+	TokenRefreshWindow = time.Duration(5 * time.Minute)
+	//TokenRefreshTimeout is the timeout for refreshing a token with the provider
+	TokenRefreshTimeout = time.Duration(10 * time.Second)
+)
+
+var (
+	//ErrTokenNotFound is returned when no token is stored for the identity
+	ErrTokenNotFound = errors.New("Token of the identity not found")
+	//ErrTokenExpired is returned when the stored token has expired and can't be refreshed
+	ErrTokenExpired = errors.New("Token of the identity has expired and can't be refreshed")
+	//ErrRPCUnauthorized is returned when the caller of the rpc is not a platform service
+	ErrRPCUnauthorized = errors.New("Caller is not authorized")
+)
+
+//refreshLock serializes the refreshes so that a refresh token isn't used concurrently
+var refreshLock sync.Mutex
+
+func init() {
+	if t, err := strconv.ParseInt(os.Getenv(TokenRefreshIntervalKey), 10, 64); err == nil && t > 0 {
+		TokenRefreshInterval = time.Duration(t * int64(time.Millisecond))
+	}
+	if t, err := strconv.ParseInt(os.Getenv(TokenRefreshWindowKey), 10, 64); err == nil && t > 0 {
+		TokenRefreshWindow = time.Duration(t * int64(time.Millisecond))
+	}
+	config.RegisterRPC(new(RPCTokens))
+}
+
+//StoreToken stores the token given by the provider for the identity. A stored refresh token is kept
+//if the provider didn't give a new one
+func StoreToken(appCtx *config.AppContext, identity *config.Identity, tok *oauth2.Token) error {
+	if config.TokenBox == nil {
+		return config.ErrTokenStorageDisabled
+	}
+	pt := identity.GetProviderToken(*appCtx)
+	if pt == nil {
+		pt = &config.ProviderToken{IdentityID: identity.ID, UserID: identity.UserID, Provider: identity.Provider}
+	}
+	err := pt.SetToken(tok)
+	if err != nil {
+		return err
+	}
+	return pt.Save(*appCtx)
+}
+
+//FreshToken returns the token of the identity. The token is refreshed if it expires within the refresh window
+func FreshToken(ctx context.Context, appCtx *config.AppContext, identity *config.Identity) (*oauth2.Token, error) {
+	pt := identity.GetProviderToken(*appCtx)
+	if pt == nil {
+		return nil, ErrTokenNotFound
+	}
+	if !expiring(pt.Expiry) {
+		return pt.Token()
+	}
+	return refresh(ctx, appCtx, pt.IdentityID)
+}
+
+//RefreshTokens refreshes the stored tokens expiring within the refresh window
+func RefreshTokens(ctx context.Context, appCtx *config.AppContext) {
+	tokens, err := config.GetExpiringProviderTokens(*appCtx, time.Now().Add(TokenRefreshWindow))
+	if err != nil {
+		appCtx.Log.Error("error while getting the expiring provider tokens", err.Error())
+		return
+	}
+	for _, pt := range tokens {
+		_, err := refresh(ctx, appCtx, pt.IdentityID)
+		if err != nil {
+			appCtx.Log.Error("error while refreshing the token of the identity", pt.IdentityID, err.Error())
+		}
+	}
+}
+
+//StartTokenRefresher refreshes the expiring tokens in the background at the refresh interval.
+//It returns the function to stop the refresher
+func StartTokenRefresher(appCtx *config.AppContext) func() {
+	ticker := time.NewTicker(TokenRefreshInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				RefreshTokens(context.Background(), appCtx)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+//refresh refreshes the token of the identity with the provider and stores it. The token is reloaded after
+//acquiring the lock since it may have been refreshed in the meantime. A refresh token rejected by the provider
+//is dropped so that it isn't retried
+func refresh(ctx context.Context, appCtx *config.AppContext, identityID uint) (*oauth2.Token, error) {
+	/*
+	 * We will reload the token
+	 * We will get the oauth2 config of the provider
+	 * Then we will refresh the token with the provider and store it
+	 */
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+
+	//reloading the token
+	identity := &config.Identity{}
+	identity.ID = identityID
+	pt := identity.GetProviderToken(*appCtx)
+	if pt == nil {
+		return nil, ErrTokenNotFound
+	}
+	tok, err := pt.Token()
+	if err != nil {
+		return nil, err
+	}
+	if !expiring(tok.Expiry) {
+		return tok, nil
+	}
+	if len(tok.RefreshToken) == 0 {
+		return nil, ErrTokenExpired
+	}
+
+	//getting the oauth2 config
+	p := GetProvider(pt.Provider)
+	if p == nil || p.OAuth2Config == nil {
+		return nil, errors.New("Tokens of " + pt.Provider + " can't be refreshed")
+	}
+	conf, err := p.OAuth2Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//refreshing the token
+	newCtx, cancel := context.WithTimeout(ctx, TokenRefreshTimeout)
+	defer cancel()
+	newTok, err := conf.TokenSource(newCtx, &oauth2.Token{RefreshToken: tok.RefreshToken}).Token()
+	if rErr, ok := err.(*oauth2.RetrieveError); ok && rErr.Response != nil && rErr.Response.StatusCode < 500 {
+		appCtx.Log.Warn("refresh token of the identity", identityID, "was rejected by", pt.Provider)
+		pt.RefreshToken = ""
+		if sErr := pt.Save(*appCtx); sErr != nil {
+			appCtx.Log.Error("error while dropping the refresh token of the identity", identityID, sErr.Error())
+		}
+		return nil, ErrTokenExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = pt.SetToken(newTok)
+	if err == nil {
+		err = pt.Save(*appCtx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	appCtx.Log.Info("refreshed the token of the identity", identityID)
+	return pt.Token()
+}
+
+//expiring tells whether a token with the given expiry expires within the refresh window. Tokens without
+//an expiry don't expire
+func expiring(expiry time.Time) bool {
+	return !expiry.IsZero() && expiry.Before(time.Now().Add(TokenRefreshWindow))
+}
+
+//RPCTokens has the handler for the provider token rpc api of the application
+type RPCTokens struct{}
+
+//TokenRequest is the request for the provider token of a user
+type TokenRequest struct {
+	//AccessToken is the access token of the platform service calling the rpc
+	AccessToken string
+	//UserID is the id of the user whose token is requested
+	UserID uint
+	//Provider is the auth agent string of the provider. Eg. GOOGLE
+	Provider string
+}
+
+//TokenResponse is the provider access token of the user
+type TokenResponse struct {
+	//AccessToken is the access token of the user at the provider
+	AccessToken string
+	//TokenType is the type of the access token. Eg. Bearer
+	TokenType string
+	//Expiry is the time at which the access token expires. It is zero if the token doesn't expire
+	Expiry time.Time
+}
+
+//ProviderToken returns a fresh access token of the user at the provider. Only the platform services
+//authenticated with the master app token can call it
+func (r *RPCTokens) ProviderToken(req TokenRequest, res *TokenResponse) error {
+	/*
+	 * We will authenticate the caller
+	 * We will find the identity of the user with the provider
+	 * Then we will get the fresh token of the identity
+	 */
+	app, ok := config.GetAutenticatedApp(req.AccessToken)
+	if !ok || !app.IsMasterApp {
+		return ErrRPCUnauthorized
+	}
+	appCtx := config.NewAppContext(log.NewLogger(0))
+
+	//finding the identity
+	info := &config.UserInfo{}
+	info.ID = req.UserID
+	ids, err := info.GetIdentities(*appCtx)
+	if err != nil {
+		return err
+	}
+	var identity *config.Identity
+	for i := range ids {
+		if ids[i].Provider == req.Provider {
+			identity = &ids[i]
+			break
+		}
+	}
+	if identity == nil {
+		return ErrTokenNotFound
+	}
+
+	//getting the fresh token
+	tok, err := FreshToken(context.Background(), appCtx, identity)
+	if err != nil {
+		appCtx.Log.Error("error while getting the token of the identity", identity.ID, "for the rpc", err.Error())
+		return err
+	}
+	*res = TokenResponse{AccessToken: tok.AccessToken, TokenType: tok.TokenType, Expiry: tok.Expiry}
+	return nil
+}
@@ -4,14 +4,83 @@
 
 package oauth
 
+import (
+	"github.com/cuttle-ai/auth-service/claimmap"
+	"github.com/cuttle-ai/auth-service/config"
+)
+
 /*
  * This file contains the user info map required by the oauth
  */
 
+const (
+	//FieldEmail is the email field of the claim mapping
+	FieldEmail = "email"
+	//FieldName is the name field of the claim mapping
+	FieldName = "name"
+	//FieldPicture is the picture field of the claim mapping
+	FieldPicture = "picture"
+	//FieldEmailVerified is the field of the claim mapping telling whether the auth agent has verified the email.
+	//It is mapped from the standard claims if not given in the mapping
+	FieldEmailVerified = "email_verified"
+)
+
+//emailVerifiedRule maps the email verified field from the standard claims. verified_email is given by
+//the google user info api
+var emailVerifiedRule = claimmap.Rule{Field: FieldEmailVerified, Alternatives: [][]string{{"email_verified"}, {"verified_email"}}}
+
 //UserInfoMap contains the string of the keywords to be used to retrieve the
 //user info from the api respose of the auth agent like Google, Facebook etc.
+//Claims overrides the keywords with a declarative mapping. The fields of the mapping other than
+//email, name, picture and email_verified are set as the metadata of the user
 type UserInfoMap struct {
-	Email   string //Email key of the user info model
-	Name    string //Name key of the user info model
-	Picture string //Picture url key of the user info model
+	Email   string        //Email key of the user info model
+	Name    string        //Name key of the user info model
+	Picture string        //Picture url key of the user info model
+	Claims  *claimmap.Map //Claims is the claim mapping. If nil, it is built from the keys
+}
+
+//Map returns the claim mapping of the user info map
+func (u UserInfoMap) Map() *claimmap.Map {
+	m := &claimmap.Map{}
+	if u.Claims != nil {
+		m.Rules = append(m.Rules, u.Claims.Rules...)
+	} else {
+		for _, f := range [][2]string{{FieldEmail, u.Email}, {FieldName, u.Name}, {FieldPicture, u.Picture}} {
+			if len(f[1]) != 0 {
+				m.Rules = append(m.Rules, claimmap.Rule{Field: f[0], Alternatives: [][]string{{f[1]}}})
+			}
+		}
+	}
+	for _, r := range m.Rules {
+		if r.Field == FieldEmailVerified {
+			return m
+		}
+	}
+	m.Rules = append(m.Rules, emailVerifiedRule)
+	return m
+}
+
+//UserInfo maps the claims given by the auth agent to the user info
+func (u UserInfoMap) UserInfo(claims map[string]interface{}) (*config.UserInfo, error) {
+	fields, err := u.Map().Apply(claims)
+	if err != nil {
+		return nil, err
+	}
+	info := &config.UserInfo{
+		Email:         fields[FieldEmail],
+		Name:          fields[FieldName],
+		Picture:       fields[FieldPicture],
+		EmailVerified: fields[FieldEmailVerified] == "true",
+	}
+	for k, v := range fields {
+		if k == FieldEmail || k == FieldName || k == FieldPicture || k == FieldEmailVerified {
+			continue
+		}
+		if info.Metadata == nil {
+			info.Metadata = config.Metadata{}
+		}
+		info.Metadata[k] = v
+	}
+	return info, nil
 }
@@ -0,0 +1,184 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package password has the utilities for hashing and verifying the passwords of the local accounts.
+//Passwords are hashed with argon2id. Bcrypt hashes are verified and upgraded to argon2id on login
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+ * This file contains the password hashing utils
+ */
+
+const (
+	//TimeKey is the key storing the argon2id time cost
+	TimeKey = "PASSWORD_ARGON2_TIME"
+	//MemoryKey is the key storing the argon2id memory cost in KiB
+	MemoryKey = "PASSWORD_ARGON2_MEMORY"
+	//ThreadsKey is the key storing the argon2id parallelism
+	ThreadsKey = "PASSWORD_ARGON2_THREADS"
+	//MinLengthKey is the key storing the minimum length of a password
+	MinLengthKey = "PASSWORD_MIN_LENGTH"
+)
+
+//MaxLength is the maximum length of a password. Longer passwords are rejected to bound the hashing cost
+const MaxLength = 1024
+
+//ErrMismatch is returned when the password doesn't match the hash
+var ErrMismatch = errors.New("Password doesn't match")
+
+//Params are the argon2id parameters
+type Params struct {
+	//Time is the number of passes over the memory
+	Time uint32
+	//Memory is the memory cost in KiB
+	Memory uint32
+	//Threads is the parallelism
+	Threads uint8
+	//SaltLength is the length of the random salt in bytes
+	SaltLength uint32
+	//KeyLength is the length of the hash in bytes
+	KeyLength uint32
+}
+
+//DefaultParams are the parameters with which the new hashes are created
+var DefaultParams = Params{Time: 3, Memory: 64 * 1024, Threads: 2, SaltLength: 16, KeyLength: 32}
+
+//MinLength is the minimum length of a password
+var MinLength = 8
+
+func init() {
+	/*
+	 * We will override the default params from the environment
+	 * Then the minimum length of the password
+	 */
+	if t, err := strconv.ParseUint(os.Getenv(TimeKey), 10, 32); err == nil && t > 0 {
+		DefaultParams.Time = uint32(t)
+	}
+	if m, err := strconv.ParseUint(os.Getenv(MemoryKey), 10, 32); err == nil && m > 0 {
+		DefaultParams.Memory = uint32(m)
+	}
+	if p, err := strconv.ParseUint(os.Getenv(ThreadsKey), 10, 8); err == nil && p > 0 {
+		DefaultParams.Threads = uint8(p)
+	}
+	if l, err := strconv.Atoi(os.Getenv(MinLengthKey)); err == nil && l > 0 {
+		MinLength = l
+	}
+}
+
+//Validate checks whether the password satisfies the password policy
+func Validate(password string) error {
+	l := utf8.RuneCountInString(password)
+	if l < MinLength {
+		return fmt.Errorf("Password must have at least %d characters", MinLength)
+	}
+	if len(password) > MaxLength {
+		return fmt.Errorf("Password can't be longer than %d bytes", MaxLength)
+	}
+	return nil
+}
+
+//Hash returns the argon2id hash of the password in the PHC string format with the default params
+func Hash(password string) (string, error) {
+	return HashWithParams(password, DefaultParams)
+}
+
+//HashWithParams returns the argon2id hash of the password in the PHC string format with the given params
+func HashWithParams(password string, p Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+//Verify verifies the password against the hash. It returns ErrMismatch if the password doesn't match.
+//rehash will be true if the hash has to be upgraded to the current default params
+func Verify(password string, hash string) (rehash bool, err error) {
+	/*
+	 * If the hash is a bcrypt hash, we will verify it with bcrypt and always ask for a rehash
+	 * Else we will decode the argon2id hash
+	 * Then compute the hash of the password with the same params and compare
+	 * Then we will check whether the params are outdated
+	 */
+	if len(password) > MaxLength {
+		return false, ErrMismatch
+	}
+
+	//verifying the bcrypt hash
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, ErrMismatch
+		}
+		return err == nil, err
+	}
+
+	//decoding the argon2id hash
+	p, salt, key, err := decode(hash)
+	if err != nil {
+		return false, err
+	}
+
+	//comparing the hash
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, ErrMismatch
+	}
+
+	//checking whether the params are outdated
+	rehash = p.Time < DefaultParams.Time || p.Memory < DefaultParams.Memory ||
+		p.Threads != DefaultParams.Threads || p.KeyLength < DefaultParams.KeyLength
+	return rehash, nil
+}
+
+//decode decodes the argon2id hash in the PHC string format
+func decode(hash string) (p Params, salt []byte, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		err = errors.New("Unsupported password hash")
+		return
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return
+	}
+	if version != argon2.Version {
+		err = errors.New("Unsupported argon2 version " + strconv.Itoa(version))
+		return
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads)
+	if err != nil {
+		return
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package password
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+ * This file contains the tests of the password hashing utils
+ */
+
+func TestHashAndVerify(t *testing.T) {
+	h, err := Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rehash, err := Verify("correct horse battery staple", h)
+	if err != nil || rehash {
+		t.Errorf("expected a match without rehash, got %v %v", rehash, err)
+	}
+	if _, err = Verify("wrong horse battery staple", h); err != ErrMismatch {
+		t.Errorf("expected a mismatch, got %v", err)
+	}
+}
+
+func TestVerifyOutdatedParams(t *testing.T) {
+	h, err := HashWithParams("correct horse battery staple", Params{Time: 1, Memory: 1024, Threads: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rehash, err := Verify("correct horse battery staple", h)
+	if err != nil || !rehash {
+		t.Errorf("expected a match with rehash, got %v %v", rehash, err)
+	}
+}
+
+func TestVerifyBcrypt(t *testing.T) {
+	h, err := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rehash, err := Verify("correct horse battery staple", string(h))
+	if err != nil || !rehash {
+		t.Errorf("expected a match with rehash, got %v %v", rehash, err)
+	}
+	if _, err = Verify("wrong horse battery staple", string(h)); err != ErrMismatch {
+		t.Errorf("expected a mismatch, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if Validate("short") == nil {
+		t.Error("expected short password to be rejected")
+	}
+	if err := Validate("long enough password"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package recovery has the generation and hashing of the one time recovery codes used when the second factor is lost
+package recovery
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+/*
+ * This file contains the recovery code utils
+ */
+
+const (
+	//Count is the number of codes generated in a batch
+	Count = 10
+	//groups is the number of groups in a code
+	groups = 4
+	//groupLength is the number of characters in a group of a code
+	groupLength = 4
+)
+
+//alphabet of the codes. It has 32 characters without the ambiguous ones like 0/O and 1/I
+const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+//Generate returns a batch of new recovery codes. Each code has 80 bits of entropy so a fast hash is enough to store it
+func Generate() ([]string, error) {
+	codes := make([]string, 0, Count)
+	b := make([]byte, groups*groupLength)
+	for i := 0; i < Count; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		parts := make([]string, groups)
+		for g := range parts {
+			p := make([]byte, groupLength)
+			for j := range p {
+				p[j] = alphabet[b[g*groupLength+j]&0x1f]
+			}
+			parts[g] = string(p)
+		}
+		codes = append(codes, strings.Join(parts, "-"))
+	}
+	return codes, nil
+}
+
+//Hash returns the hash of the code to be stored. The code is normalized so that the case, spaces and dashes don't matter
+func Hash(code string) string {
+	h := sha256.Sum256([]byte(Normalize(code)))
+	return hex.EncodeToString(h[:])
+}
+
+//Normalize returns the code in upper case without the spaces and dashes
+func Normalize(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
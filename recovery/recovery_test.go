@@ -0,0 +1,43 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package recovery
+
+import (
+	"strings"
+	"testing"
+)
+
+/*
+ * This file contains the tests of the recovery code utils
+ */
+
+func TestGenerate(t *testing.T) {
+	codes, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != Count {
+		t.Fatalf("expected %d codes, got %d", Count, len(codes))
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != groups*groupLength+groups-1 || strings.Trim(Normalize(c), alphabet) != "" {
+			t.Errorf("unexpected code %s", c)
+		}
+		if seen[c] {
+			t.Errorf("duplicate code %s", c)
+		}
+		seen[c] = true
+	}
+}
+
+func TestHashNormalizes(t *testing.T) {
+	if Hash("abcd-efgh-jkmn-pqrs") != Hash(" ABCDEFGH JKMNPQRS ") {
+		t.Error("expected the hash to ignore the case, spaces and dashes")
+	}
+	if Hash("ABCD-EFGH-JKMN-PQRS") == Hash("ABCD-EFGH-JKMN-PQRT") {
+		t.Error("expected different codes to have different hashes")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/cuttle-ai/auth-service/authserver"
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+)
+
+/*
+ * This file contains the handlers for deleting the accounts and signing out the users
+ */
+
+//DeleteAccount deletes the account of the logged in user. The grants given by the user at the auth agents are
+//revoked and the user is logged out of all the sessions
+func DeleteAccount(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the account of the user
+	 * We will revoke the grants of the user
+	 * We will delete the account and record the event
+	 * Then we will end the sessions of the user
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//getting the account
+	info := config.GetUserInfoByID(*appCtx, appCtx.Session.User.ID)
+	if info == nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeSessionExpired]}, http.StatusForbidden)
+		return
+	}
+	if info.UserType == config.SuperAdmin {
+		response.WriteError(appCtx, w, response.Error{Err: "Super admin account can't be deleted"}, http.StatusForbidden)
+		return
+	}
+
+	//revoking the grants
+	oauth.RevokeGrants(ctx, appCtx, info, oauth.RevokeOnAccountDeletion, r.RemoteAddr)
+
+	//deleting the account
+	err := info.Delete(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while deleting the account of", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't delete the account"}, http.StatusInternalServerError)
+		return
+	}
+	info.Audit(*appCtx, config.AuditAccountDeleted, info.Email, r.RemoteAddr)
+	appCtx.Log.Info("deleted the account of", info.ID)
+
+	//ending the sessions
+	endUserSessions(appCtx, info.ID)
+	clearSessionCookie(w)
+	response.Write(appCtx, w, "Successfully deleted the account")
+}
+
+//SignOutUser signs out the user posted from all the sessions. The grants given by the user at the auth agents
+//are revoked. Only the admins can sign out the users
+func SignOutUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will make sure the logged in user is an admin
+	 * We will get the account of the user. Users with a higher role than the admin can't be signed out
+	 * We will revoke the grants of the user
+	 * Then we will end the sessions of the user and record the event
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	admin := appCtx.Session.User
+	if !isAdmin(appCtx) {
+		appCtx.Log.Warn("user", admin.ID, "without admin previlege tried to sign out a user")
+		response.WriteError(appCtx, w, response.Error{Err: "You don't have the previlege to access this API."}, http.StatusForbidden)
+		return
+	}
+
+	//getting the account
+	id, err := strconv.ParseUint(r.PostFormValue("user_id"), 10, 64)
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " user_id"}, http.StatusBadRequest)
+		return
+	}
+	info := config.GetUserInfoByID(*appCtx, uint(id))
+	if info == nil {
+		response.WriteError(appCtx, w, response.Error{Err: "User not found"}, http.StatusNotFound)
+		return
+	}
+	if config.UserTypeRank(info.UserType) > config.UserTypeRank(admin.UserType) {
+		appCtx.Log.Warn("user", admin.ID, "tried to sign out the user", info.ID, "having a higher role")
+		response.WriteError(appCtx, w, response.Error{Err: "You can't sign out a user having a higher role than yours"}, http.StatusForbidden)
+		return
+	}
+
+	//revoking the grants
+	oauth.RevokeGrants(ctx, appCtx, info, oauth.RevokeOnForcedSignOut, r.RemoteAddr)
+
+	//ending the sessions
+	n := endUserSessions(appCtx, info.ID)
+	info.Audit(*appCtx, config.AuditForcedSignOut, "by "+strconv.FormatUint(uint64(admin.ID), 10), r.RemoteAddr)
+	appCtx.Log.Info("signed out the user", info.ID, "from", n, "sessions by", admin.ID)
+	response.Write(appCtx, w, response.Message{Message: "signed out the user", Data: n})
+}
+
+//endUserSessions deletes the sessions of the user and informs the logged out info to all the applications.
+//The tokens issued to the apps on behalf of the user are revoked too. It returns the number of sessions ended
+func endUserSessions(appCtx *config.AppContext, userID uint) int {
+	req := routes.AppContextRequest{
+		Type:    routes.DeleteUserSessions,
+		Out:     make(chan routes.AppContextRequest),
+		Session: config.Session{User: &config.User{ID: userID}},
+	}
+	go routes.SendRequest(routes.AppContextRequestChan, req)
+	res := <-req.Out
+	authserver.RevokeUserTokens(appCtx, userID)
+	for _, s := range res.Sessions {
+		if !s.Authenticated {
+			continue
+		}
+		u := *s.User
+		go u.InformAuth(*appCtx, false)
+	}
+	return len(res.Sessions)
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/account/delete",
+			HandlerFunc:   DeleteAccount,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/users/signout",
+			HandlerFunc:   SignOutUser,
+			ParseForm:     true,
+			Authenticated: true,
+		},
+	)
+}
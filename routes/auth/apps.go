@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/cuttle-ai/auth-service/authserver"
 	"github.com/cuttle-ai/auth-service/config"
 	"github.com/cuttle-ai/auth-service/routes"
 	"github.com/cuttle-ai/auth-service/routes/response"
@@ -42,8 +43,7 @@ func CreateApp(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	/*
 	 * First we will get the app context
 	 * Then we will parse the request
-	 * Then we will create the app
-	 * Then will inform the authentication across the platform
+	 * Then we will create the app with a client secret. The app gets the short lived tokens with it
 	 * Return the response
 	 */
 	//getting the app context
@@ -60,12 +60,23 @@ func CreateApp(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
+	if !validRedirectURIs(a.RedirectURIs) {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " RedirectURIs"}, http.StatusBadRequest)
+		return
+	}
 
 	//creating the app
 	a.UID = uuid.New()
-	a.AccessToken = uuid.New().String()
+	a.AccessToken = ""
 	a.UserID = appCtx.Session.User.ID
 	aI := a.ToAppInfo()
+	secret, hash, err := authserver.NewClientSecret()
+	if err != nil {
+		appCtx.Log.Error("error while generating the client secret of the app for", aI.UserID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't create the app"}, http.StatusInternalServerError)
+		return
+	}
+	aI.ClientSecretHash = hash
 	err = (&aI).Insert(*appCtx)
 	if err != nil {
 		//error while inserting the app to the platform
@@ -75,16 +86,15 @@ func CreateApp(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//informing the authentication across the platform
-	appCtx.Log.Info("created the app for user - ", a.UserID, "with id", a.ID, "going to update the same across the platform")
-	user := aI.ToApp().ToUser()
-	go user.InformAuth(*appCtx, true)
+	appCtx.Log.Info("created the app for user - ", aI.UserID, "with id", aI.ID)
 
-	//we will write the response
-	response.Write(appCtx, w, response.Message{Message: "created the app", Data: aI.ToApp()})
+	//we will write the response. The client secret is given only now
+	created := aI.ToApp()
+	created.ClientSecret = secret
+	response.Write(appCtx, w, response.Message{Message: "created the app", Data: created})
 }
 
-//UpdateApp api will update an app registered with the platform. Only name, email and description are updated
+//UpdateApp api will update an app registered with the platform. Only name, email, description and redirect uris are updated
 func UpdateApp(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	/*
 	 * First we will get the app context
@@ -106,6 +116,10 @@ func UpdateApp(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
+	if !validRedirectURIs(a.RedirectURIs) {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " RedirectURIs"}, http.StatusBadRequest)
+		return
+	}
 
 	//updating the app
 	if appCtx.Session.User.UserType != config.AdminUser && appCtx.Session.User.UserType != config.SuperAdmin {
@@ -164,9 +178,77 @@ func DeleteApp(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	appCtx.Log.Info("delete the app for user - ", a.UserID, "with id", a.ID, "going to update the same across the platform")
 	user := aI.ToApp().ToUser()
 	go user.InformAuth(*appCtx, false)
+	authserver.RevokeAppTokens(appCtx, aI.ID)
 	response.Write(appCtx, w, response.Message{Message: "deleted the app", Data: nil})
 }
 
+//RotateAppSecret api will generate a new client secret for an app registered with the platform. The old secret
+//stops working immediately
+func RotateAppSecret(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the request
+	 * Then we will get the app and make sure the user can manage it
+	 * Then we will generate and store the new secret
+	 * Return the response
+	 */
+	//getting the app context
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	appCtx.Log.Info("a request has come to rotate the app secret from ", appCtx.Session.User.ID)
+
+	//parse the request param
+	a := &config.App{}
+	err := json.NewDecoder(r.Body).Decode(a)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the app param", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	//getting the app
+	aI := config.GetAppByUID(*appCtx, a.UID.String())
+	if aI == nil || (aI.UserID != appCtx.Session.User.ID && !isAdmin(appCtx)) {
+		response.WriteError(appCtx, w, response.Error{Err: "App not found"}, http.StatusNotFound)
+		return
+	}
+	if aI.IsMasterApp {
+		appCtx.Log.Warn("user", appCtx.Session.User.ID, "tried to rotate the secret of the master app")
+		response.WriteError(appCtx, w, response.Error{Err: "Secret of the master app can't be rotated"}, http.StatusForbidden)
+		return
+	}
+
+	//generating the new secret
+	secret, hash, err := authserver.NewClientSecret()
+	if err == nil {
+		aI.ClientSecretHash = hash
+		err = aI.UpdateClientSecret(*appCtx)
+	}
+	if err != nil {
+		appCtx.Log.Error("error while rotating the client secret of the app", aI.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't rotate the app secret"}, http.StatusInternalServerError)
+		return
+	}
+
+	//we will write the response
+	appCtx.Log.Info("rotated the client secret of the app", aI.ID)
+	rotated := aI.ToApp()
+	rotated.ClientSecret = secret
+	response.Write(appCtx, w, response.Message{Message: "rotated the app secret", Data: rotated})
+}
+
+//validRedirectURIs tells whether the redirect uris are absolute uris without fragments as required by the oauth2 spec
+//and have the schemes allowed by the authorization server
+func validRedirectURIs(uris []string) bool {
+	for _, u := range uris {
+		if !authserver.SafeRedirectURI(u) {
+			return false
+		}
+	}
+	return true
+}
+
 //GetAllApps api will return the list of all apps registered in the platform. This is intented for admin use
 func GetAllApps(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	/*
@@ -205,6 +287,12 @@ func init() {
 			HandlerFunc:   DeleteApp,
 			Authenticated: true,
 		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/apps/secret",
+			HandlerFunc:   RotateAppSecret,
+			Authenticated: true,
+		},
 		routes.Route{
 			Version:       "v1",
 			Pattern:       "/auth/admin/apps",
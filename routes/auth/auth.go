@@ -11,112 +11,160 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cuttle-ai/auth-service/authserver"
 	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/domainpolicy"
 	"github.com/cuttle-ai/auth-service/oauth"
-	"github.com/cuttle-ai/auth-service/oauth/google"
 	"github.com/cuttle-ai/auth-service/routes"
 	"github.com/cuttle-ai/auth-service/routes/response"
-	"golang.org/x/oauth2"
 )
 
-func getUserInfo(appCtx *config.AppContext) (*config.UserInfo, error) {
-
-	//getting the user model
-	if appCtx.Session.User == nil || len(appCtx.Session.User.AccessToken) == 0 {
-		return nil, nil
+//Urls will return the 3party auth URLs of the registered providers mapped to their label.
+//The return_to query param is the allowed url to which the browser is redirected after the login
+func Urls(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	returnTo := r.URL.Query().Get("return_to")
+	if len(returnTo) != 0 && !config.ReturnToAllowed(returnTo) {
+		appCtx.Log.Warn("return to url is not allowed", returnTo)
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " return_to"}, http.StatusBadRequest)
+		return
 	}
-	agent := getAgent(appCtx.Session.User)
-
-	//getting the updated user info from the auth agent
-	info, err := oauth.Info(oauth2.NoContext, appCtx, agent)
-	if err != nil {
-		//error while getting the user info from the auth agent
-		return nil, err
+	urls := map[string]string{}
+	for _, p := range oauth.Providers() {
+		if p.LoginURL == nil {
+			continue
+		}
+		u, err := p.LoginURL(ctx, appCtx, returnTo)
+		if err != nil {
+			//error while building the login url of the provider. We will skip the provider
+			appCtx.Log.Error("Error while getting the login url of the provider", p.Name, err.Error())
+			continue
+		}
+		urls[p.Label] = u
 	}
 
-	return info, nil
-}
-
-//Urls will return the 3party auth URLs
-func Urls(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
-	response.Write(appCtx, w, map[string]string{
-		"Google": google.Config.AuthCodeURL("state", oauth2.AccessTypeOffline),
+	//saving the session with the oauth states of the logins
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: appCtx.Session,
+		Type:    routes.SetSession,
 	})
+	response.Write(appCtx, w, urls)
 }
 
-//GoogleAuth is the callback url for the Google OAuth
-func GoogleAuth(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+//startSession will save the user info and start an authenticated session for the user. If returnTo is given
+//the browser is redirected to it after the login
+func startSession(appCtx *config.AppContext, w http.ResponseWriter, info *config.UserInfo, returnTo string) {
 	/*
-	 * We will get the google auth code from the request
-	 * Will get the token from the google auth exchange
-	 * We will initiate the user session and save the session
-	 * We will get user info from the auth agent
-	 * We will also info the user logged info info to all the applications
-	 * Then will redirect to the index page
+	 * If the user is linking an identity, we will link it instead of logging in
+	 * We will find the account of the user info or create one
+	 * We will store the token given by the auth agent for the identity
+	 * If the email of the account is not verified, the user has to verify it before the session is authenticated
+	 * Then we will authenticate the session
 	 */
-	//we will get the code from the request
-	code := r.URL.Query().Get("code")
-	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
-
-	//we will get the token from the google exchange
-	tok, err := google.Config.Exchange(oauth2.NoContext, code)
-	if err != nil {
-		//error while getting the token from the google auth exchange
-		appCtx.Log.Error("Error while getting the token for the code", code)
-		appCtx.Log.Error(err.Error())
-		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your oauth"}, http.StatusForbidden)
+	//linking the identity
+	if l := appCtx.Session.Link; l != nil && l.Expires.After(time.Now()) {
+		linkIdentity(appCtx, w, info, returnTo)
 		return
 	}
+	appCtx.Session.Link = nil
 
-	//we will set the user
-	user := &config.User{}
-	user.AccessToken = tok.AccessToken
-	user.AuthAgent = oauth.GOOGLE
-	appCtx.Session.User = user
-
-	//getting the user info from the auth agent
-	agent := getAgent(appCtx.Session.User)
-	info, err := getUserInfo(appCtx)
+	//finding the account
+	i, err := findAccount(appCtx, info)
+	if pErr, ok := err.(*domainpolicy.Error); ok {
+		appCtx.Log.Warn("sign up of", info.Email, "from", appCtx.Session.User.AuthAgent, "is denied by the domain policy")
+		writeLoginDenied(appCtx, w, pErr.Reason)
+		return
+	}
+	if err == errNotInvited {
+		appCtx.Log.Warn("sign up of", info.Email, "from", appCtx.Session.User.AuthAgent, "has no invitation")
+		writeLoginDenied(appCtx, w, err.Error())
+		return
+	}
+	if err == errSignUpUnverified {
+		appCtx.Log.Info("sign up of", info.Email, "from", appCtx.Session.User.AuthAgent, "waits for the email verification")
+		startEmailVerification(appCtx, w, info, returnTo)
+		return
+	}
+	if err == errAccountExists {
+		appCtx.Log.Warn("account exists for", info.Email, "without the identity of", appCtx.Session.User.AuthAgent)
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusConflict)
+		return
+	}
+	if err == errAccountUnverified {
+		appCtx.Log.Warn("unverified account of", info.Email, "matched by the login from", appCtx.Session.User.AuthAgent)
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusConflict)
+		return
+	}
 	if err != nil {
-		//error while getting the user info from the auth agent
-		appCtx.Log.Error("Error while fetching the user info from oauth agent", agent.Name())
-		appCtx.Log.Error(err.Error())
-		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your oauth"}, http.StatusForbidden)
+		appCtx.Log.Error("error while finding the account of", info.Email, "from", appCtx.Session.User.AuthAgent, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your login"}, http.StatusInternalServerError)
 		return
 	}
 
-	//if the info is nil, we know that the session is empty
-	if info == nil {
-		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your oauth"}, http.StatusForbidden)
-		return
+	//storing the token of the identity
+	if tok := appCtx.Session.User.ProviderToken; tok != nil && len(info.Subject) != 0 {
+		storeProviderToken(appCtx, config.GetIdentity(*appCtx, appCtx.Session.User.AuthAgent, info.Subject), tok)
 	}
 
-	//since we have a valid info, we will get the info from db
-	//if the info is empty, we have to update the db with new info
-	//if info is not empty, except the registered info we will update the existing info in db
-	i := (*info).Get(*appCtx)
-	if i == nil {
-		info.Insert(*appCtx)
-		if info.ID == 1 {
-			err = config.AddAsSuperAdmin(appCtx, info.ID, info.Email)
-			if err != nil {
-				//error while adding the user as super admin
-				appCtx.Log.Error("error shile adding the user as super admin ", i.ID, err.Error())
-			}
-		}
-		i = info
-	} else {
-		info.Registered = i.Registered
-		info.Update(*appCtx)
+	//verifying the email
+	if !emailVerified(appCtx, i, info) {
+		startEmailVerification(appCtx, w, i, returnTo)
+		return
 	}
 
+	authenticate(appCtx, w, i, returnTo)
+}
+
+//authenticate will initiate the authenticated session of the account and save it. If the user has to verify with a
+//second factor, the session will be pending till then. Else the session is activated
+func authenticate(appCtx *config.AppContext, w http.ResponseWriter, i *config.UserInfo, returnTo string) {
 	//will save the session
 	appCtx.Session.Authenticated = true
 	appCtx.Session.User.Email = i.Email
-	appCtx.Session.User.AccessToken = appCtx.Session.ID
+	appCtx.Session.User.IDToken = ""
+	appCtx.Session.User.Nonce = ""
+	appCtx.Session.User.ProviderToken = nil
 	appCtx.Session.User.ID = i.ID
 	appCtx.Session.User.UserType = i.UserType
+	appCtx.Session.SecondFactor = ""
+	appCtx.Session.EmailVerification = nil
+
+	//the session gets a signed token as its id so that the services can validate it offline. The id known before
+	//the login is dropped
+	token, err := authserver.SessionToken(*appCtx.Session.User)
+	if err != nil {
+		appCtx.Log.Error("error while signing the session token of the user", i.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your login"}, http.StatusInternalServerError)
+		return
+	}
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: config.Session{ID: appCtx.Session.ID},
+		Type:    routes.DeleteSession,
+	})
+	appCtx.Session.ID = token
+	appCtx.Session.User.AccessToken = token
+
+	//if the user has to verify with a second factor, the session will be pending till then
+	if mfaRequired(appCtx, i) {
+		appCtx.Log.Info("second factor is required for the user", i.ID)
+		appCtx.Session.Pending = config.SessionMFARequired
+		go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+			Session: appCtx.Session,
+			Type:    routes.SetSession,
+		})
+		setSessionCookie(w, appCtx.Session)
+		writeLogin(appCtx, w, returnTo, appCtx.Session)
+		return
+	}
+
+	activateSession(appCtx, w, returnTo)
+}
+
+//activateSession will clear the pending state of the authenticated session and inform the user logged in
+//info to all the applications. If returnTo is given the browser is redirected to it
+func activateSession(appCtx *config.AppContext, w http.ResponseWriter, returnTo string) {
+	//will save the session
+	appCtx.Session.Pending = ""
 	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
 		Session: appCtx.Session,
 		Type:    routes.SetSession,
@@ -124,16 +172,73 @@ func GoogleAuth(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 
 	//informing the user logged in info to all the applications
 	go appCtx.Session.User.InformAuth(*appCtx, true)
+	setSessionCookie(w, appCtx.Session)
+
+	//will rediect to the index page
+	writeLogin(appCtx, w, returnTo, appCtx.Session)
+}
+
+//writeLogin writes the payload of the completed login. If the login has a url to return to, the browser
+//is redirected there instead. The redirect page is written for the clients which don't follow the redirect
+func writeLogin(appCtx *config.AppContext, w http.ResponseWriter, returnTo string, payload interface{}) {
+	if len(returnTo) == 0 {
+		response.Write(appCtx, w, payload)
+		return
+	}
+	w.Header().Set("Location", returnTo)
+	response.WriteErrorTemplate(appCtx, w, indexRedirectPage(appCtx), returnTo, http.StatusFound)
+}
+
+//writeLoginDenied renders the page telling the user why the login was denied
+func writeLoginDenied(appCtx *config.AppContext, w http.ResponseWriter, reason string) {
+	response.WriteErrorTemplate(appCtx, w, loginDeniedPage(appCtx), struct {
+		Reason string
+		URL    string
+	}{reason, config.FrontendOrigin()}, http.StatusForbidden)
+}
+
+//setSessionCookie sets the cookie of the session
+func setSessionCookie(w http.ResponseWriter, session config.Session) {
 	http.SetCookie(w, &http.Cookie{
 		Name:    config.AuthHeaderKey,
-		Value:   appCtx.Session.ID,
-		Expires: time.Now().AddDate(0, 0, 1),
+		Value:   session.ID,
+		Expires: time.Now().Add(authserver.SessionTTL),
 		Domain:  strings.Split(config.FrontendURL, ":")[0],
 		Path:    "/",
 	})
+}
 
-	//will rediect to the index page
-	response.Write(appCtx, w, appCtx.Session)
+//clearSessionCookie expires the cookie of the session
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    config.AuthHeaderKey,
+		Expires: time.Now(),
+		Domain:  strings.Split(config.FrontendURL, ":")[0],
+		Path:    "/",
+	})
+}
+
+//mfaRequired tells whether the user has to verify with a second factor after logging in. It is required if the user
+//has enrolled a second factor or if the policy requires it for the user type. Passkey logins are already multi factor
+func mfaRequired(appCtx *config.AppContext, info *config.UserInfo) bool {
+	if appCtx.Session.User.AuthAgent == oauth.WEBAUTHN {
+		return false
+	}
+	return config.MFARequired(info.UserType) || secondFactorEnrolled(appCtx, info)
+}
+
+//secondFactorEnrolled tells whether the user has enrolled a totp secret or a passkey
+func secondFactorEnrolled(appCtx *config.AppContext, info *config.UserInfo) bool {
+	if info.TOTPEnabled {
+		return true
+	}
+	creds, err := info.GetWebAuthnCredentials(*appCtx)
+	if err != nil {
+		//we fail closed since the user may have enrolled a passkey
+		appCtx.Log.Error("error while getting the passkeys of", info.ID, err.Error())
+		return true
+	}
+	return len(creds) != 0
 }
 
 //Register registers the user with the platform.
@@ -145,13 +250,14 @@ func Register(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	 * If the session doesn't exist we will give session expired message
 	 * Then check whether the user has agreed to the terms and conditions of the application
 	 * Will parse the subscribe parameter
+	 * We will accept the invitation of the user
 	 * If yes we will update the profile information
 	 */
 	//getting the user session
 	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
 
 	//session expired if no session
-	if !appCtx.Session.Authenticated || appCtx.Session.User == nil {
+	if !appCtx.Session.IsAuthenticated() || appCtx.Session.User == nil {
 		appCtx.Log.Error("User session expired for while registering by accepting the terms and conditions")
 		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeSessionExpired]}, http.StatusForbidden)
 		return
@@ -176,12 +282,45 @@ func Register(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		subscribed = true
 	}
 
+	//accepting the invitation. The user type assigned by the invitation is updated in the session
+	info := config.GetUserInfoByID(*appCtx, appCtx.Session.User.ID)
+	if info == nil {
+		appCtx.Log.Error("account of the session", appCtx.Session.User.ID, "not found while registering")
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeSessionExpired]}, http.StatusForbidden)
+		return
+	}
+	pro := *info
+	if !pro.Registered {
+		err := acceptInvitation(appCtx, &pro)
+		if err == errNotInvited {
+			appCtx.Log.Warn("registration without an invitation by", pro.ID)
+			response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while accepting the invitation of", pro.ID, err.Error())
+			response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your registration"}, http.StatusInternalServerError)
+			return
+		}
+		if pro.UserType != appCtx.Session.User.UserType {
+			appCtx.Session.User.UserType = pro.UserType
+			go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+				Session: appCtx.Session,
+				Type:    routes.SetSession,
+			})
+			go appCtx.Session.User.InformAuth(*appCtx, true)
+		}
+	}
+
 	//updating the profile info
-	pro := config.UserInfo{Email: appCtx.Session.User.Email}
-	pro = *(pro.Get(*appCtx))
 	pro.Registered = true
 	pro.Subscribed = subscribed
-	pro.Update(*appCtx)
+	err := pro.Update(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while registering the user", pro.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your registration"}, http.StatusInternalServerError)
+		return
+	}
 
 	//sending success message
 	response.Write(appCtx, w, "Successfully registered the user")
@@ -198,8 +337,9 @@ func Profile(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
 	u := &config.UserInfo{}
 	if appCtx.Session.User != nil {
-		u.Email = appCtx.Session.User.Email
-		u = u.Get(*appCtx)
+		if info := config.GetUserInfoByID(*appCtx, appCtx.Session.User.ID); info != nil {
+			u = info
+		}
 	}
 	response.Write(appCtx, w, u)
 }
@@ -207,17 +347,28 @@ func Profile(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 //Logout logs a user out of the platform
 func Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	/*
+	 * If enabled we will revoke the grants given by the user at the auth agents
 	 * We will inform all the services that the user has logged out
 	 * We will empty the session
 	 * Then saves the session
 	 */
 	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
 
+	//revoking the grants of the user
+	if config.RevokeOnLogout && appCtx.Session.IsAuthenticated() && appCtx.Session.User != nil {
+		if info := config.GetUserInfoByID(*appCtx, appCtx.Session.User.ID); info != nil {
+			oauth.RevokeGrants(ctx, appCtx, info, oauth.RevokeOnLogout, r.RemoteAddr)
+		}
+	}
+
 	//inform all the services that the user has logged out
 	go appCtx.Session.User.InformAuth(*appCtx, false)
 
 	//We will delete the user model from the session
 	appCtx.Session.Authenticated = false
+	appCtx.Session.Pending = ""
+	appCtx.Session.SecondFactor = ""
+	appCtx.Session.Link = nil
 	appCtx.Session.User = nil
 
 	//will save the session
@@ -225,12 +376,7 @@ func Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		Session: appCtx.Session,
 		Type:    routes.SetSession,
 	})
-	http.SetCookie(w, &http.Cookie{
-		Name:    config.AuthHeaderKey,
-		Expires: time.Now(),
-		Domain:  strings.Split(config.FrontendURL, ":")[0],
-		Path:    "/",
-	})
+	clearSessionCookie(w)
 
 	//send the ok response
 	response.Write(appCtx, w, "you have sucessfully logged out of the system")
@@ -243,12 +389,6 @@ func init() {
 			Pattern:     "/auth/urls",
 			HandlerFunc: Urls,
 		},
-		routes.Route{
-			Version:     "v1",
-			Pattern:     "/auth/google",
-			HandlerFunc: GoogleAuth,
-			ParseForm:   true,
-		},
 		routes.Route{
 			Version:     "v1",
 			Pattern:     "/auth/register",
@@ -271,14 +411,3 @@ func init() {
 		},
 	)
 }
-
-func getAgent(u *config.User) oauth.Agent {
-	if u == nil {
-		return nil
-	}
-	switch u.AuthAgent {
-	case oauth.GOOGLE:
-		return &google.Agent{}
-	}
-	return nil
-}
@@ -0,0 +1,462 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/authserver"
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+)
+
+/*
+ * This file contains the handlers of the authorization server with which the registered apps act on behalf of the users
+ */
+
+//consentTimeout is the time within which the user has to give the consent
+const consentTimeout = 10 * time.Minute
+
+//consentTemplateString is the page asking the consent of the user for the app
+var consentTemplateString = headerText + `
+<h1>{{.App}} wants to access your account</h1>
+<p>It will be able to act on your behalf with the following permissions</p>
+<ul>{{range .Scopes}}
+<li>{{.}}</li>{{end}}
+</ul>
+<form method="POST">
+<input type="hidden" name="key" value="{{.Key}}">
+<button type="submit" name="consent" value="approve">Allow</button>
+<button type="submit" name="consent" value="deny">Deny</button>
+</form>` + footerText
+
+func consentPage(appCtx *config.AppContext) response.Template {
+	tem, err := template.New("consent-page").Parse(consentTemplateString)
+	if err != nil {
+		appCtx.Log.Error("Error while initializing the consent page template in routes/auth/authorize", err.Error())
+	}
+	return response.Template{T: tem, Name: "consent-page"}
+}
+
+//authorizeErrorTemplateString is the page shown when the authorization request can't be redirected back to the app
+var authorizeErrorTemplateString = headerText + `
+<h1>Sorry, the app can't be authorized</h1>
+<p>{{.}}</p>` + footerText
+
+func authorizeErrorPage(appCtx *config.AppContext) response.Template {
+	tem, err := template.New("authorize-error-page").Parse(authorizeErrorTemplateString)
+	if err != nil {
+		appCtx.Log.Error("Error while initializing the authorize error page template in routes/auth/authorize", err.Error())
+	}
+	return response.Template{T: tem, Name: "authorize-error-page"}
+}
+
+//Authorize is the authorization endpoint. The users without a session are sent to the login and come back here.
+//Logged in users are asked for the consent unless they have already consented to the scope. The authorization
+//code is then given to the app at its redirect uri
+func Authorize(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * If the consent is submitted we will complete the authorization
+	 * We will validate the app and the redirect uri. Errors before it are shown to the user
+	 * We will validate the response type, scope and the pkce code challenge
+	 * If the user is not logged in, we will send the user to the login
+	 * If the user has consented to the scope, we will issue the code
+	 * Else we will ask for the consent
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method == http.MethodPost {
+		authorizeConsent(appCtx, w, r)
+		return
+	}
+	q := r.URL.Query()
+
+	//validating the app and the redirect uri
+	app := authserver.Client(appCtx, q.Get("client_id"))
+	if app == nil {
+		response.WriteErrorTemplate(appCtx, w, authorizeErrorPage(appCtx), "The app is not registered", http.StatusBadRequest)
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	redirectURISupplied := len(redirectURI) != 0
+	if uris := strings.Fields(app.RedirectURIs); len(redirectURI) == 0 && len(uris) == 1 {
+		redirectURI = uris[0]
+	}
+	if !authserver.ValidRedirectURI(app, redirectURI) {
+		appCtx.Log.Warn("redirect uri", redirectURI, "is not registered for the app", app.ID)
+		response.WriteErrorTemplate(appCtx, w, authorizeErrorPage(appCtx), "The redirect uri is not registered for the app", http.StatusBadRequest)
+		return
+	}
+	state := q.Get("state")
+
+	//validating the response type, scope and the code challenge
+	if q.Get("response_type") != "code" {
+		redirectAuthorization(appCtx, w, redirectURI, state, url.Values{"error": {authserver.ErrorUnsupportedResponseType}})
+		return
+	}
+	scope, err := authserver.ParseScope(q.Get("scope"))
+	if err != nil {
+		redirectAuthorization(appCtx, w, redirectURI, state, oauthErrorValues(err))
+		return
+	}
+	challenge := q.Get("code_challenge")
+	if len(challenge) != 0 && q.Get("code_challenge_method") != "S256" {
+		redirectAuthorization(appCtx, w, redirectURI, state, url.Values{
+			"error":             {authserver.ErrorInvalidRequest},
+			"error_description": {"Only the S256 code challenge method is supported"},
+		})
+		return
+	}
+
+	//sending the user to the login
+	if !appCtx.Session.IsAuthenticated() {
+		writeLogin(appCtx, w, authorizeLoginURL(r), nil)
+		return
+	}
+
+	//issuing the code if the user has consented
+	if g := config.GetAppGrant(*appCtx, app.ID, appCtx.Session.User.ID); g != nil && authserver.ScopeCovers(g.Scope, scope) {
+		issueAuthorizationCode(appCtx, w, app, redirectURI, redirectURISupplied, scope, state, challenge)
+		return
+	}
+
+	//asking for the consent
+	key, err := authserver.NewKey()
+	if err != nil {
+		appCtx.Log.Error("error while generating the consent key", err.Error())
+		redirectAuthorization(appCtx, w, redirectURI, state, url.Values{"error": {authserver.ErrorServerError}})
+		return
+	}
+	appCtx.Session.Authorization = &config.AuthorizationRequest{
+		Key:                 key,
+		ClientID:            app.UID.String(),
+		RedirectURI:         redirectURI,
+		RedirectURISupplied: redirectURISupplied,
+		Scope:               scope,
+		State:               state,
+		CodeChallenge:       challenge,
+		Expires:             time.Now().Add(consentTimeout),
+	}
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: appCtx.Session,
+		Type:    routes.SetSession,
+	})
+	w.Header().Set("X-Frame-Options", "DENY")
+	response.WriteTemplate(appCtx, w, consentPage(appCtx), struct {
+		App    string
+		Scopes []string
+		Key    string
+	}{app.Name, strings.Fields(scope), key})
+}
+
+//authorizeConsent completes the authorization request pending in the session with the consent submitted by the user
+func authorizeConsent(appCtx *config.AppContext, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the authorization request pending in the session
+	 * If the user has denied, we will tell the app
+	 * Else we will store the consent and issue the code
+	 */
+	req := appCtx.Session.Authorization
+	if !appCtx.Session.IsAuthenticated() || req == nil || req.Expires.Before(time.Now()) ||
+		subtle.ConstantTimeCompare([]byte(r.PostFormValue("key")), []byte(req.Key)) != 1 {
+		response.WriteErrorTemplate(appCtx, w, authorizeErrorPage(appCtx), "The authorization request has expired. Please try again from the app", http.StatusBadRequest)
+		return
+	}
+	appCtx.Session.Authorization = nil
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: appCtx.Session,
+		Type:    routes.SetSession,
+	})
+	app := authserver.Client(appCtx, req.ClientID)
+	if app == nil || !authserver.ValidRedirectURI(app, req.RedirectURI) {
+		response.WriteErrorTemplate(appCtx, w, authorizeErrorPage(appCtx), "The app is not registered", http.StatusBadRequest)
+		return
+	}
+
+	//telling the app that the user has denied
+	if r.PostFormValue("consent") != "approve" {
+		appCtx.Log.Info("user", appCtx.Session.User.ID, "denied the consent to the app", app.ID)
+		redirectAuthorization(appCtx, w, req.RedirectURI, req.State, url.Values{"error": {authserver.ErrorAccessDenied}})
+		return
+	}
+
+	//storing the consent
+	g := config.GetAppGrant(*appCtx, app.ID, appCtx.Session.User.ID)
+	if g == nil {
+		g = &config.AppGrant{AppID: app.ID, UserID: appCtx.Session.User.ID}
+	}
+	for _, s := range strings.Fields(req.Scope) {
+		if !authserver.ScopeCovers(g.Scope, s) {
+			g.Scope = strings.TrimSpace(g.Scope + " " + s)
+		}
+	}
+	err := g.Save(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while storing the consent of", appCtx.Session.User.ID, "to the app", app.ID, err.Error())
+		redirectAuthorization(appCtx, w, req.RedirectURI, req.State, url.Values{"error": {authserver.ErrorServerError}})
+		return
+	}
+	appCtx.Log.Info("user", appCtx.Session.User.ID, "consented to the app", app.ID, "for", req.Scope)
+
+	issueAuthorizationCode(appCtx, w, app, req.RedirectURI, req.RedirectURISupplied, req.Scope, req.State, req.CodeChallenge)
+}
+
+//issueAuthorizationCode issues the authorization code for the logged in user and redirects the user to the app with it
+func issueAuthorizationCode(appCtx *config.AppContext, w http.ResponseWriter, app *config.AppInfo, redirectURI string, redirectURISupplied bool, scope string, state string, challenge string) {
+	code, err := authserver.NewCode(appCtx, app, appCtx.Session.User.ID, redirectURI, redirectURISupplied, scope, challenge)
+	if err != nil {
+		appCtx.Log.Error("error while issuing the authorization code to the app", app.ID, err.Error())
+		redirectAuthorization(appCtx, w, redirectURI, state, url.Values{"error": {authserver.ErrorServerError}})
+		return
+	}
+	redirectAuthorization(appCtx, w, redirectURI, state, url.Values{"code": {code}})
+}
+
+//Token is the token endpoint. The apps exchange the authorization codes and the refresh tokens for the tokens.
+//The apps get the short lived tokens for themselves with the client credentials
+func Token(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will authenticate the app
+	 * Then we will issue the tokens for the grant
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//authenticating the app
+	app, err := authserver.AuthenticateClient(appCtx, r)
+	if err != nil {
+		appCtx.Log.Warn("client authentication failed at the token endpoint")
+		writeTokenError(appCtx, w, err)
+		return
+	}
+
+	//issuing the tokens
+	var tok *authserver.Token
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		tok, err = authserver.ExchangeCode(appCtx, app, r.PostFormValue("code"), r.PostFormValue("redirect_uri"), r.PostFormValue("code_verifier"))
+	case "refresh_token":
+		tok, err = authserver.Refresh(appCtx, app, r.PostFormValue("refresh_token"), r.PostFormValue("scope"))
+	case "client_credentials":
+		tok, err = authserver.ClientCredentials(appCtx, app, r.PostFormValue("scope"))
+	default:
+		err = &authserver.Error{Code: authserver.ErrorUnsupportedGrantType, Description: "Grant type " + r.PostFormValue("grant_type") + " is not supported"}
+	}
+	if err != nil {
+		appCtx.Log.Warn("couldn't issue the token to the app", app.ID, err.Error())
+		writeTokenError(appCtx, w, err)
+		return
+	}
+	writeTokenResponse(appCtx, w, tok, http.StatusOK)
+}
+
+//Introspect is the token introspection endpoint as per rfc 7662. It tells whether the posted session token, app token
+//or the access token issued by the authorization server is active. The platform services authenticate with the
+//bearer token of the master app and can introspect any token. The registered apps authenticate with their client
+//credentials and can introspect only the tokens issued to them
+func Introspect(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will authenticate the caller
+	 * We will introspect the token
+	 * Then we will hide the tokens not issued to the app calling
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//authenticating the caller
+	var app *config.AppInfo
+	if bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); bearer != r.Header.Get("Authorization") {
+		if u, ok := config.LookupAccessToken(bearer); !ok || u.UserType != config.CuttleApp {
+			appCtx.Log.Warn("introspection requested with a token not of the master app")
+			writeTokenError(appCtx, w, &authserver.Error{Code: authserver.ErrorInvalidClient, Description: "Client authentication failed"})
+			return
+		}
+	} else {
+		var err error
+		app, err = authserver.AuthenticateClient(appCtx, r)
+		if err != nil {
+			appCtx.Log.Warn("client authentication failed at the introspection endpoint")
+			writeTokenError(appCtx, w, err)
+			return
+		}
+	}
+
+	//introspecting the token
+	token := r.PostFormValue("token")
+	if len(token) == 0 {
+		writeTokenError(appCtx, w, &authserver.Error{Code: authserver.ErrorInvalidRequest, Description: "Token is missing"})
+		return
+	}
+	result := authserver.Introspect(token)
+	if app != nil && result.AppID != app.ID {
+		result = authserver.Introspection{}
+	}
+	writeTokenResponse(appCtx, w, result, http.StatusOK)
+}
+
+//Revoke is the token revocation endpoint as per rfc 7009. The apps revoke their access tokens and refresh tokens
+//after authenticating with their client credentials. The tokens are removed from the platform services
+func Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will authenticate the app
+	 * Then we will revoke the token
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//authenticating the app
+	app, err := authserver.AuthenticateClient(appCtx, r)
+	if err != nil {
+		appCtx.Log.Warn("client authentication failed at the revocation endpoint")
+		writeTokenError(appCtx, w, err)
+		return
+	}
+
+	//revoking the token
+	err = authserver.Revoke(appCtx, app, r.PostFormValue("token"), r.PostFormValue("token_type_hint"))
+	if err != nil {
+		appCtx.Log.Warn("couldn't revoke the token of the app", app.ID, err.Error())
+		writeTokenError(appCtx, w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+//JWKS publishes the keys with which the services can verify the session tokens and the access tokens.
+//The services should fetch the keys again when a token is signed with a key they don't know
+func JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodGet {
+		response.WriteError(appCtx, w, response.Error{Err: "Only GET is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(authserver.JWKS()); err != nil {
+		appCtx.Log.Error("Error while writing the key set", err.Error())
+	}
+}
+
+//writeTokenError writes the error of the token endpoint in the oauth2 format
+func writeTokenError(appCtx *config.AppContext, w http.ResponseWriter, err error) {
+	e, ok := err.(*authserver.Error)
+	if !ok {
+		e = &authserver.Error{Code: authserver.ErrorServerError, Description: "Sorry couldn't issue the token"}
+	}
+	status := http.StatusBadRequest
+	if e.Code == authserver.ErrorInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		status = http.StatusUnauthorized
+	} else if e.Code == authserver.ErrorServerError {
+		status = http.StatusInternalServerError
+	}
+	writeTokenResponse(appCtx, w, e, status)
+}
+
+//writeTokenResponse writes the payload of the token endpoint. The responses mustn't be cached as per the spec
+func writeTokenResponse(appCtx *config.AppContext, w http.ResponseWriter, payload interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		appCtx.Log.Error("Error while writing the token response", err.Error())
+	}
+}
+
+//redirectAuthorization redirects the user to the redirect uri of the app with the params and the state
+func redirectAuthorization(appCtx *config.AppContext, w http.ResponseWriter, redirectURI string, state string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		response.WriteErrorTemplate(appCtx, w, authorizeErrorPage(appCtx), "The redirect uri of the app is invalid", http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if len(state) != 0 {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(http.StatusFound)
+}
+
+//oauthErrorValues returns the redirect params of the error
+func oauthErrorValues(err error) url.Values {
+	e, ok := err.(*authserver.Error)
+	if !ok {
+		return url.Values{"error": {authserver.ErrorServerError}}
+	}
+	return url.Values{"error": {e.Code}, "error_description": {e.Description}}
+}
+
+//authorizeLoginURL returns the url of the login page with the authorization request as the url to return to
+func authorizeLoginURL(r *http.Request) string {
+	login := authserver.LoginURL
+	if len(login) == 0 {
+		login = config.FrontendOrigin()
+	}
+	sep := "?"
+	if strings.Contains(login, "?") {
+		sep = "&"
+	}
+	return login + sep + "return_to=" + url.QueryEscape(authserver.Issuer+r.URL.RequestURI())
+}
+
+func init() {
+	routes.AddRoutes(routes.Route{
+		Version:     "v1",
+		Pattern:     "/.well-known/jwks.json",
+		HandlerFunc: JWKS,
+	})
+	if !authserver.Enabled {
+		return
+	}
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/oauth/authorize",
+			HandlerFunc: Authorize,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/oauth/token",
+			HandlerFunc: Token,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/oauth/introspect",
+			HandlerFunc: Introspect,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/oauth/revoke",
+			HandlerFunc: Revoke,
+			ParseForm:   true,
+		},
+	)
+}
@@ -0,0 +1,301 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/mailer"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+)
+
+/*
+ * This file contains the verification of the ownership of the emails not verified by the auth agents
+ */
+
+const (
+	//maxEmailVerificationAttempts is the number of wrong codes allowed for an email verification code
+	maxEmailVerificationAttempts = 5
+	//maxEmailVerificationCodes is the number of codes that can be mailed for an email verification
+	maxEmailVerificationCodes = 3
+)
+
+//EmailVerify verifies the code posted for the session waiting for the email verification. The account is marked
+//verified or created if the verification is for a sign up. Then the session is authenticated
+func EmailVerify(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will make sure the session is waiting for the email verification
+	 * We will check the expiry and the attempts of the code
+	 * We will validate the code
+	 * We will create the account of the sign up
+	 * We will mark the email of the account as verified
+	 * Then we will authenticate the session
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	ev := pendingEmailVerification(appCtx, w)
+	if ev == nil {
+		return
+	}
+
+	//checking the expiry and the attempts
+	if ev.Expires.Before(time.Now()) {
+		response.WriteError(appCtx, w, response.Error{Err: "Verification code has expired. Request a new one"}, http.StatusUnauthorized)
+		return
+	}
+	if ev.Attempts >= maxEmailVerificationAttempts {
+		appCtx.Log.Warn("too many wrong email verification codes given by", ev.UserID)
+		cancelEmailVerification(appCtx)
+		response.WriteError(appCtx, w, response.Error{Err: "Too many wrong codes. Please log in again"}, http.StatusTooManyRequests)
+		return
+	}
+
+	//validating the code. The verification is copied since the stored session shares it
+	if subtle.ConstantTimeCompare([]byte(hashVerificationCode(r.PostFormValue("code"))), []byte(ev.CodeHash)) != 1 {
+		next := *ev
+		next.Attempts++
+		appCtx.Session.EmailVerification = &next
+		go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+			Session: appCtx.Session,
+			Type:    routes.SetSession,
+		})
+		appCtx.Log.Warn("wrong email verification code given by", ev.UserID)
+		response.WriteError(appCtx, w, response.Error{Err: "Invalid verification code"}, http.StatusUnauthorized)
+		return
+	}
+
+	//creating the account of the sign up
+	var info *config.UserInfo
+	if ev.SignUp != nil {
+		var err error
+		info, err = completeSignUp(appCtx, ev)
+		if err == errAccountExists {
+			appCtx.Log.Warn("account was created for", ev.Email, "while the sign up waited for the email verification")
+			cancelEmailVerification(appCtx)
+			response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while creating the account of", ev.Email, "from", ev.Provider, err.Error())
+			response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your sign up"}, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		info = config.GetUserInfoByID(*appCtx, ev.UserID)
+	}
+
+	//marking the email as verified
+	if info == nil || !strings.EqualFold(info.Email, ev.Email) {
+		cancelEmailVerification(appCtx)
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeSessionExpired]}, http.StatusForbidden)
+		return
+	}
+	info.EmailVerified = true
+	err := info.UpdateEmailVerified(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while marking the email of", info.ID, "as verified", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't verify the email"}, http.StatusInternalServerError)
+		return
+	}
+	info.Audit(*appCtx, config.AuditEmailVerified, info.Email, r.RemoteAddr)
+	appCtx.Log.Info("verified the email of", info.ID)
+
+	authenticate(appCtx, w, info, "")
+}
+
+//EmailVerificationResend mails a new code for the session waiting for the email verification
+func EmailVerificationResend(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	ev := pendingEmailVerification(appCtx, w)
+	if ev == nil {
+		return
+	}
+	if ev.Sent >= maxEmailVerificationCodes || mailer.Default == nil {
+		appCtx.Log.Warn("email verification codes throttled for", ev.UserID)
+		response.WriteError(appCtx, w, response.Error{Err: "Too many verification codes requested. Please log in again"}, http.StatusTooManyRequests)
+		return
+	}
+	next, err := sendVerificationCode(*ev)
+	if err != nil {
+		appCtx.Log.Error("error while sending the email verification code to", ev.UserID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't send the verification code"}, http.StatusInternalServerError)
+		return
+	}
+	appCtx.Session.EmailVerification = next
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: appCtx.Session,
+		Type:    routes.SetSession,
+	})
+	response.Write(appCtx, w, "Verification code has been sent to "+next.Email)
+}
+
+//emailVerified tells whether the email of the account is verified. The account is marked verified if the auth agent
+//has verified its email. Accounts needn't be verified if the email verification is not required
+func emailVerified(appCtx *config.AppContext, i *config.UserInfo, info *config.UserInfo) bool {
+	if i.EmailVerified {
+		return true
+	}
+	if info.EmailVerified && strings.EqualFold(info.Email, i.Email) {
+		i.EmailVerified = true
+		if err := i.UpdateEmailVerified(*appCtx); err != nil {
+			//the flag will be saved on the next login
+			appCtx.Log.Error("error while marking the email of", i.ID, "as verified", err.Error())
+		}
+		return true
+	}
+	return !config.EmailVerificationRequired
+}
+
+//startEmailVerification mails a verification code to the email of the account and keeps the session pending
+//till the user enters it. The session is not authenticated till then. If the account is not created yet, it is
+//kept in the verification to be created once the email is verified
+func startEmailVerification(appCtx *config.AppContext, w http.ResponseWriter, i *config.UserInfo, returnTo string) {
+	/*
+	 * We will make sure the code can be mailed
+	 * We will mail the code
+	 * Then we will save the pending session
+	 */
+	if mailer.Default == nil {
+		appCtx.Log.Error("email of", i.ID, "can't be verified since no mail sender is configured")
+		writeLoginDenied(appCtx, w, "Your email address couldn't be verified. Please contact your administrator")
+		return
+	}
+
+	//mailing the code
+	pending := config.EmailVerification{UserID: i.ID, Email: i.Email}
+	if i.ID == 0 {
+		su := *i
+		pending.SignUp = &su
+		pending.Provider = appCtx.Session.User.AuthAgent
+	}
+	ev, err := sendVerificationCode(pending)
+	if err != nil {
+		appCtx.Log.Error("error while sending the email verification code to", i.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't send the verification code"}, http.StatusInternalServerError)
+		return
+	}
+	appCtx.Log.Info("email verification is required for the user", i.ID)
+
+	//will save the session
+	appCtx.Session.Authenticated = false
+	appCtx.Session.Pending = config.SessionEmailVerificationRequired
+	appCtx.Session.EmailVerification = ev
+	appCtx.Session.SecondFactor = ""
+	appCtx.Session.User.Email = i.Email
+	appCtx.Session.User.ID = i.ID
+	appCtx.Session.User.AccessToken = ""
+	appCtx.Session.User.IDToken = ""
+	appCtx.Session.User.Nonce = ""
+	appCtx.Session.User.ProviderToken = nil
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: appCtx.Session,
+		Type:    routes.SetSession,
+	})
+	setSessionCookie(w, appCtx.Session)
+	writeLogin(appCtx, w, returnTo, appCtx.Session)
+}
+
+//completeSignUp creates the account of the sign up waiting for the email verification along with its identity.
+//errAccountExists is returned if an account was created with the email in the meantime
+func completeSignUp(appCtx *config.AppContext, ev *config.EmailVerification) (*config.UserInfo, error) {
+	if (&config.UserInfo{Email: ev.Email}).Get(*appCtx) != nil {
+		return nil, errAccountExists
+	}
+	su := *ev.SignUp
+	su.EmailVerified = true
+	i, err := signUp(appCtx, ev.Provider, &su)
+	if err != nil {
+		return nil, err
+	}
+	appCtx.Log.Info("created the account", i.ID, "of the verified sign up from", ev.Provider)
+	return i, nil
+}
+
+//pendingEmailVerification returns the email verification of the session. If the session is not waiting for the
+//email verification the error is written and nil is returned
+func pendingEmailVerification(appCtx *config.AppContext, w http.ResponseWriter) *config.EmailVerification {
+	ev := appCtx.Session.EmailVerification
+	if appCtx.Session.Pending != config.SessionEmailVerificationRequired || ev == nil || appCtx.Session.User == nil {
+		response.WriteError(appCtx, w, response.Error{Err: "Session is not waiting for the email verification"}, http.StatusBadRequest)
+		return nil
+	}
+	return ev
+}
+
+//cancelEmailVerification clears the email verification of the session so that the user has to log in again
+func cancelEmailVerification(appCtx *config.AppContext) {
+	appCtx.Session.EmailVerification = nil
+	appCtx.Session.Pending = ""
+	appCtx.Session.User = nil
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: appCtx.Session,
+		Type:    routes.SetSession,
+	})
+}
+
+//sendVerificationCode generates a new code for the email verification and mails it. The verification with the
+//hash of the new code is returned
+func sendVerificationCode(ev config.EmailVerification) (*config.EmailVerification, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return nil, err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	ev.CodeHash = hashVerificationCode(code)
+	ev.Expires = time.Now().Add(config.EmailVerificationTTL)
+	ev.Attempts = 0
+	ev.Sent++
+	err = mailer.Default.Send(mailer.Message{
+		To:      ev.Email,
+		Subject: "Verify your email address",
+		Body: "Use the following code to verify your email address. The code is valid till " +
+			ev.Expires.Format(time.RFC1123) + ".\r\n\r\n" + code + "\r\n\r\n" +
+			"If you didn't try to log in, you can ignore this email.\r\n",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+//hashVerificationCode returns the hex encoded sha256 hash of the verification code
+func hashVerificationCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/email/verify",
+			HandlerFunc: EmailVerify,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/email/verify/resend",
+			HandlerFunc: EmailVerificationResend,
+		},
+	)
+}
@@ -0,0 +1,378 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/domainpolicy"
+	"github.com/cuttle-ai/auth-service/magiclink"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+	"golang.org/x/oauth2"
+)
+
+/*
+ * This file contains the handlers for linking the identities of the auth agents to the accounts
+ */
+
+//identityLinkTimeout is the time within which the login with the provider has to complete the identity link
+const identityLinkTimeout = 10 * time.Minute
+
+var (
+	//errAccountExists is returned when an account exists with the email of a login whose identity is not linked to it
+	errAccountExists = errors.New("An account already exists with the email. Log in to it and link this login from your account")
+	//errSignUpUnverified is returned when an account would be created with an email not verified by the auth agent.
+	//The account is created once the user verifies the email
+	errSignUpUnverified = errors.New("Email of the sign up has to be verified")
+	//errAccountUnverified is returned when a login proving the ownership of the email matches an account whose email
+	//is not verified. The account may have been created by someone else with the email
+	errAccountUnverified = errors.New("An account with the email is waiting for its email to be verified. Log in to it and verify the email")
+)
+
+//Identities returns the identities linked to the account of the logged in user
+func Identities(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	info := &config.UserInfo{}
+	info.ID = appCtx.Session.User.ID
+	ids, err := info.GetIdentities(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while getting the identities of", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't fetch the identities"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(appCtx, w, response.Message{Message: "fetched the list", Data: ids})
+}
+
+//LinkIdentity starts linking the identity of the provider posted to the account of the logged in user.
+//It returns the url with which the user has to log in to the provider to complete the link
+func LinkIdentity(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the login url of the provider
+	 * Then we will store the link in the session
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//getting the login url
+	provider := r.PostFormValue("provider")
+	u, err := loginURL(ctx, appCtx, provider)
+	if err != nil {
+		appCtx.Log.Warn("couldn't start linking", provider, "for", appCtx.Session.User.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//storing the link
+	appCtx.Session.Link = &config.IdentityLink{
+		Provider: provider,
+		User:     *appCtx.Session.User,
+		Expires:  time.Now().Add(identityLinkTimeout),
+	}
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: appCtx.Session,
+		Type:    routes.SetSession,
+	})
+	response.Write(appCtx, w, response.Message{Message: "log in with the url to link the identity", Data: u})
+}
+
+//UnlinkIdentity unlinks the identity posted from the account of the logged in user. The last way of
+//logging in to the account can't be unlinked
+func UnlinkIdentity(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the identities of the user
+	 * We will make sure the user can still log in without the identity
+	 * Then we will delete the identity and record the event
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	id, err := strconv.ParseUint(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " id"}, http.StatusBadRequest)
+		return
+	}
+
+	//getting the identities
+	info := config.GetUserInfoByID(*appCtx, appCtx.Session.User.ID)
+	if info == nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeSessionExpired]}, http.StatusForbidden)
+		return
+	}
+	ids, err := info.GetIdentities(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while getting the identities of", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't unlink the identity"}, http.StatusInternalServerError)
+		return
+	}
+	var identity *config.Identity
+	for i := range ids {
+		if ids[i].ID == uint(id) {
+			identity = &ids[i]
+		}
+	}
+	if identity == nil {
+		response.WriteError(appCtx, w, response.Error{Err: "Identity not found"}, http.StatusNotFound)
+		return
+	}
+
+	//making sure the user can still log in
+	if len(ids) == 1 && len(info.PasswordHash) == 0 && magiclink.Default == nil {
+		response.WriteError(appCtx, w, response.Error{Err: "Can't unlink the only login of the account"}, http.StatusForbidden)
+		return
+	}
+
+	//deleting the identity
+	err = identity.Delete(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while unlinking the identity", identity.ID, "of", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't unlink the identity"}, http.StatusInternalServerError)
+		return
+	}
+	info.Audit(*appCtx, config.AuditIdentityUnlinked, identity.Provider, r.RemoteAddr)
+	appCtx.Log.Info("unlinked the identity", identity.ID, "of", identity.Provider, "from", info.ID)
+	response.Write(appCtx, w, "Successfully unlinked the identity")
+}
+
+//findAccount returns the account of the user info given by the auth agent. Accounts are found by the identity of the
+//subject. First party logins without a subject are found by the email. Accounts created from google logins before the
+//identities existed are linked on their first login if google has verified the email. If no account exists and the domain policy and the invitations
+//allow the email, a new one is created with the identity. Sign ups whose email is not verified are held till the user verifies it
+func findAccount(appCtx *config.AppContext, info *config.UserInfo) (*config.UserInfo, error) {
+	agent := appCtx.Session.User.AuthAgent
+
+	//first party logins
+	if len(info.Subject) == 0 {
+		i := info.Get(*appCtx)
+		if i == nil {
+			if err := domainpolicy.Default.CheckEmail(info.Email); err != nil {
+				return nil, err
+			}
+			if err := invitationRequired(appCtx, info.Email); err != nil {
+				return nil, err
+			}
+			if !info.EmailVerified && config.EmailVerificationRequired {
+				return nil, errSignUpUnverified
+			}
+			return createAccount(appCtx, info)
+		}
+		//a login proving the ownership of the email can't take over an account whose email is not proved
+		if info.EmailVerified && !i.EmailVerified {
+			return nil, errAccountUnverified
+		}
+		return updateProfile(appCtx, i, info)
+	}
+
+	//finding by the identity
+	if id := config.GetIdentity(*appCtx, agent, info.Subject); id != nil {
+		i := config.GetUserInfoByID(*appCtx, id.UserID)
+		if i == nil {
+			return nil, errors.New("Account of the identity " + strconv.FormatUint(uint64(id.ID), 10) + " not found")
+		}
+		return updateProfile(appCtx, i, info)
+	}
+
+	//creating the account if no account exists with the email
+	i := info.Get(*appCtx)
+	if i == nil {
+		if err := domainpolicy.Default.CheckEmail(info.Email); err != nil {
+			return nil, err
+		}
+		if err := invitationRequired(appCtx, info.Email); err != nil {
+			return nil, err
+		}
+		//the email can't be taken by an account till the user proves its ownership
+		if !info.EmailVerified && config.EmailVerificationRequired {
+			return nil, errSignUpUnverified
+		}
+		return signUp(appCtx, agent, info)
+	}
+
+	//linking the legacy google accounts. Unverified emails can't be trusted to match the account. Legacy accounts
+	//never had a password, so an unverified account with one is a local sign up which can't be linked
+	if agent != oauth.GOOGLE || !info.EmailVerified {
+		return nil, errAccountExists
+	}
+	if !i.EmailVerified && len(i.PasswordHash) != 0 {
+		return nil, errAccountUnverified
+	}
+	ids, err := i.GetIdentities(*appCtx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) != 0 {
+		return nil, errAccountExists
+	}
+	err = (&config.Identity{Provider: agent, Subject: info.Subject, UserID: i.ID, Email: info.Email}).Insert(*appCtx)
+	if err != nil {
+		return nil, err
+	}
+	appCtx.Log.Info("linked the google identity to the legacy account", i.ID)
+	return updateProfile(appCtx, i, info)
+}
+
+//signUp creates the account of the user info along with the identity of the auth agent. First party sign ups
+//have no identity
+func signUp(appCtx *config.AppContext, agent string, info *config.UserInfo) (*config.UserInfo, error) {
+	i, err := createAccount(appCtx, info)
+	if err != nil || len(info.Subject) == 0 {
+		return i, err
+	}
+	return i, (&config.Identity{Provider: agent, Subject: info.Subject, UserID: i.ID, Email: info.Email}).Insert(*appCtx)
+}
+
+//createAccount creates the account of the user info. The first account is made the super admin
+func createAccount(appCtx *config.AppContext, info *config.UserInfo) (*config.UserInfo, error) {
+	err := info.Insert(*appCtx)
+	if err != nil {
+		return nil, err
+	}
+	if info.ID == 1 {
+		err := config.AddAsSuperAdmin(appCtx, info.ID, info.Email)
+		if err != nil {
+			//error while adding the user as super admin
+			appCtx.Log.Error("error shile adding the user as super admin ", info.ID, err.Error())
+		} else {
+			info.UserType = config.SuperAdmin
+		}
+	}
+	return info, nil
+}
+
+//updateProfile updates the name, the picture and the metadata of the account with the ones given by the auth agent
+func updateProfile(appCtx *config.AppContext, i *config.UserInfo, info *config.UserInfo) (*config.UserInfo, error) {
+	if i == info {
+		return i, nil
+	}
+	changed := false
+	if len(info.Name) != 0 && info.Name != i.Name {
+		i.Name = info.Name
+		changed = true
+	}
+	if len(info.Picture) != 0 && info.Picture != i.Picture {
+		i.Picture = info.Picture
+		changed = true
+	}
+	if len(info.Metadata) != 0 && !reflect.DeepEqual(info.Metadata, i.Metadata) {
+		i.Metadata = info.Metadata
+		changed = true
+	}
+	if !changed {
+		return i, nil
+	}
+	return i, i.UpdateProfile(*appCtx)
+}
+
+//linkIdentity completes the identity link of the session with the user info given by the auth agent.
+//The user of the session is restored to the user who started the link
+func linkIdentity(appCtx *config.AppContext, w http.ResponseWriter, info *config.UserInfo, returnTo string) {
+	/*
+	 * We will restore the user of the session
+	 * We will make sure the identity is not linked to another account
+	 * Then we will link the identity, store its token and record the event
+	 */
+	//restoring the user
+	l := appCtx.Session.Link
+	agent := appCtx.Session.User.AuthAgent
+	tok := appCtx.Session.User.ProviderToken
+	u := l.User
+	appCtx.Session.User = &u
+	appCtx.Session.Link = nil
+	go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+		Session: appCtx.Session,
+		Type:    routes.SetSession,
+	})
+	if agent != l.Provider || len(info.Subject) == 0 {
+		appCtx.Log.Warn("login with", agent, "doesn't match the identity link of", l.Provider, "by", u.ID)
+		response.WriteError(appCtx, w, response.Error{Err: "Login doesn't match the provider being linked"}, http.StatusBadRequest)
+		return
+	}
+
+	//making sure the identity is not linked to another account
+	id := config.GetIdentity(*appCtx, agent, info.Subject)
+	if id != nil && id.UserID != u.ID {
+		appCtx.Log.Warn("identity", id.ID, "is already linked to another account. Requested by", u.ID)
+		response.WriteError(appCtx, w, response.Error{Err: "The login is already linked to another account"}, http.StatusConflict)
+		return
+	}
+	if id != nil {
+		storeProviderToken(appCtx, id, tok)
+		writeLogin(appCtx, w, returnTo, response.Message{Message: "identity is already linked", Data: id})
+		return
+	}
+
+	//linking the identity
+	id = &config.Identity{Provider: agent, Subject: info.Subject, UserID: u.ID, Email: info.Email}
+	err := id.Insert(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while linking the identity of", agent, "to", u.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't link the identity"}, http.StatusInternalServerError)
+		return
+	}
+	storeProviderToken(appCtx, id, tok)
+	account := &config.UserInfo{}
+	account.ID = u.ID
+	account.Audit(*appCtx, config.AuditIdentityLinked, agent, "")
+	appCtx.Log.Info("linked the identity", id.ID, "of", agent, "to", u.ID)
+	writeLogin(appCtx, w, returnTo, response.Message{Message: "linked the identity", Data: id})
+}
+
+//storeProviderToken stores the token given by the auth agent for the identity. Failures are only logged
+//since the login can complete without the token
+func storeProviderToken(appCtx *config.AppContext, id *config.Identity, tok *oauth2.Token) {
+	if id == nil || tok == nil || config.TokenBox == nil {
+		return
+	}
+	err := oauth.StoreToken(appCtx, id, tok)
+	if err != nil {
+		appCtx.Log.Error("error while storing the token of the identity", id.ID, err.Error())
+	}
+}
+
+//loginURL returns the url with which the user logs in to the provider for linking its identity.
+//Providers without a login url like ldap are logged in by posting to their callback
+func loginURL(ctx context.Context, appCtx *config.AppContext, provider string) (string, error) {
+	p := oauth.GetProvider(provider)
+	if p == nil || !p.Linkable {
+		return "", errors.New(provider + " can't be linked")
+	}
+	if p.LoginURL == nil {
+		return p.CallbackPath, nil
+	}
+	return p.LoginURL(ctx, appCtx, "")
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/identities",
+			HandlerFunc:   Identities,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/identities/link",
+			HandlerFunc:   LinkIdentity,
+			ParseForm:     true,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/identities/unlink",
+			HandlerFunc:   UnlinkIdentity,
+			ParseForm:     true,
+			Authenticated: true,
+		},
+	)
+}
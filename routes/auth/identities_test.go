@@ -0,0 +1,158 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/log"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+/*
+ * This file contains the tests of finding the accounts of the logins so that an account created with an email
+ * can't be taken over by another login with the email. The config is loaded with SKIP_VAULT=true and a
+ * DISCOVERY_TOKEN. The login providers needing a config are switched off with AUTH_PROVIDERS_DISABLED=GOOGLE.
+ * The records are stored in an in-memory sqlite database
+ */
+
+//victimEmail is the email of the user whose account is attempted to be taken over
+const victimEmail = "victim@example.com"
+
+//newTestContext returns an app context with an in-memory database having an admin account so that the
+//accounts of the tests are not made the super admin
+func newTestContext(t *testing.T) *config.AppContext {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every connection opens a new in-memory database
+	db.DB().SetMaxOpenConns(1)
+	db.AutoMigrate(&config.UserInfo{}, &config.Identity{}, &config.Invitation{})
+	appCtx := &config.AppContext{Db: db, Log: log.NewLogger(0)}
+	admin := &config.UserInfo{Name: "Admin", Email: "admin@example.com", EmailVerified: true, UserType: config.SuperAdmin}
+	if err := admin.Insert(*appCtx); err != nil {
+		t.Fatal(err)
+	}
+	return appCtx
+}
+
+//login sets the auth agent of the login in the session of the app context
+func login(appCtx *config.AppContext, agent string) *config.AppContext {
+	appCtx.Session.User = &config.User{AuthAgent: agent}
+	return appCtx
+}
+
+func TestLocalSignUpHeldTillVerified(t *testing.T) {
+	appCtx := newTestContext(t)
+
+	//someone else signs up locally with the email of the victim
+	signup := &config.UserInfo{Email: victimEmail, Name: victimEmail, PasswordHash: "hash of the password of the attacker"}
+	if _, err := findAccount(login(appCtx, oauth.LOCAL), signup); err != errSignUpUnverified {
+		t.Fatalf("local sign up gave %v, expected it to wait for the email verification", err)
+	}
+	if (&config.UserInfo{Email: victimEmail}).Get(*appCtx) != nil {
+		t.Fatal("account was created before the email of the sign up was verified")
+	}
+
+	//the victim logs in with google
+	google := &config.UserInfo{Email: victimEmail, Name: "Victim", Subject: "google-subject", EmailVerified: true}
+	i, err := findAccount(login(appCtx, oauth.GOOGLE), google)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(i.PasswordHash) != 0 || !i.EmailVerified {
+		t.Errorf("account of the google login has the password of the sign up or is not verified %+v", i)
+	}
+	if id := config.GetIdentity(*appCtx, oauth.GOOGLE, google.Subject); id == nil || id.UserID != i.ID {
+		t.Errorf("google identity is not linked to the account %d", i.ID)
+	}
+
+	//the held sign up can't be completed once the account exists
+	ev := &config.EmailVerification{Email: victimEmail, SignUp: signup, Provider: oauth.LOCAL}
+	if _, err := completeSignUp(appCtx, ev); err != errAccountExists {
+		t.Errorf("completing the sign up of an existing account gave %v", err)
+	}
+}
+
+func TestLocalSignUpCompleted(t *testing.T) {
+	appCtx := newTestContext(t)
+	signup := &config.UserInfo{Email: victimEmail, Name: victimEmail, PasswordHash: "hash"}
+	ev := &config.EmailVerification{Email: victimEmail, SignUp: signup, Provider: oauth.LOCAL}
+	i, err := completeSignUp(login(appCtx, oauth.LOCAL), ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !i.EmailVerified || i.PasswordHash != "hash" || i.UserType == config.SuperAdmin {
+		t.Errorf("unexpected account of the verified sign up %+v", i)
+	}
+	ids, err := i.GetIdentities(*appCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("local sign up got the identities %+v", ids)
+	}
+}
+
+func TestUnverifiedAccountNotMatched(t *testing.T) {
+	appCtx := newTestContext(t)
+
+	//an account whose email is not verified, like the local sign ups made before they were held
+	unverified := &config.UserInfo{Email: victimEmail, Name: victimEmail, PasswordHash: "hash of the password of the attacker"}
+	if err := unverified.Insert(*appCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	//google logins are not linked to it
+	google := &config.UserInfo{Email: victimEmail, Subject: "google-subject", EmailVerified: true}
+	if _, err := findAccount(login(appCtx, oauth.GOOGLE), google); err != errAccountUnverified {
+		t.Errorf("google login to the unverified account gave %v", err)
+	}
+	if config.GetIdentity(*appCtx, oauth.GOOGLE, google.Subject) != nil {
+		t.Error("google identity was linked to the unverified account")
+	}
+
+	//magic links don't log in to it
+	magic := &config.UserInfo{Email: victimEmail, EmailVerified: true}
+	if _, err := findAccount(login(appCtx, oauth.MAGICLINK), magic); err != errAccountUnverified {
+		t.Errorf("magic link login to the unverified account gave %v", err)
+	}
+	if i := config.GetUserInfoByID(*appCtx, unverified.ID); i.EmailVerified {
+		t.Error("unverified account was marked verified")
+	}
+
+	//invitations to the email are not accepted by it
+	inv := &config.Invitation{Email: victimEmail, UserType: config.AdminUser, Expires: time.Now().Add(time.Hour)}
+	if err := inv.Insert(*appCtx); err != nil {
+		t.Fatal(err)
+	}
+	if err := acceptInvitation(appCtx, unverified); err != nil {
+		t.Fatal(err)
+	}
+	if unverified.UserType == config.AdminUser || config.GetPendingInvitation(*appCtx, victimEmail) == nil {
+		t.Error("invitation was accepted by the unverified account")
+	}
+}
+
+func TestLegacyGoogleAccountLinked(t *testing.T) {
+	appCtx := newTestContext(t)
+	legacy := &config.UserInfo{Email: victimEmail, Name: "Victim"}
+	if err := legacy.Insert(*appCtx); err != nil {
+		t.Fatal(err)
+	}
+	google := &config.UserInfo{Email: victimEmail, Subject: "google-subject", EmailVerified: true}
+	i, err := findAccount(login(appCtx, oauth.GOOGLE), google)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.ID != legacy.ID || config.GetIdentity(*appCtx, oauth.GOOGLE, google.Subject) == nil {
+		t.Errorf("legacy google account %d was not linked", legacy.ID)
+	}
+}
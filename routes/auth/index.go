@@ -62,6 +62,19 @@ func indexRedirectPage(appCtx *config.AppContext) response.Template {
 	return response.Template{T: tem, Name: "index-redirect-page"}
 }
 
+var loginDeniedTemplateString = headerText + `
+<h1>Sorry, you can't log in</h1>
+<p>{{.Reason}}</p>
+<a href="{{.URL}}">Go back</a>` + footerText
+
+func loginDeniedPage(appCtx *config.AppContext) response.Template {
+	tem, err := template.New("login-denied-page").Parse(loginDeniedTemplateString)
+	if err != nil {
+		appCtx.Log.Error("Error while initializing the login denied page template in routes/auth/index", err.Error())
+	}
+	return response.Template{T: tem, Name: "login-denied-page"}
+}
+
 var indexErrorTemplateString = headerText + `
 <span>Authenticate your self
 {{range $key, $value := .}} 
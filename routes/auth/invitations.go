@@ -0,0 +1,269 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/domainpolicy"
+	"github.com/cuttle-ai/auth-service/mailer"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+)
+
+/*
+ * This file contains the handlers for the invitations to sign up
+ */
+
+//errNotInvited is returned when an account is created without an invitation while the sign up is invitation only
+var errNotInvited = errors.New("Sign up is by invitation only. Please ask your administrator for an invitation")
+
+//invitableUserTypes are the user types which can be assigned with an invitation
+var invitableUserTypes = []string{config.NormalUser, config.ManagerUser, config.AdminUser}
+
+//Invitations returns all the invitations. Only the admins can list the invitations
+func Invitations(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if !isAdmin(appCtx) {
+		response.WriteError(appCtx, w, response.Error{Err: "You don't have the previlege to access this API."}, http.StatusForbidden)
+		return
+	}
+	invitations, err := config.GetInvitations(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while getting the invitations", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't fetch the invitations"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(appCtx, w, response.Message{Message: "fetched the list", Data: invitations})
+}
+
+//CreateInvitation invites the email posted to sign up and sends the invitation email. A pending invitation
+//of the email is replaced. Only the admins can invite
+func CreateInvitation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will make sure the logged in user is an admin and a mail sender is configured
+	 * We will validate the email and the user type
+	 * We will make sure the email has no account and is allowed by the domain policy
+	 * We will revoke the pending invitation of the email
+	 * Then we will create the invitation and send the email
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(appCtx) {
+		response.WriteError(appCtx, w, response.Error{Err: "You don't have the previlege to access this API."}, http.StatusForbidden)
+		return
+	}
+	if mailer.Default == nil {
+		response.WriteError(appCtx, w, response.Error{Err: "Invitations can't be sent since no mail sender is configured"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	//validating the email and the user type
+	addr, err := mail.ParseAddress(r.PostFormValue("email"))
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " email"}, http.StatusUnprocessableEntity)
+		return
+	}
+	email := strings.ToLower(addr.Address)
+	userType := r.PostFormValue("user_type")
+	if len(userType) == 0 {
+		userType = config.NormalUser
+	}
+	if !contains(invitableUserTypes, userType) {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " user_type"}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	//making sure the email can sign up
+	if (&config.UserInfo{Email: email}).Get(*appCtx) != nil {
+		response.WriteError(appCtx, w, response.Error{Err: "An account already exists with the email"}, http.StatusConflict)
+		return
+	}
+	if err := domainpolicy.Default.CheckEmail(email); err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	//revoking the pending invitation
+	if old := config.GetPendingInvitation(*appCtx, email); old != nil {
+		if err := old.Revoke(*appCtx); err != nil && err != config.ErrInvitationNotPending {
+			appCtx.Log.Error("error while revoking the pending invitation", old.ID, "of", email, err.Error())
+			response.WriteError(appCtx, w, response.Error{Err: "Couldn't create the invitation"}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	//creating the invitation
+	inv := &config.Invitation{
+		Email:     email,
+		UserType:  userType,
+		InvitedBy: appCtx.Session.User.ID,
+		Expires:   time.Now().Add(config.InvitationTTL),
+	}
+	err = inv.Insert(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while creating the invitation of", email, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't create the invitation"}, http.StatusInternalServerError)
+		return
+	}
+
+	//sending the email. The invitation is revoked if it couldn't be sent
+	err = mailer.Default.Send(invitationMessage(appCtx, inv))
+	if err != nil {
+		appCtx.Log.Error("error while sending the invitation", inv.ID, "to", email, err.Error())
+		if rErr := inv.Revoke(*appCtx); rErr != nil {
+			appCtx.Log.Error("error while revoking the unsent invitation", inv.ID, rErr.Error())
+		}
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't send the invitation"}, http.StatusBadGateway)
+		return
+	}
+	appCtx.Log.Info("invited", email, "as", userType, "by", appCtx.Session.User.ID)
+	response.Write(appCtx, w, response.Message{Message: "sent the invitation", Data: inv})
+}
+
+//RevokeInvitation revokes the pending invitation posted. Only the admins can revoke the invitations
+func RevokeInvitation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(appCtx) {
+		response.WriteError(appCtx, w, response.Error{Err: "You don't have the previlege to access this API."}, http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseUint(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " id"}, http.StatusBadRequest)
+		return
+	}
+	inv := config.GetInvitation(*appCtx, uint(id))
+	if inv == nil {
+		response.WriteError(appCtx, w, response.Error{Err: "Invitation not found"}, http.StatusNotFound)
+		return
+	}
+	err = inv.Revoke(*appCtx)
+	if err == config.ErrInvitationNotPending {
+		response.WriteError(appCtx, w, response.Error{Err: "Invitation has already been accepted or revoked"}, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		appCtx.Log.Error("error while revoking the invitation", inv.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't revoke the invitation"}, http.StatusInternalServerError)
+		return
+	}
+	appCtx.Log.Info("revoked the invitation", inv.ID, "by", appCtx.Session.User.ID)
+	response.Write(appCtx, w, "Successfully revoked the invitation")
+}
+
+//invitationMessage returns the email inviting to sign up
+func invitationMessage(appCtx *config.AppContext, inv *config.Invitation) mailer.Message {
+	link := config.InvitationURL
+	if len(link) == 0 {
+		link = config.FrontendOrigin()
+	}
+	return mailer.Message{
+		To:      inv.Email,
+		Subject: "You are invited to sign up",
+		Body: "You have been invited to sign up. Use the following link and log in with this email address " +
+			"before " + inv.Expires.Format(time.RFC1123) + ".\r\n\r\n" + link + "\r\n\r\n" +
+			"If you weren't expecting this invitation, you can ignore this email.\r\n",
+	}
+}
+
+//invitationRequired returns errNotInvited if the sign up is invitation only and the email has no pending
+//invitation. The first account can be created without an invitation
+func invitationRequired(appCtx *config.AppContext, email string) error {
+	if !config.InvitationOnly || !config.HasUsers(*appCtx) {
+		return nil
+	}
+	if config.GetPendingInvitation(*appCtx, email) == nil {
+		return errNotInvited
+	}
+	return nil
+}
+
+//acceptInvitation accepts the pending invitation of the user and assigns its user type. It returns errNotInvited
+//if the sign up is invitation only and the user has no pending invitation. Super admins don't need an invitation.
+//Invitations are sent to the email, so they are accepted only if the email of the user is verified
+func acceptInvitation(appCtx *config.AppContext, info *config.UserInfo) error {
+	var inv *config.Invitation
+	if info.EmailVerified {
+		inv = config.GetPendingInvitation(*appCtx, info.Email)
+	}
+	if inv == nil {
+		if config.InvitationOnly && info.UserType != config.SuperAdmin {
+			return errNotInvited
+		}
+		return nil
+	}
+	err := inv.Accept(*appCtx, info.ID)
+	if err == config.ErrInvitationNotPending {
+		return errNotInvited
+	}
+	if err != nil {
+		return err
+	}
+	if len(inv.UserType) != 0 && inv.UserType != info.UserType && info.UserType != config.SuperAdmin {
+		info.UserType = inv.UserType
+		err = info.UpdateUserType(*appCtx)
+		if err != nil {
+			return err
+		}
+	}
+	info.Audit(*appCtx, config.AuditInvitationAccepted, strconv.FormatUint(uint64(inv.ID), 10), "")
+	return nil
+}
+
+//isAdmin tells whether the logged in user is an admin
+func isAdmin(appCtx *config.AppContext) bool {
+	t := appCtx.Session.User.UserType
+	return t == config.AdminUser || t == config.SuperAdmin
+}
+
+//contains tells whether the value is in the list
+func contains(list []string, v string) bool {
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/invitations",
+			HandlerFunc:   Invitations,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/invitations/create",
+			HandlerFunc:   CreateInvitation,
+			ParseForm:     true,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/invitations/revoke",
+			HandlerFunc:   RevokeInvitation,
+			ParseForm:     true,
+			Authenticated: true,
+		},
+	)
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/ldap"
+	"github.com/cuttle-ai/auth-service/oauth"
+)
+
+/*
+ * This file contains the login provider of the ldap directory
+ */
+
+//ldapProvider returns the login provider of the ldap directory. It has no login url since the users
+//post their username and password to the callback
+func ldapProvider() *oauth.Provider {
+	return &oauth.Provider{
+		Name:         oauth.LDAP,
+		Label:        "LDAP",
+		CallbackPath: "/auth/ldap",
+		Callback:     ldapLogin,
+		Linkable:     true,
+	}
+}
+
+//ldapLogin authenticates the user by binding to the ldap directory with the username and password posted
+func ldapLogin(ctx context.Context, appCtx *config.AppContext, r *http.Request) (*config.UserInfo, error) {
+	/*
+	 * We will authenticate the user against the directory
+	 * Then we will set the user
+	 */
+	if r.Method != http.MethodPost {
+		return nil, &oauth.CallbackError{Status: http.StatusMethodNotAllowed, Message: "Only POST is supported"}
+	}
+
+	//authenticating the user
+	e, err := ldap.Directory.Authenticate(r.PostFormValue("username"), r.PostFormValue("password"))
+	if err == ldap.ErrInvalidCredentials {
+		return nil, &oauth.CallbackError{Status: http.StatusUnauthorized, Message: err.Error(), Err: err}
+	}
+	if err != nil {
+		return nil, &oauth.CallbackError{Status: http.StatusBadGateway, Message: "Sorry couldn't complete your login", Err: err}
+	}
+
+	//we will set the user
+	appCtx.Session.User = &config.User{
+		AuthAgent: oauth.LDAP,
+		Email:     e.Email,
+	}
+	return &config.UserInfo{
+		Email:   e.Email,
+		Name:    e.Name,
+		Picture: e.Picture,
+		Subject: e.DN,
+		//the directory is the source of truth of the email
+		EmailVerified: true,
+	}, nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/domainpolicy"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/password"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+)
+
+/*
+ * This file contains the handlers for the local accounts logging in with email and password
+ */
+
+//dummyHash is verified against when the account doesn't exist so that the response time
+//doesn't reveal whether an account exists
+var dummyHash string
+
+//LocalSignup signs up a local account with the email and password posted. The account is created and the user
+//is logged in once the email is verified
+func LocalSignup(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will validate the email and the password
+	 * We will make sure no account exists with the email
+	 * We will hash the password
+	 * Then we will set the user and start the user session
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	if !config.LocalSignup {
+		response.WriteError(appCtx, w, response.Error{Err: "Signup is not enabled"}, http.StatusForbidden)
+		return
+	}
+
+	//validating the email and the password
+	addr, err := mail.ParseAddress(r.PostFormValue("email"))
+	if err != nil {
+		appCtx.Log.Error("invalid email given for signup", r.PostFormValue("email"))
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " email"}, http.StatusUnprocessableEntity)
+		return
+	}
+	email := strings.ToLower(addr.Address)
+	if err := domainpolicy.Default.CheckEmail(email); err != nil {
+		appCtx.Log.Warn("signup of", email, "is denied by the domain policy")
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusForbidden)
+		return
+	}
+	if err := invitationRequired(appCtx, email); err != nil {
+		appCtx.Log.Warn("signup of", email, "has no invitation")
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusForbidden)
+		return
+	}
+	pass := r.PostFormValue("password")
+	err = password.Validate(pass)
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	//making sure no account exists with the email
+	info := &config.UserInfo{Email: email, Name: strings.TrimSpace(r.PostFormValue("name"))}
+	if info.Get(*appCtx) != nil {
+		appCtx.Log.Warn("signup attempted for an existing account", email)
+		response.WriteError(appCtx, w, response.Error{Err: "An account already exists with the email"}, http.StatusConflict)
+		return
+	}
+	if len(info.Name) == 0 {
+		info.Name = email
+	}
+
+	//hashing the password
+	info.PasswordHash, err = password.Hash(pass)
+	if err != nil {
+		appCtx.Log.Error("error while hashing the password for signup", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your signup"}, http.StatusInternalServerError)
+		return
+	}
+
+	//we will set the user
+	appCtx.Session.User = &config.User{AuthAgent: oauth.LOCAL, Email: email}
+	startSession(appCtx, w, info, "")
+}
+
+//LocalLogin logs in the user with the email and password of the local account
+func LocalLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the account of the email
+	 * We will verify the password
+	 * If the password hash is outdated we will upgrade it
+	 * Then we will set the user and start the user session
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(r.PostFormValue("email")))
+	pass := r.PostFormValue("password")
+
+	//getting the account
+	info := (&config.UserInfo{Email: email}).Get(*appCtx)
+	hash := dummyHash
+	if info != nil && len(info.PasswordHash) != 0 {
+		hash = info.PasswordHash
+	}
+
+	//verifying the password
+	rehash, err := password.Verify(pass, hash)
+	if err != nil || hash == dummyHash {
+		appCtx.Log.Warn("invalid local account credentials given for", email)
+		response.WriteError(appCtx, w, response.Error{Err: "Invalid email or password"}, http.StatusUnauthorized)
+		return
+	}
+
+	//upgrading the outdated hash
+	if rehash {
+		upgradePassword(appCtx, info, pass)
+	}
+
+	//we will set the user
+	appCtx.Session.User = &config.User{AuthAgent: oauth.LOCAL, Email: info.Email}
+	startSession(appCtx, w, info, "")
+}
+
+//LocalChangePassword changes the password of the local account of the logged in user.
+//Users without a local account can use it to set a password
+func LocalChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the account of the user
+	 * If the account has a password we will verify the current password
+	 * We will validate the new password
+	 * Then we will update the password hash
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//getting the account
+	info := config.GetUserInfoByID(*appCtx, appCtx.Session.User.ID)
+	if info == nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeSessionExpired]}, http.StatusForbidden)
+		return
+	}
+
+	//verifying the current password
+	if len(info.PasswordHash) != 0 {
+		if _, err := password.Verify(r.PostFormValue("current_password"), info.PasswordHash); err != nil {
+			appCtx.Log.Warn("invalid current password given for changing the password by", info.ID)
+			response.WriteError(appCtx, w, response.Error{Err: "Current password is wrong"}, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	//validating the new password
+	pass := r.PostFormValue("new_password")
+	err := password.Validate(pass)
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	//updating the password hash
+	info.PasswordHash, err = password.Hash(pass)
+	if err == nil {
+		err = info.UpdatePassword(*appCtx)
+	}
+	if err != nil {
+		appCtx.Log.Error("error while updating the password of", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't change the password"}, http.StatusInternalServerError)
+		return
+	}
+
+	response.Write(appCtx, w, "Successfully changed the password")
+}
+
+//upgradePassword rehashes the password with the current params and saves it. Failures are only logged
+//since the login can continue with the old hash
+func upgradePassword(appCtx *config.AppContext, info *config.UserInfo, pass string) {
+	h, err := password.Hash(pass)
+	if err != nil {
+		appCtx.Log.Error("error while upgrading the password hash of", info.ID, err.Error())
+		return
+	}
+	info.PasswordHash = h
+	err = info.UpdatePassword(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while saving the upgraded password hash of", info.ID, err.Error())
+		return
+	}
+	appCtx.Log.Info("upgraded the password hash of", info.ID)
+}
+
+func init() {
+	if !config.LocalAccounts {
+		return
+	}
+	var err error
+	dummyHash, err = password.Hash("dummy-password-for-timing")
+	if err != nil {
+		panic(err)
+	}
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/local/signup",
+			HandlerFunc: LocalSignup,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/local/login",
+			HandlerFunc: LocalLogin,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/local/password",
+			HandlerFunc:   LocalChangePassword,
+			ParseForm:     true,
+			Authenticated: true,
+		},
+	)
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/magiclink"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+)
+
+/*
+ * This file contains the handlers for the passwordless login with the magic links
+ */
+
+//magicLinkConfirmTemplateString is the page shown when the link is opened. The link is consumed only
+//on submitting the form so that the link scanners of the mail clients don't use up the link
+var magicLinkConfirmTemplateString = headerText + `
+<form method="POST">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Continue to login</button>
+</form>` + footerText
+
+func magicLinkConfirmPage(appCtx *config.AppContext) response.Template {
+	tem, err := template.New("magic-link-confirm-page").Parse(magicLinkConfirmTemplateString)
+	if err != nil {
+		appCtx.Log.Error("Error while initializing the magic link confirm page template in routes/auth/magiclink", err.Error())
+	}
+	return response.Template{T: tem, Name: "magic-link-confirm-page"}
+}
+
+//MagicLinkSend sends a login link to the email posted
+func MagicLinkSend(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will validate the email
+	 * Then we will send the link to the email
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//validating the email
+	addr, err := mail.ParseAddress(r.PostFormValue("email"))
+	if err != nil {
+		appCtx.Log.Error("invalid email given for the magic link", r.PostFormValue("email"))
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " email"}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	//sending the link
+	err = magiclink.Default.Send(addr.Address)
+	if err == magiclink.ErrThrottled {
+		appCtx.Log.Warn("magic link requests throttled for", addr.Address)
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		appCtx.Log.Error("error while sending the magic link to", addr.Address, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't send the login link"}, http.StatusInternalServerError)
+		return
+	}
+
+	response.Write(appCtx, w, "Login link has been sent to "+addr.Address)
+}
+
+//MagicLinkVerify shows the confirm page when the link is opened and logs in the user when it is submitted
+func MagicLinkVerify(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * If the link is opened we will show the confirm page
+	 * We will verify the token submitted
+	 * We will get the account of the email
+	 * Then we will set the user and start the user session
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method == http.MethodGet {
+		response.WriteTemplate(appCtx, w, magicLinkConfirmPage(appCtx), r.URL.Query().Get("token"))
+		return
+	}
+
+	//verifying the token
+	email, err := magiclink.Default.Verify(r.PostFormValue("token"))
+	if err != nil && err != magiclink.ErrInvalidLink && err != magiclink.ErrExpired && err != magiclink.ErrUsed {
+		appCtx.Log.Error("error while verifying the magic link", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't verify the login link"}, http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		appCtx.Log.Warn("invalid magic link submitted", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusUnauthorized)
+		return
+	}
+
+	//getting the account. New accounts are created with the email as the name
+	info := (&config.UserInfo{Email: email}).Get(*appCtx)
+	if info == nil {
+		info = &config.UserInfo{Email: email, Name: email}
+	}
+	//the user has proved the ownership of the email by opening the link
+	info.EmailVerified = true
+
+	//we will set the user
+	appCtx.Session.User = &config.User{AuthAgent: oauth.MAGICLINK, Email: email}
+	startSession(appCtx, w, info, "")
+}
+
+//usedLinks records the nonces of the used magic links in the database so that a link can be used only once
+//across the instances of the auth service
+type usedLinks struct{}
+
+//MarkUsed records the nonce as used till the link expires. It returns magiclink.ErrUsed if it was used already
+func (usedLinks) MarkUsed(nonce string, expires time.Time) error {
+	ok, err := config.MarkNonceUsed(*config.NewAppContext(nil), nonce, expires)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return magiclink.ErrUsed
+	}
+	return nil
+}
+
+func init() {
+	if magiclink.Default == nil {
+		return
+	}
+	magiclink.Default.Used = usedLinks{}
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/magic",
+			HandlerFunc: MagicLinkSend,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/magic/verify",
+			HandlerFunc: MagicLinkVerify,
+			ParseForm:   true,
+		},
+	)
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/domainpolicy"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+
+	"github.com/cuttle-ai/auth-service/ldap"
+
+	//the login providers register themselves in their init
+	_ "github.com/cuttle-ai/auth-service/oauth/github"
+	_ "github.com/cuttle-ai/auth-service/oauth/google"
+	_ "github.com/cuttle-ai/auth-service/oauth/oidc"
+	_ "github.com/cuttle-ai/auth-service/saml"
+)
+
+/*
+ * This file contains the callback handlers of the registered login providers
+ */
+
+//repostTemplateString is the page posting the callback of a provider again from the auth service so that the
+//browser sends the session cookie along with it
+var repostTemplateString = headerText + `
+<form method="POST">{{range $k, $v := .}}{{range $v}}
+<input type="hidden" name="{{$k}}" value="{{.}}">{{end}}{{end}}
+<input type="hidden" name="repost" value="true">
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+<script>document.forms[0].submit();</script>` + footerText
+
+func repostPage(appCtx *config.AppContext) response.Template {
+	tem, err := template.New("repost-page").Parse(repostTemplateString)
+	if err != nil {
+		appCtx.Log.Error("Error while initializing the repost page template in routes/auth/providers", err.Error())
+	}
+	return response.Template{T: tem, Name: "repost-page"}
+}
+
+//ProviderCallback returns the callback handler of the given login provider
+func ProviderCallback(p *oauth.Provider) routes.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		/*
+		 * If the provider posted cross site, we will ask the browser to post again with the session cookie
+		 * We will complete the login with the provider. A failed login isn't saved to the session
+		 * The browser logging in with a login url is redirected to the frontend if no url to return to was given
+		 * Then we will start the user session
+		 */
+		appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+
+		//posting again with the session cookie
+		if p.Repost && r.Method == http.MethodPost && len(r.PostFormValue("repost")) == 0 {
+			response.WriteTemplate(appCtx, w, repostPage(appCtx), r.PostForm)
+			return
+		}
+
+		//completing the login with the provider
+		info, err := p.Callback(ctx, appCtx, r)
+		returnTo := appCtx.Session.ReturnTo
+		appCtx.Session.ReturnTo = ""
+		if cErr, ok := err.(*oauth.CallbackError); ok {
+			if pErr, ok := cErr.Err.(*domainpolicy.Error); ok {
+				appCtx.Log.Warn("login with", p.Name, "is denied by the domain policy", pErr.Reason)
+				writeLoginDenied(appCtx, w, pErr.Reason)
+				return
+			}
+			appCtx.Log.Error("Error while completing the login with", p.Name, cErr.Error())
+			response.WriteError(appCtx, w, response.Error{Err: callbackErrorMessage(cErr)}, cErr.Status)
+			return
+		}
+		if err != nil || info == nil {
+			appCtx.Log.Error("Error while completing the login with", p.Name, err)
+			response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't complete your login"}, http.StatusForbidden)
+			return
+		}
+
+		//getting the url to return to
+		if len(returnTo) == 0 && p.LoginURL != nil {
+			returnTo = config.FrontendOrigin()
+		}
+
+		startSession(appCtx, w, info, returnTo)
+	}
+}
+
+//callbackErrorMessage returns the message of the callback error to be shown to the user
+func callbackErrorMessage(err *oauth.CallbackError) string {
+	switch err.Err {
+	case oauth.ErrInvalidState:
+		return response.ErrorCodes[response.ErrorCodeInvalidState]
+	case oauth.ErrStateExpired:
+		return response.ErrorCodes[response.ErrorCodeStateExpired]
+	}
+	return err.Message
+}
+
+func init() {
+	/*
+	 * We will register the ldap directory since the ldap package is kept free of the app context
+	 * Then we will add the callback routes of the registered providers
+	 */
+	if ldap.Directory != nil {
+		oauth.Register(ldapProvider())
+	}
+	for _, p := range oauth.Providers() {
+		routes.AddRoutes(routes.Route{
+			Version:     "v1",
+			Pattern:     p.CallbackPath,
+			HandlerFunc: ProviderCallback(p),
+			ParseForm:   true,
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/recovery"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+)
+
+/*
+ * This file contains the handlers for the one time recovery codes
+ */
+
+//RecoveryCodesStatus is the status of the recovery codes of a user
+type RecoveryCodesStatus struct {
+	//Total is the number of codes in the batch
+	Total int
+	//Remaining is the number of unused codes
+	Remaining int
+}
+
+//RecoveryCodes returns the status of the recovery codes of the logged in user. The codes themselves are shown
+//only when they are generated
+func RecoveryCodes(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	info := &config.UserInfo{}
+	info.ID = appCtx.Session.User.ID
+	codes, err := info.GetRecoveryCodes(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while getting the recovery codes of", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't fetch the recovery codes"}, http.StatusInternalServerError)
+		return
+	}
+	status := RecoveryCodesStatus{Total: len(codes)}
+	for _, c := range codes {
+		if c.UsedAt == nil {
+			status.Remaining++
+		}
+	}
+	response.Write(appCtx, w, status)
+}
+
+//GenerateRecoveryCodes generates a new batch of recovery codes for the logged in user replacing the existing ones.
+//The codes are returned only in this response
+func GenerateRecoveryCodes(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will generate the codes
+	 * We will replace the stored codes with the hashes of the new ones
+	 * Then we will record the event and return the codes
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	info := &config.UserInfo{}
+	info.ID = appCtx.Session.User.ID
+
+	//generating the codes
+	codes, err := recovery.Generate()
+	if err != nil {
+		appCtx.Log.Error("error while generating the recovery codes for", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't generate the recovery codes"}, http.StatusInternalServerError)
+		return
+	}
+
+	//replacing the stored codes
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = recovery.Hash(c)
+	}
+	err = info.ReplaceRecoveryCodes(*appCtx, hashes)
+	if err != nil {
+		appCtx.Log.Error("error while storing the recovery codes of", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't generate the recovery codes"}, http.StatusInternalServerError)
+		return
+	}
+
+	info.Audit(*appCtx, config.AuditRecoveryCodesGenerated, strconv.Itoa(len(codes))+" codes", r.RemoteAddr)
+	appCtx.Log.Info("generated the recovery codes for", info.ID)
+	response.Write(appCtx, w, response.Message{Message: "generated the recovery codes", Data: codes})
+}
+
+//RedeemRecoveryCode verifies the session waiting for the second factor with the recovery code posted. The code
+//can't be used again
+func RedeemRecoveryCode(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the user of the pending session
+	 * We will find the unused code and mark it as used
+	 * We will record the use
+	 * Then we will activate the session
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if appCtx.Session.Pending != config.SessionMFARequired {
+		response.WriteError(appCtx, w, response.Error{Err: "Session is not waiting for the second factor"}, http.StatusBadRequest)
+		return
+	}
+	info := sessionUserInfo(appCtx, w)
+	if info == nil || secondFactorThrottled(appCtx, w, info) {
+		return
+	}
+
+	//finding the code and marking it as used
+	code := info.GetUnusedRecoveryCode(*appCtx, recovery.Hash(r.PostFormValue("code")))
+	if code == nil {
+		secondFactorFailed(info)
+		appCtx.Log.Warn("wrong recovery code given by", info.ID)
+		response.WriteError(appCtx, w, response.Error{Err: "Invalid recovery code"}, http.StatusUnauthorized)
+		return
+	}
+	err := code.MarkUsed(*appCtx)
+	if err == config.ErrRecoveryCodeUsed {
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		appCtx.Log.Error("error while marking the recovery code", code.ID, "of", info.ID, "as used", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't verify the recovery code"}, http.StatusInternalServerError)
+		return
+	}
+
+	//recording the use
+	info.Audit(*appCtx, config.AuditRecoveryCodeUsed, "code "+strconv.FormatUint(uint64(code.ID), 10), r.RemoteAddr)
+	appCtx.Log.Info("recovery code", code.ID, "used by", info.ID)
+
+	appCtx.Session.SecondFactor = oauth.RECOVERYCODE
+	activateSession(appCtx, w, "")
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/recovery-codes",
+			HandlerFunc:   RecoveryCodes,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/recovery-codes/generate",
+			HandlerFunc:   GenerateRecoveryCodes,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/recovery-codes/redeem",
+			HandlerFunc: RedeemRecoveryCode,
+			ParseForm:   true,
+		},
+	)
+}
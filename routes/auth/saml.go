@@ -0,0 +1,83 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+	"github.com/cuttle-ai/auth-service/saml"
+)
+
+/*
+ * This file contains the handlers for the saml service provider
+ */
+
+//SAMLMetadata returns the saml service provider metadata to be imported by the identity providers
+func SAMLMetadata(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, err := w.Write(saml.SPMetadata())
+	if err != nil {
+		appCtx.Log.Error("Error while writing the saml service provider metadata", err.Error())
+	}
+}
+
+//SAMLLogin returns the handler which redirects the user to the given saml identity provider for login.
+//The return_to query param is the allowed url to which the browser is redirected after the login
+func SAMLLogin(idp *saml.IdentityProvider) routes.HandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+		returnTo := r.URL.Query().Get("return_to")
+		if len(returnTo) != 0 && !config.ReturnToAllowed(returnTo) {
+			appCtx.Log.Warn("return to url is not allowed", returnTo)
+			response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " return_to"}, http.StatusBadRequest)
+			return
+		}
+		u, err := idp.AuthnRequestURL(ctx, appCtx, returnTo)
+		if err != nil {
+			//error while creating the authentication request
+			appCtx.Log.Error("Error while creating the saml authentication request for", idp.Name)
+			appCtx.Log.Error(err.Error())
+			response.WriteError(appCtx, w, response.Error{Err: "Sorry couldn't start your login"}, http.StatusBadGateway)
+			return
+		}
+
+		//saving the session with the state of the login
+		go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+			Session: appCtx.Session,
+			Type:    routes.SetSession,
+		})
+		http.Redirect(w, r, u, http.StatusFound)
+	}
+}
+
+func init() {
+	if len(saml.IdentityProviders) == 0 {
+		return
+	}
+	routes.AddRoutes(routes.Route{
+		Version:     "v1",
+		Pattern:     "/auth/saml/metadata",
+		HandlerFunc: SAMLMetadata,
+	})
+	for _, idp := range saml.IdentityProviders {
+		if oauth.GetProvider(idp.AgentName()) == nil {
+			//login with the identity provider is switched off
+			continue
+		}
+		routes.AddRoutes(
+			routes.Route{
+				Version:     "v1",
+				Pattern:     idp.Path() + "/login",
+				HandlerFunc: SAMLLogin(idp),
+			},
+		)
+	}
+}
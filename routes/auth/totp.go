@@ -0,0 +1,276 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+	"github.com/cuttle-ai/auth-service/totp"
+)
+
+/*
+ * This file contains the handlers for the totp enrollment and verification
+ */
+
+const (
+	//maxSecondFactorFailures is the number of wrong second factor codes allowed for a user in the secondFactorFailureWindow
+	maxSecondFactorFailures = 5
+	//secondFactorFailureWindow is the window in which the wrong second factor codes of a user are counted
+	secondFactorFailureWindow = 5 * time.Minute
+)
+
+//secondFactorFailures has the times of the wrong second factor codes given by the users mapped to the user id
+var secondFactorFailures = struct {
+	times map[uint][]time.Time
+	lock  sync.Mutex
+}{times: map[uint][]time.Time{}}
+
+//TOTPSecret is the response of the totp enrollment
+type TOTPSecret struct {
+	//Secret is the base32 encoded secret for entering manually in the authenticator app
+	Secret string
+	//URI is the otpauth provisioning uri to be shown as the QR code
+	URI string
+}
+
+//TOTPEnroll generates a new totp secret for the user. The secret is used only after the enrollment is confirmed.
+//Users who have to set up a second factor can enroll while their session is pending
+func TOTPEnroll(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the user info
+	 * We will make sure the pending sessions can't replace an enrolled second factor
+	 * Then we will generate and store the secret
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	info := sessionUserInfo(appCtx, w)
+	if info == nil {
+		return
+	}
+
+	//making sure the second factor is not replaced
+	if appCtx.Session.Pending == config.SessionMFARequired && secondFactorEnrolled(appCtx, info) {
+		response.WriteError(appCtx, w, response.Error{Err: "Verify with your second factor to continue"}, http.StatusForbidden)
+		return
+	}
+	if info.TOTPEnabled {
+		response.WriteError(appCtx, w, response.Error{Err: "Two factor authentication is already enabled"}, http.StatusConflict)
+		return
+	}
+
+	//generating the secret
+	secret, err := totp.GenerateSecret()
+	if err == nil {
+		info.TOTPSecret = secret
+		err = info.UpdateTOTP(*appCtx)
+	}
+	if err != nil {
+		appCtx.Log.Error("error while generating the totp secret for", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't enroll the two factor authentication"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(appCtx, w, TOTPSecret{Secret: secret, URI: totp.ProvisioningURI(config.MFAIssuer, info.Email, secret)})
+}
+
+//TOTPConfirm confirms the totp enrollment with the code posted. If the session was waiting for the second factor,
+//it is activated
+func TOTPConfirm(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	info := sessionUserInfo(appCtx, w)
+	if info == nil {
+		return
+	}
+	if info.TOTPEnabled || len(info.TOTPSecret) == 0 {
+		response.WriteError(appCtx, w, response.Error{Err: "No pending two factor enrollment"}, http.StatusBadRequest)
+		return
+	}
+	if !validateTOTP(appCtx, w, info, r.PostFormValue("code")) {
+		return
+	}
+	info.TOTPEnabled = true
+	err := info.UpdateTOTP(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while enabling the totp for", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't enroll the two factor authentication"}, http.StatusInternalServerError)
+		return
+	}
+	appCtx.Log.Info("enabled the totp for", info.ID)
+	if appCtx.Session.Pending == config.SessionMFARequired {
+		appCtx.Session.SecondFactor = oauth.TOTP
+		activateSession(appCtx, w, "")
+		return
+	}
+	response.Write(appCtx, w, "Successfully enabled the two factor authentication")
+}
+
+//TOTPVerify verifies the code posted for the session waiting for the second factor and activates the session
+func TOTPVerify(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if appCtx.Session.Pending != config.SessionMFARequired {
+		response.WriteError(appCtx, w, response.Error{Err: "Session is not waiting for the second factor"}, http.StatusBadRequest)
+		return
+	}
+	info := sessionUserInfo(appCtx, w)
+	if info == nil {
+		return
+	}
+	if !info.TOTPEnabled {
+		response.WriteError(appCtx, w, response.Error{Err: "Two factor authentication is not enabled"}, http.StatusBadRequest)
+		return
+	}
+	if !validateTOTP(appCtx, w, info, r.PostFormValue("code")) {
+		return
+	}
+	appCtx.Session.SecondFactor = oauth.TOTP
+	activateSession(appCtx, w, "")
+}
+
+//TOTPDisable disables the totp of the user after verifying the code posted. Users who have to use a second factor
+//can disable it only if they have a passkey
+func TOTPDisable(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	info := sessionUserInfo(appCtx, w)
+	if info == nil {
+		return
+	}
+	if !info.TOTPEnabled {
+		response.WriteError(appCtx, w, response.Error{Err: "Two factor authentication is not enabled"}, http.StatusBadRequest)
+		return
+	}
+	if !validateTOTP(appCtx, w, info, r.PostFormValue("code")) {
+		return
+	}
+	if config.MFARequired(info.UserType) {
+		creds, err := info.GetWebAuthnCredentials(*appCtx)
+		if err != nil || len(creds) == 0 {
+			response.WriteError(appCtx, w, response.Error{Err: "Two factor authentication is required for your account"}, http.StatusForbidden)
+			return
+		}
+	}
+	info.TOTPEnabled = false
+	info.TOTPSecret = ""
+	err := info.UpdateTOTP(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while disabling the totp for", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't disable the two factor authentication"}, http.StatusInternalServerError)
+		return
+	}
+	appCtx.Log.Info("disabled the totp for", info.ID)
+	response.Write(appCtx, w, "Successfully disabled the two factor authentication")
+}
+
+//sessionUserInfo returns the user info of the session. The session can be pending. If the session is not authenticated
+//the error is written and nil is returned
+func sessionUserInfo(appCtx *config.AppContext, w http.ResponseWriter) *config.UserInfo {
+	if !appCtx.Session.Authenticated || appCtx.Session.User == nil {
+		response.WriteError(appCtx, w, response.Error{Err: "You have to be logged in to access this API."}, http.StatusForbidden)
+		return nil
+	}
+	info := config.GetUserInfoByID(*appCtx, appCtx.Session.User.ID)
+	if info == nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeSessionExpired]}, http.StatusForbidden)
+		return nil
+	}
+	return info
+}
+
+//validateTOTP validates the code of the user and stores its step so that it can't be used again.
+//If the code is not valid the error is written and false is returned
+func validateTOTP(appCtx *config.AppContext, w http.ResponseWriter, info *config.UserInfo, code string) bool {
+	/*
+	 * We will check the failures of the user
+	 * We will validate the code
+	 * Then we will store the step of the code
+	 */
+	//checking the failures
+	if secondFactorThrottled(appCtx, w, info) {
+		return false
+	}
+
+	//validating the code
+	step, err := totp.Validate(info.TOTPSecret, code, time.Now(), info.TOTPLastStep)
+	if err != nil {
+		secondFactorFailed(info)
+		appCtx.Log.Warn("wrong totp code given by", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: err.Error()}, http.StatusUnauthorized)
+		return false
+	}
+
+	//storing the step
+	info.TOTPLastStep = step
+	err = info.UpdateTOTP(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while storing the totp step of", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't verify the code"}, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+//secondFactorThrottled tells whether the user has given too many wrong second factor codes recently.
+//If so the error is written
+func secondFactorThrottled(appCtx *config.AppContext, w http.ResponseWriter, info *config.UserInfo) bool {
+	secondFactorFailures.lock.Lock()
+	defer secondFactorFailures.lock.Unlock()
+	recent := []time.Time{}
+	for _, t := range secondFactorFailures.times[info.ID] {
+		if time.Since(t) < secondFactorFailureWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		delete(secondFactorFailures.times, info.ID)
+	} else {
+		secondFactorFailures.times[info.ID] = recent
+	}
+	if len(recent) < maxSecondFactorFailures {
+		return false
+	}
+	appCtx.Log.Warn("too many wrong second factor codes given by", info.ID)
+	response.WriteError(appCtx, w, response.Error{Err: "Too many wrong codes. Please try again later"}, http.StatusTooManyRequests)
+	return true
+}
+
+//secondFactorFailed records a wrong second factor code given by the user
+func secondFactorFailed(info *config.UserInfo) {
+	secondFactorFailures.lock.Lock()
+	defer secondFactorFailures.lock.Unlock()
+	secondFactorFailures.times[info.ID] = append(secondFactorFailures.times[info.ID], time.Now())
+}
+
+func init() {
+	routes.AddRoutes(
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/totp/enroll",
+			HandlerFunc: TOTPEnroll,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/totp/confirm",
+			HandlerFunc: TOTPConfirm,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/totp/verify",
+			HandlerFunc: TOTPVerify,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/totp/disable",
+			HandlerFunc:   TOTPDisable,
+			ParseForm:     true,
+			Authenticated: true,
+		},
+	)
+}
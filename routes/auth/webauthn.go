@@ -0,0 +1,325 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+	"github.com/cuttle-ai/auth-service/routes"
+	"github.com/cuttle-ai/auth-service/routes/response"
+	"github.com/cuttle-ai/auth-service/webauthn"
+)
+
+/*
+ * This file contains the handlers for the webauthn passkeys registration and login
+ */
+
+//maxWebAuthnBody is the maximum size of the webauthn responses accepted
+const maxWebAuthnBody = 64 * 1024
+
+//WebAuthnRegisterBegin returns the options for registering a new passkey for the logged in user
+func WebAuthnRegisterBegin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will get the user info and the passkeys already registered
+	 * Then we will start the registration
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+
+	//getting the user info and the passkeys
+	info, creds, err := webAuthnCredentials(appCtx, appCtx.Session.User.ID)
+	if err != nil {
+		appCtx.Log.Error("error while getting the passkeys of", appCtx.Session.User.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't start the passkey registration"}, http.StatusInternalServerError)
+		return
+	}
+
+	//starting the registration
+	o, err := webauthn.RelyingParty.BeginRegistration(info.ID, info.Email, info.Name, credentialIDs(creds))
+	if err != nil {
+		appCtx.Log.Error("error while starting the passkey registration for", info.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't start the passkey registration"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(appCtx, w, o)
+}
+
+//WebAuthnRegisterFinish verifies the attestation posted and stores the passkey of the logged in user.
+//The name of the passkey is given as the name query param
+func WebAuthnRegisterFinish(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will verify the attestation
+	 * We will make sure the passkey is not registered already
+	 * Then we will store the passkey
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebAuthnBody))
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " body"}, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	//verifying the attestation
+	cred, err := webauthn.RelyingParty.FinishRegistration(appCtx.Session.User.ID, body)
+	if err != nil {
+		appCtx.Log.Warn("invalid passkey registration by", appCtx.Session.User.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't verify the passkey"}, http.StatusBadRequest)
+		return
+	}
+
+	//making sure the passkey is not registered
+	id := base64.RawURLEncoding.EncodeToString(cred.ID)
+	if config.GetWebAuthnCredential(*appCtx, id) != nil {
+		response.WriteError(appCtx, w, response.Error{Err: "Passkey is already registered"}, http.StatusConflict)
+		return
+	}
+
+	//storing the passkey
+	m := &config.WebAuthnCredential{
+		UserID:       cred.UserID,
+		CredentialID: id,
+		PublicKey:    cred.PublicKey,
+		SignCount:    cred.SignCount,
+		Name:         r.URL.Query().Get("name"),
+	}
+	if len(m.Name) == 0 {
+		m.Name = "Passkey"
+	}
+	err = m.Insert(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while storing the passkey of", cred.UserID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't store the passkey"}, http.StatusInternalServerError)
+		return
+	}
+	appCtx.Log.Info("registered a passkey for", cred.UserID, "with id", m.ID)
+	response.Write(appCtx, w, response.Message{Message: "registered the passkey", Data: m})
+}
+
+//WebAuthnListCredentials returns the passkeys of the logged in user
+func WebAuthnListCredentials(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	_, creds, err := webAuthnCredentials(appCtx, appCtx.Session.User.ID)
+	if err != nil {
+		appCtx.Log.Error("error while getting the passkeys of", appCtx.Session.User.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't fetch the passkeys"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(appCtx, w, response.Message{Message: "fetched the list", Data: creds})
+}
+
+//WebAuthnDeleteCredential deletes the passkey of the logged in user with the id posted
+func WebAuthnDeleteCredential(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	id, err := strconv.ParseUint(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " id"}, http.StatusBadRequest)
+		return
+	}
+	m := &config.WebAuthnCredential{UserID: appCtx.Session.User.ID}
+	m.ID = uint(id)
+	err = m.Delete(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while deleting the passkey", id, "of", m.UserID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't delete the passkey"}, http.StatusInternalServerError)
+		return
+	}
+	appCtx.Log.Info("deleted the passkey", id, "of", m.UserID)
+	response.Write(appCtx, w, "Successfully deleted the passkey")
+}
+
+//WebAuthnLoginBegin returns the options for logging in with a passkey. If the session is already authenticated
+//the passkey is asked as the second factor. Else any discoverable passkey is allowed
+func WebAuthnLoginBegin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will find the user the login is for. Logins without a session use the discoverable passkeys so that
+	 * the response doesn't tell whether an email is registered
+	 * We will get the passkeys of the user
+	 * Then we will start the login
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//finding the user
+	var userID uint
+	firstFactor := !appCtx.Session.Authenticated
+	if !firstFactor {
+		userID = appCtx.Session.User.ID
+	}
+
+	//getting the passkeys
+	var allow [][]byte
+	if userID != 0 {
+		_, creds, err := webAuthnCredentials(appCtx, userID)
+		if err != nil {
+			appCtx.Log.Error("error while getting the passkeys of", userID, err.Error())
+			response.WriteError(appCtx, w, response.Error{Err: "Couldn't start the passkey login"}, http.StatusInternalServerError)
+			return
+		}
+		allow = credentialIDs(creds)
+	}
+
+	//starting the login
+	o, err := webauthn.RelyingParty.BeginLogin(userID, allow, firstFactor)
+	if err != nil {
+		appCtx.Log.Error("error while starting the passkey login", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't start the passkey login"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(appCtx, w, o)
+}
+
+//WebAuthnLoginFinish verifies the assertion posted. If the session is already authenticated, the session is marked
+//as verified with the second factor and activated if it was waiting for the second factor. Else the session of the user who owns the passkey is started
+func WebAuthnLoginFinish(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	/*
+	 * We will verify the assertion against the stored passkey
+	 * We will update the sign count of the passkey
+	 * If the session is authenticated, we will mark the second factor and activate the session if it was pending
+	 * Else we will start the user session
+	 */
+	appCtx := ctx.Value(routes.AppContextKey).(*config.AppContext)
+	if r.Method != http.MethodPost {
+		response.WriteError(appCtx, w, response.Error{Err: "Only POST is supported"}, http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebAuthnBody))
+	if err != nil {
+		response.WriteError(appCtx, w, response.Error{Err: response.ErrorCodes[response.ErrorCodeInvalidParams] + " body"}, http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	//verifying the assertion
+	var m *config.WebAuthnCredential
+	cred, err := webauthn.RelyingParty.FinishLogin(body, func(id []byte) *webauthn.Credential {
+		m = config.GetWebAuthnCredential(*appCtx, base64.RawURLEncoding.EncodeToString(id))
+		if m == nil {
+			return nil
+		}
+		return &webauthn.Credential{ID: id, PublicKey: m.PublicKey, SignCount: m.SignCount, UserID: m.UserID}
+	})
+	if err == webauthn.ErrSignCount {
+		appCtx.Log.Error("sign count of the passkey", m.ID, "of", m.UserID, "didn't increase. It may have been cloned")
+	}
+	if err != nil {
+		appCtx.Log.Warn("invalid passkey login", err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't verify the passkey"}, http.StatusUnauthorized)
+		return
+	}
+
+	//updating the sign count
+	m.SignCount = cred.SignCount
+	err = m.UpdateSignCount(*appCtx)
+	if err != nil {
+		appCtx.Log.Error("error while updating the sign count of the passkey", m.ID, err.Error())
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't complete the passkey login"}, http.StatusInternalServerError)
+		return
+	}
+
+	//marking the second factor
+	if appCtx.Session.Authenticated {
+		if appCtx.Session.User.ID != cred.UserID {
+			appCtx.Log.Warn("user", appCtx.Session.User.ID, "tried to verify with the passkey of", cred.UserID)
+			response.WriteError(appCtx, w, response.Error{Err: "Couldn't verify the passkey"}, http.StatusForbidden)
+			return
+		}
+		appCtx.Session.SecondFactor = oauth.WEBAUTHN
+		if appCtx.Session.Pending == config.SessionMFARequired {
+			activateSession(appCtx, w, "")
+			return
+		}
+		go routes.SendRequest(routes.AppContextRequestChan, routes.AppContextRequest{
+			Session: appCtx.Session,
+			Type:    routes.SetSession,
+		})
+		response.Write(appCtx, w, appCtx.Session)
+		return
+	}
+
+	//starting the user session
+	info := config.GetUserInfoByID(*appCtx, cred.UserID)
+	if info == nil {
+		appCtx.Log.Error("couldn't find the user", cred.UserID, "of the passkey", m.ID)
+		response.WriteError(appCtx, w, response.Error{Err: "Couldn't complete the passkey login"}, http.StatusForbidden)
+		return
+	}
+	appCtx.Session.User = &config.User{AuthAgent: oauth.WEBAUTHN, Email: info.Email}
+	startSession(appCtx, w, info, "")
+}
+
+//webAuthnCredentials returns the user info and the passkeys of the user
+func webAuthnCredentials(appCtx *config.AppContext, userID uint) (*config.UserInfo, []config.WebAuthnCredential, error) {
+	info := config.GetUserInfoByID(*appCtx, userID)
+	if info == nil {
+		return nil, nil, errors.New("User not found")
+	}
+	creds, err := info.GetWebAuthnCredentials(*appCtx)
+	return info, creds, err
+}
+
+//credentialIDs returns the decoded credential ids of the passkeys
+func credentialIDs(creds []config.WebAuthnCredential) [][]byte {
+	ids := [][]byte{}
+	for _, c := range creds {
+		if id, err := base64.RawURLEncoding.DecodeString(c.CredentialID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func init() {
+	if webauthn.RelyingParty == nil {
+		return
+	}
+	routes.AddRoutes(
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/webauthn/register/begin",
+			HandlerFunc:   WebAuthnRegisterBegin,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/webauthn/register/finish",
+			HandlerFunc:   WebAuthnRegisterFinish,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/webauthn/credentials",
+			HandlerFunc:   WebAuthnListCredentials,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:       "v1",
+			Pattern:       "/auth/webauthn/credentials/delete",
+			HandlerFunc:   WebAuthnDeleteCredential,
+			ParseForm:     true,
+			Authenticated: true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/webauthn/login/begin",
+			HandlerFunc: WebAuthnLoginBegin,
+			ParseForm:   true,
+		},
+		routes.Route{
+			Version:     "v1",
+			Pattern:     "/auth/webauthn/login/finish",
+			HandlerFunc: WebAuthnLoginFinish,
+		},
+	)
+}
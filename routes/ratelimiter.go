@@ -30,6 +30,10 @@ const (
 	CleanUp RequestType = 2
 	//SetSession sets the user session info
 	SetSession RequestType = 3
+	//DeleteUserSessions deletes the sessions of the user of the given session and returns them
+	DeleteUserSessions RequestType = 4
+	//DeleteSession deletes the session with the id of the given session
+	DeleteSession RequestType = 5
 )
 
 //AppContextRequest is the request to get, return or try clean up app contexts
@@ -44,6 +48,8 @@ type AppContextRequest struct {
 	Exhausted bool
 	//Session is  the user session
 	Session config.Session
+	//Sessions are the sessions deleted by the delete user sessions requests
+	Sessions []config.Session
 }
 
 //AppContextRequestChan is the common channel through which the requests for app context come
@@ -107,6 +113,19 @@ func AppContext(in chan AppContextRequest) {
 		case SetSession:
 			//we will set the user session in given from
 			userSession[req.Session.ID] = req.Session
+		case DeleteUserSessions:
+			//we will delete the sessions of the user and return them
+			req.Sessions = []config.Session{}
+			for k, v := range userSession {
+				if v.User != nil && req.Session.User != nil && v.User.ID == req.Session.User.ID {
+					req.Sessions = append(req.Sessions, v)
+					delete(userSession, k)
+				}
+			}
+			go SendRequest(req.Out, req)
+		case DeleteSession:
+			//we will delete the session
+			delete(userSession, req.Session.ID)
 		case Finished:
 			//we will return the rewwuest ids
 			delete(usedMaps, req.AppContext.Log.GetID())
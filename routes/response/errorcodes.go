@@ -16,6 +16,10 @@ const (
 	ErrorCodeSessionExpired = 1
 	//ErrorCodeInvalidParams denotes that the api parameters are invalid
 	ErrorCodeInvalidParams = 2
+	//ErrorCodeInvalidState denotes that the oauth state of the login is not issued to the session
+	ErrorCodeInvalidState = 3
+	//ErrorCodeStateExpired denotes that the oauth login wasn't completed in time
+	ErrorCodeStateExpired = 4
 )
 
 //ErrorCodes has the map of error code mapped to the error messages
@@ -23,4 +27,6 @@ var ErrorCodes map[int]string = map[int]string{
 	ErrorCodeNone:           "No Error",
 	ErrorCodeSessionExpired: "Session has been expired",
 	ErrorCodeInvalidParams:  "The following parameters are invalid",
+	ErrorCodeInvalidState:   "Login request is invalid. Please start the login again",
+	ErrorCodeStateExpired:   "Login request has expired. Please start the login again",
 }
@@ -122,7 +122,7 @@ func (r Route) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if r.Authenticated && !resCtx.AppContext.Session.Authenticated {
+	if r.Authenticated && !resCtx.AppContext.Session.IsAuthenticated() {
 		response.WriteError(resCtx.AppContext, res, response.Error{Err: "You have to be logged in to access this API."}, http.StatusForbidden)
 		_, cancel := context.WithCancel(ctx)
 		cancel()
@@ -0,0 +1,211 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package saml has the implementation of the saml 2.0 service provider.
+//Identity providers like ADFS or Shibboleth can be configured as named identity providers
+package saml
+
+/*
+ * This file contains the configuration of the service provider and the identity providers
+ */
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/claimmap"
+	"github.com/cuttle-ai/auth-service/oauth"
+)
+
+const (
+	//IdentityProvidersKey is the key storing the comma separated names of the saml identity providers
+	IdentityProvidersKey = "SAML_IDPS"
+	//BaseURLKey is the key storing the public base url of the auth service. ACS and metadata urls are built from it
+	BaseURLKey = "SAML_SP_BASE_URL"
+	//EntityIDKey is the key storing the entity id of the service provider. Default is the metadata url
+	EntityIDKey = "SAML_SP_ENTITY_ID"
+	//TimeoutKey is the key storing the timeout for fetching the identity provider metadata in milliseconds
+	TimeoutKey = "SAML_TIMEOUT"
+)
+
+//Suffixes of the keys storing the config of an identity provider. The key of a config is
+//SAML_<IDP NAME>_<SUFFIX>. Eg. SAML_ADFS_METADATA_URL
+const (
+	//MetadataURLSuffix is the suffix of the key storing the metadata url of the identity provider
+	MetadataURLSuffix = "METADATA_URL"
+	//MetadataFileSuffix is the suffix of the key storing the metadata file path of the identity provider
+	MetadataFileSuffix = "METADATA_FILE"
+	//LabelSuffix is the suffix of the key storing the display label of the identity provider
+	LabelSuffix = "LABEL"
+	//AttributeEmailSuffix is the suffix of the key storing the email attribute name
+	AttributeEmailSuffix = "ATTRIBUTE_EMAIL"
+	//AttributeNameSuffix is the suffix of the key storing the name attribute name
+	AttributeNameSuffix = "ATTRIBUTE_NAME"
+	//AttributePictureSuffix is the suffix of the key storing the picture attribute name
+	AttributePictureSuffix = "ATTRIBUTE_PICTURE"
+	//AttributeMapSuffix is the suffix of the key storing the attribute mapping. It overrides the attribute names
+	AttributeMapSuffix = "ATTRIBUTE_MAP"
+	//EmailVerifiedSuffix is the suffix of the key telling whether the emails asserted by the identity provider are verified
+	EmailVerifiedSuffix = "EMAIL_VERIFIED"
+)
+
+//Timeout is the timeout for fetching the metadata of the identity providers
+var Timeout = time.Duration(10000 * time.Millisecond)
+
+//ClockSkew is the allowed clock skew while validating the time conditions of the assertions
+var ClockSkew = time.Duration(time.Minute)
+
+//BaseURL is the public base url of the auth service
+var BaseURL string
+
+//EntityID is the entity id of the service provider
+var EntityID string
+
+//IdentityProviders has the configured saml identity providers mapped to their name
+var IdentityProviders = map[string]*IdentityProvider{}
+
+//IdentityProvider is a saml identity provider
+type IdentityProvider struct {
+	//Name of the identity provider
+	Name string
+	//Label is the display label of the identity provider
+	Label string
+	//MetadataURL is the url from which the metadata of the identity provider is imported
+	MetadataURL string
+	//MetadataFile is the file from which the metadata of the identity provider is imported
+	MetadataFile string
+	//AttributeMap maps the assertion attributes to the user info
+	AttributeMap oauth.UserInfoMap
+	//EmailVerified trusts the emails asserted by the identity provider as verified
+	EmailVerified bool
+	//metadata is the cached metadata of the identity provider
+	metadata *Metadata
+	//lock for the metadata
+	lock sync.Mutex
+}
+
+//AgentName returns the auth agent name of the identity provider
+func (i *IdentityProvider) AgentName() string {
+	return oauth.SAML + ":" + i.Name
+}
+
+//Path returns the path prefix of the routes of the identity provider
+func (i *IdentityProvider) Path() string {
+	return "/auth/saml/" + strings.ToLower(i.Name)
+}
+
+//ACSURL returns the assertion consumer service url of the identity provider
+func (i *IdentityProvider) ACSURL() string {
+	return BaseURL + i.Path() + "/acs"
+}
+
+//LoginURL returns the url at which the login with the identity provider can be started. returnTo is the
+//allowed url to which the user is redirected after the login
+func (i *IdentityProvider) LoginURL(returnTo string) string {
+	if len(returnTo) != 0 {
+		return BaseURL + i.Path() + "/login?return_to=" + url.QueryEscape(returnTo)
+	}
+	return BaseURL + i.Path() + "/login"
+}
+
+//LoadConfig will load the config of the service provider and the identity providers
+func LoadConfig() error {
+	/*
+	 * We will set the timeout
+	 * We will get the list of identity provider names
+	 * If there are identity providers, we will load the service provider config
+	 * Then we will load each identity provider's config
+	 */
+	//setting the timeout
+	if t, err := strconv.ParseInt(os.Getenv(TimeoutKey), 10, 64); err == nil {
+		Timeout = time.Duration(t * int64(time.Millisecond))
+	}
+
+	//getting the list of identity providers
+	names := []string{}
+	for _, name := range strings.Split(os.Getenv(IdentityProvidersKey), ",") {
+		if name = strings.TrimSpace(name); len(name) != 0 {
+			names = append(names, strings.ToUpper(name))
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	//loading the service provider config
+	BaseURL = strings.TrimSuffix(os.Getenv(BaseURLKey), "/")
+	if len(BaseURL) == 0 {
+		return errors.New("SAML service provider base url not found")
+	}
+	EntityID = os.Getenv(EntityIDKey)
+	if len(EntityID) == 0 {
+		EntityID = BaseURL + "/auth/saml/metadata"
+	}
+
+	//loading the identity providers
+	idps := map[string]*IdentityProvider{}
+	for _, name := range names {
+		env := func(suffix string) string {
+			return os.Getenv("SAML_" + name + "_" + suffix)
+		}
+		envOr := func(suffix string, def string) string {
+			if v := env(suffix); len(v) != 0 {
+				return v
+			}
+			return def
+		}
+		idp := &IdentityProvider{
+			Name:          name,
+			Label:         envOr(LabelSuffix, name),
+			MetadataURL:   env(MetadataURLSuffix),
+			MetadataFile:  env(MetadataFileSuffix),
+			EmailVerified: env(EmailVerifiedSuffix) == "true",
+			AttributeMap: oauth.UserInfoMap{
+				Email:   envOr(AttributeEmailSuffix, "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"),
+				Name:    envOr(AttributeNameSuffix, "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name"),
+				Picture: env(AttributePictureSuffix),
+			},
+		}
+		if spec := env(AttributeMapSuffix); len(spec) != 0 {
+			attrs, err := claimmap.Parse(spec)
+			if err != nil {
+				return errors.New("SAML attribute mapping is invalid for " + name + ". " + err.Error())
+			}
+			idp.AttributeMap.Claims = attrs
+		}
+		if len(idp.MetadataURL) == 0 && len(idp.MetadataFile) == 0 {
+			return errors.New("SAML metadata url or file not found for " + name)
+		}
+		idps[name] = idp
+	}
+
+	IdentityProviders = idps
+	return nil
+}
+
+func init() {
+	/*
+	 * We will load the config
+	 * Then we will register the identity providers in the order of their names
+	 */
+	err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	names := []string{}
+	for name := range IdentityProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		oauth.Register(IdentityProviders[name].Provider())
+	}
+}
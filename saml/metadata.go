@@ -0,0 +1,214 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package saml
+
+/*
+ * This file contains the metadata import of the identity providers and the service provider metadata
+ */
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	//RedirectBinding is the http redirect binding of saml
+	RedirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	//PostBinding is the http post binding of saml
+	PostBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	//EmailNameIDFormat is the email address name id format
+	EmailNameIDFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+)
+
+//Metadata is the imported metadata of an identity provider
+type Metadata struct {
+	//EntityID of the identity provider
+	EntityID string
+	//SSOURL is the single sign on url of the identity provider supporting the redirect binding
+	SSOURL string
+	//Certificates are the signing certificates of the identity provider
+	Certificates []*x509.Certificate
+}
+
+//entityDescriptor is the saml metadata entity descriptor
+type entityDescriptor struct {
+	EntityID         string `xml:"entityID,attr"`
+	IDPSSODescriptor struct {
+		KeyDescriptors []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SingleSignOnServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+//entitiesDescriptor is the saml metadata entities descriptor wrapping many entities
+type entitiesDescriptor struct {
+	XMLName           xml.Name
+	EntityDescriptors []entityDescriptor `xml:"EntityDescriptor"`
+}
+
+//Metadata returns the metadata of the identity provider. The metadata is imported once and cached
+func (i *IdentityProvider) Metadata(ctx context.Context) (*Metadata, error) {
+	/*
+	 * If we have the metadata cached, we will return it
+	 * We will read the metadata from the url or the file
+	 * Then we will parse it and cache it
+	 */
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.metadata != nil {
+		return i.metadata, nil
+	}
+
+	//reading the metadata
+	var b []byte
+	var err error
+	if len(i.MetadataURL) != 0 {
+		b, err = fetch(ctx, i.MetadataURL)
+	} else {
+		b, err = ioutil.ReadFile(i.MetadataFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//parsing the metadata
+	m, err := ParseMetadata(b)
+	if err != nil {
+		return nil, err
+	}
+	i.metadata = m
+	return m, nil
+}
+
+//ParseMetadata parses the metadata xml of an identity provider
+func ParseMetadata(b []byte) (*Metadata, error) {
+	/*
+	 * We will parse the metadata as an entity descriptor
+	 * If the metadata is an entities descriptor we will take its first identity provider
+	 * We will get the single sign on url supporting redirect binding
+	 * Then the signing certificates
+	 */
+	//parsing the metadata
+	ed := entityDescriptor{}
+	wrapper := entitiesDescriptor{}
+	err := xml.Unmarshal(b, &wrapper)
+	if err != nil {
+		return nil, err
+	}
+	if wrapper.XMLName.Local == "EntitiesDescriptor" {
+		found := false
+		for _, e := range wrapper.EntityDescriptors {
+			if len(e.IDPSSODescriptor.SingleSignOnServices) != 0 {
+				ed = e
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("No identity provider found in the metadata")
+		}
+	} else if err = xml.Unmarshal(b, &ed); err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{EntityID: ed.EntityID}
+	//getting the single sign on url
+	for _, s := range ed.IDPSSODescriptor.SingleSignOnServices {
+		if s.Binding == RedirectBinding {
+			m.SSOURL = s.Location
+		}
+	}
+	if len(m.SSOURL) == 0 {
+		return nil, errors.New("No single sign on service with redirect binding found in the metadata")
+	}
+
+	//getting the signing certificates
+	for _, k := range ed.IDPSSODescriptor.KeyDescriptors {
+		if len(k.Use) != 0 && k.Use != "signing" {
+			continue
+		}
+		for _, c := range k.Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(c), ""))
+			if err != nil {
+				return nil, err
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			m.Certificates = append(m.Certificates, cert)
+		}
+	}
+	if len(m.Certificates) == 0 {
+		return nil, errors.New("No signing certificate found in the metadata")
+	}
+	return m, nil
+}
+
+//fetch will do a get request to the given url and return the response body
+func fetch(ctx context.Context, u string) ([]byte, error) {
+	newCtx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(newCtx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(u + " responded with status " + res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+//spMetadataHeader is the header of the service provider metadata
+const spMetadataHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+  <md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:NameIDFormat>` + EmailNameIDFormat + `</md:NameIDFormat>
+`
+
+//spMetadataACS is an assertion consumer service in the service provider metadata
+const spMetadataACS = `    <md:AssertionConsumerService Binding="` + PostBinding + `" Location="%s" index="%s"/>
+`
+
+//spMetadataFooter is the footer of the service provider metadata
+const spMetadataFooter = `  </md:SPSSODescriptor>
+</md:EntityDescriptor>
+`
+
+//SPMetadata returns the service provider metadata to be imported by the identity providers.
+//It has the assertion consumer service of every identity provider
+func SPMetadata() []byte {
+	names := []string{}
+	for name := range IdentityProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := &bytes.Buffer{}
+	b.WriteString(sprintfXML(spMetadataHeader, EntityID))
+	for k, name := range names {
+		b.WriteString(sprintfXML(spMetadataACS, IdentityProviders[name].ACSURL(), strconv.Itoa(k)))
+	}
+	b.WriteString(spMetadataFooter)
+	return b.Bytes()
+}
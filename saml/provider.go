@@ -0,0 +1,52 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package saml
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+)
+
+/*
+ * This file contains the login providers of the identity providers
+ */
+
+//Provider returns the login provider of the identity provider. The callback is the assertion consumer service.
+//Identity providers post the response cross site without the session cookie, so the browser is asked to post it
+//again from the auth service. They can't be linked
+func (i *IdentityProvider) Provider() *oauth.Provider {
+	return &oauth.Provider{
+		Name:  i.AgentName(),
+		Label: i.Label,
+		LoginURL: func(ctx context.Context, appCtx *config.AppContext, returnTo string) (string, error) {
+			return i.LoginURL(returnTo), nil
+		},
+		CallbackPath: i.Path() + "/acs",
+		Callback:     i.callback,
+		Repost:       true,
+	}
+}
+
+//callback validates the saml response posted to the assertion consumer service for the login started by the
+//session and sets the user along with the url to return to
+func (i *IdentityProvider) callback(ctx context.Context, appCtx *config.AppContext, r *http.Request) (*config.UserInfo, error) {
+	st, err := oauth.ConsumeState(appCtx, i.AgentName(), r.PostFormValue("RelayState"))
+	if err != nil {
+		return nil, &oauth.CallbackError{Status: http.StatusForbidden, Message: err.Error(), Err: err}
+	}
+	info, err := i.UserInfo(ctx, RequestID(st), r.PostFormValue("SAMLResponse"))
+	if err != nil {
+		return nil, &oauth.CallbackError{Status: http.StatusForbidden, Message: "Sorry couldn't complete your login", Err: err}
+	}
+	appCtx.Session.User = &config.User{
+		AuthAgent: i.AgentName(),
+		Email:     info.Email,
+	}
+	appCtx.Session.ReturnTo = st.ReturnTo
+	return info, nil
+}
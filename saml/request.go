@@ -0,0 +1,91 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package saml
+
+/*
+ * This file contains the authentication request sent to the identity providers
+ */
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/oauth"
+)
+
+//authnRequestTemplate is the authentication request sent to the identity provider
+const authnRequestTemplate = `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ` +
+	`ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="` + PostBinding + `">` +
+	`<saml:Issuer>%s</saml:Issuer>` +
+	`<samlp:NameIDPolicy Format="` + EmailNameIDFormat + `" AllowCreate="true"/>` +
+	`</samlp:AuthnRequest>`
+
+//RequestID returns the id of the authentication request sent for the oauth state of the login. The nonce of the
+//state is used as the id so that the response is accepted only for the session which started the login
+func RequestID(st config.OAuthState) string {
+	return "id-" + st.Nonce
+}
+
+//AuthnRequestURL creates an authentication request and returns the
+//url of the identity provider to which the user has to be redirected. returnTo is the allowed url
+//to which the user is redirected after the login. The caller has to save the session
+func (i *IdentityProvider) AuthnRequestURL(ctx context.Context, appCtx *config.AppContext, returnTo string) (string, error) {
+	/*
+	 * We will get the metadata of the identity provider
+	 * We will store the login in the session with the state as the relay state
+	 * We will create the authentication request
+	 * Then we will encode the request for the redirect binding
+	 */
+	//getting the metadata
+	m, err := i.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	//storing the login in the session
+	relayState, st, err := oauth.NewState(appCtx, i.AgentName(), returnTo)
+	if err != nil {
+		return "", err
+	}
+
+	//creating the request
+	req := sprintfXML(authnRequestTemplate, RequestID(st), time.Now().UTC().Format(time.RFC3339), m.SSOURL, i.ACSURL(), EntityID)
+
+	//encoding the request for the redirect binding
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	w.Write([]byte(req))
+	w.Close()
+	sep := "?"
+	if strings.Contains(m.SSOURL, "?") {
+		sep = "&"
+	}
+	return m.SSOURL + sep + url.Values{
+		"SAMLRequest": {base64.StdEncoding.EncodeToString(buf.Bytes())},
+		"RelayState":  {relayState},
+	}.Encode(), nil
+}
+
+//sprintfXML formats the format string with the arguments escaped as xml
+func sprintfXML(format string, args ...string) string {
+	escaped := make([]interface{}, len(args))
+	for k, v := range args {
+		b := &bytes.Buffer{}
+		xml.EscapeText(b, []byte(v))
+		escaped[k] = b.String()
+	}
+	return fmt.Sprintf(format, escaped...)
+}
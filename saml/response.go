@@ -0,0 +1,292 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package saml
+
+/*
+ * This file contains the validation of the responses from the identity providers
+ */
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cuttle-ai/auth-service/config"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	//protocolNamespace is the saml protocol namespace
+	protocolNamespace = "urn:oasis:names:tc:SAML:2.0:protocol"
+	//assertionNamespace is the saml assertion namespace
+	assertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	//statusSuccess is the status code of a successful response
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	//bearerMethod is the bearer subject confirmation method
+	bearerMethod = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	//transientNameID is the format of the name ids which change on every login
+	transientNameID = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+)
+
+//assertion is the saml assertion. It is unmarshalled only from signature validated xml
+type assertion struct {
+	ID      string `xml:"ID,attr"`
+	Issuer  string `xml:"Issuer"`
+	Subject struct {
+		NameID struct {
+			Value  string `xml:",chardata"`
+			Format string `xml:"Format,attr"`
+		} `xml:"NameID"`
+		SubjectConfirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				InResponseTo string    `xml:"InResponseTo,attr"`
+				Recipient    string    `xml:"Recipient,attr"`
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions struct {
+		NotBefore    time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+		Audiences    []string  `xml:"AudienceRestriction>Audience"`
+	} `xml:"Conditions"`
+	Attributes []struct {
+		Name         string   `xml:"Name,attr"`
+		FriendlyName string   `xml:"FriendlyName,attr"`
+		Values       []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+}
+
+//attributes returns the first values of the attributes mapped to both their name and friendly name
+func (a assertion) attributes() map[string]interface{} {
+	result := map[string]interface{}{}
+	for _, attr := range a.Attributes {
+		if len(attr.Values) == 0 {
+			continue
+		}
+		for _, name := range []string{attr.FriendlyName, attr.Name} {
+			if _, ok := result[name]; len(name) != 0 && !ok {
+				result[name] = strings.TrimSpace(attr.Values[0])
+			}
+		}
+	}
+	return result
+}
+
+//usedAssertions stores the ids of the consumed assertions till they expire to prevent replay
+var usedAssertions = struct {
+	ids  map[string]time.Time
+	lock sync.Mutex
+}{ids: map[string]time.Time{}}
+
+//UserInfo validates the base64 encoded saml response posted to the assertion consumer service of the
+//identity provider for the authentication request of the given id and returns the user info from the assertion
+func (i *IdentityProvider) UserInfo(ctx context.Context, requestID string, samlResponse string) (*config.UserInfo, error) {
+	/*
+	 * We will get the metadata of the identity provider
+	 * We will decode the response and verify its status
+	 * Then we will get the signature validated assertion
+	 * Then we will validate the assertion
+	 * Then we will map the attributes to the user info
+	 */
+	//getting the metadata
+	m, err := i.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//decoding the response
+	b, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, err
+	}
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	res := doc.Root()
+	if res == nil || res.Tag != "Response" || res.NamespaceURI() != protocolNamespace {
+		return nil, errors.New("SAML response is malformed")
+	}
+	if len(requestID) == 0 || res.SelectAttrValue("InResponseTo", "") != requestID {
+		return nil, errors.New("SAML response is not for the pending authentication request")
+	}
+	status := res.FindElement("./Status/StatusCode")
+	if status == nil || status.SelectAttrValue("Value", "") != statusSuccess {
+		return nil, errors.New("SAML response status is not success")
+	}
+
+	//getting the signature validated assertion
+	el, err := validatedAssertion(res, m)
+	if err != nil {
+		return nil, err
+	}
+	a := assertion{}
+	vDoc := etree.NewDocument()
+	vDoc.SetRoot(el.Copy())
+	vb, err := vDoc.WriteToBytes()
+	if err != nil {
+		return nil, err
+	}
+	err = xml.Unmarshal(vb, &a)
+	if err != nil {
+		return nil, err
+	}
+
+	//validating the assertion
+	err = i.validate(a, m, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	//mapping the attributes to the user info
+	info, err := i.AttributeMap.UserInfo(a.attributes())
+	if err != nil {
+		return nil, err
+	}
+	nameID := strings.TrimSpace(a.Subject.NameID.Value)
+	if len(info.Email) == 0 && strings.Contains(nameID, "@") {
+		info.Email = nameID
+	}
+	if len(info.Email) == 0 {
+		return nil, errors.New("Email not found in the SAML assertion")
+	}
+	if i.EmailVerified {
+		info.EmailVerified = true
+	}
+	//transient name ids can't identify the user across the logins, so the email given by the idp is used instead
+	info.Subject = nameID
+	if a.Subject.NameID.Format == transientNameID || len(nameID) == 0 {
+		info.Subject = info.Email
+	}
+	if len(info.Name) == 0 {
+		info.Name = info.Email
+	}
+	return info, nil
+}
+
+//validatedAssertion returns the assertion element of the response after validating its signature.
+//Either the response or the assertion has to be signed. Only the elements covered by the signature are returned
+func validatedAssertion(res *etree.Element, m *Metadata) (*etree.Element, error) {
+	/*
+	 * We will prepare the validation context with the identity provider certificates
+	 * If the response is signed, we will validate the response and take the assertion from the validated response
+	 * Else we will validate the signed assertion
+	 */
+	vCtx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: m.Certificates})
+
+	//validating the signed response
+	if res.FindElement("./Signature") != nil {
+		vRes, err := vCtx.Validate(res)
+		if err != nil {
+			return nil, err
+		}
+		assertions := assertionsOf(vRes)
+		if len(assertions) != 1 {
+			return nil, errors.New("SAML response must have exactly one assertion")
+		}
+		if assertions[0].FindElement("./Signature") == nil {
+			return assertions[0], nil
+		}
+		return vCtx.Validate(assertions[0])
+	}
+
+	//validating the signed assertion
+	assertions := assertionsOf(res)
+	if len(assertions) != 1 {
+		return nil, errors.New("SAML response must have exactly one assertion")
+	}
+	return vCtx.Validate(assertions[0])
+}
+
+//assertionsOf returns the assertion elements which are the direct children of the response
+func assertionsOf(res *etree.Element) []*etree.Element {
+	assertions := []*etree.Element{}
+	for _, el := range res.ChildElements() {
+		if el.Tag == "EncryptedAssertion" {
+			//encrypted assertions are not supported. We will return no assertion
+			return nil
+		}
+		if el.Tag == "Assertion" && el.NamespaceURI() == assertionNamespace {
+			assertions = append(assertions, el)
+		}
+	}
+	return assertions
+}
+
+//validate will validate the issuer, conditions and subject confirmation of the assertion
+func (i *IdentityProvider) validate(a assertion, m *Metadata, requestID string) error {
+	/*
+	 * We will validate the issuer
+	 * Then the time conditions
+	 * Then the audience
+	 * Then the bearer subject confirmation
+	 * Then we will make sure the assertion is not replayed
+	 */
+	now := time.Now()
+	//validating the issuer
+	if strings.TrimSpace(a.Issuer) != m.EntityID {
+		return errors.New("SAML assertion issuer " + a.Issuer + " doesn't match " + m.EntityID)
+	}
+
+	//validating the time conditions
+	if !a.Conditions.NotBefore.IsZero() && now.Add(ClockSkew).Before(a.Conditions.NotBefore) {
+		return errors.New("SAML assertion is not valid yet")
+	}
+	if !a.Conditions.NotOnOrAfter.IsZero() && !now.Add(-ClockSkew).Before(a.Conditions.NotOnOrAfter) {
+		return errors.New("SAML assertion has expired")
+	}
+
+	//validating the audience
+	audience := false
+	for _, aud := range a.Conditions.Audiences {
+		if strings.TrimSpace(aud) == EntityID {
+			audience = true
+			break
+		}
+	}
+	if !audience {
+		return errors.New("SAML assertion is not intended for the service provider")
+	}
+
+	//validating the subject confirmation
+	confirmed := false
+	expires := a.Conditions.NotOnOrAfter
+	for _, sc := range a.Subject.SubjectConfirmations {
+		if sc.Method != bearerMethod || sc.Data.Recipient != i.ACSURL() || sc.Data.InResponseTo != requestID {
+			continue
+		}
+		if !now.Add(-ClockSkew).Before(sc.Data.NotOnOrAfter) {
+			continue
+		}
+		confirmed = true
+		expires = sc.Data.NotOnOrAfter
+		break
+	}
+	if !confirmed {
+		return errors.New("SAML assertion subject couldn't be confirmed")
+	}
+
+	//making sure the assertion is not replayed
+	usedAssertions.lock.Lock()
+	defer usedAssertions.lock.Unlock()
+	for k, v := range usedAssertions.ids {
+		if v.Before(now) {
+			delete(usedAssertions.ids, k)
+		}
+	}
+	if _, ok := usedAssertions.ids[a.ID]; ok {
+		return errors.New("SAML assertion has already been used")
+	}
+	usedAssertions.ids[a.ID] = expires.Add(ClockSkew)
+	return nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package saml
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/auth-service/log"
+	"github.com/cuttle-ai/auth-service/oauth"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+/*
+ * This file contains the tests of the validation of the responses from the identity providers. The responses are
+ * signed with a key generated for the tests. The config is loaded with SKIP_VAULT=true and a DISCOVERY_TOKEN
+ */
+
+//idpEntityID is the entity id of the identity provider of the tests
+const idpEntityID = "https://idp.example.com"
+
+//assertionTemplate is the assertion issued by the identity provider of the tests
+const assertionTemplate = `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="%s" Version="2.0" IssueInstant="%s">` +
+	`<saml:Issuer>%s</saml:Issuer>` +
+	`<saml:Subject>` +
+	`<saml:NameID Format="` + EmailNameIDFormat + `">%s</saml:NameID>` +
+	`<saml:SubjectConfirmation Method="` + bearerMethod + `">` +
+	`<saml:SubjectConfirmationData InResponseTo="%s" Recipient="%s" NotOnOrAfter="%s"/>` +
+	`</saml:SubjectConfirmation>` +
+	`</saml:Subject>` +
+	`<saml:Conditions NotBefore="%s" NotOnOrAfter="%s">` +
+	`<saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction>` +
+	`</saml:Conditions>` +
+	`<saml:AttributeStatement>` +
+	`<saml:Attribute Name="email"><saml:AttributeValue>%s</saml:AttributeValue></saml:Attribute>` +
+	`</saml:AttributeStatement>` +
+	`</saml:Assertion>`
+
+//testIdP is the identity provider of the tests along with the key signing its assertions
+type testIdP struct {
+	*IdentityProvider
+	keys dsig.X509KeyStore
+}
+
+//assertionParams are the params of an assertion issued by the identity provider of the tests
+type assertionParams struct {
+	id           string
+	inResponseTo string
+	recipient    string
+	audience     string
+	email        string
+	notOnOrAfter time.Time
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	BaseURL = "https://auth.example.com"
+	EntityID = BaseURL + "/auth/saml/metadata"
+	keys := dsig.RandomKeyStoreForTest()
+	_, der, err := keys.GetKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIdP{
+		IdentityProvider: &IdentityProvider{
+			Name:          "TEST",
+			Label:         "Test",
+			AttributeMap:  oauth.UserInfoMap{Email: "email", Name: "name", Picture: "picture"},
+			EmailVerified: true,
+			metadata: &Metadata{
+				EntityID:     idpEntityID,
+				SSOURL:       idpEntityID + "/sso",
+				Certificates: []*x509.Certificate{cert},
+			},
+		},
+		keys: keys,
+	}
+}
+
+//newID returns a random id of an assertion or a response
+func newID(t *testing.T) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return "_" + hex.EncodeToString(b)
+}
+
+//params returns the params of a valid assertion for the request
+func (i *testIdP) params(t *testing.T, requestID string) assertionParams {
+	return assertionParams{
+		id:           newID(t),
+		inResponseTo: requestID,
+		recipient:    i.ACSURL(),
+		audience:     EntityID,
+		email:        "jdoe@example.com",
+		notOnOrAfter: time.Now().Add(5 * time.Minute),
+	}
+}
+
+//assertion returns the assertion element of the params. It is signed if sign is true
+func (i *testIdP) assertion(t *testing.T, p assertionParams, sign bool) *etree.Element {
+	now := time.Now().UTC()
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(fmt.Sprintf(assertionTemplate, p.id, now.Format(time.RFC3339), idpEntityID, p.email,
+		p.inResponseTo, p.recipient, p.notOnOrAfter.UTC().Format(time.RFC3339),
+		now.Add(-time.Minute).Format(time.RFC3339), p.notOnOrAfter.UTC().Format(time.RFC3339), p.audience, p.email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := doc.Root()
+	if !sign {
+		return el
+	}
+	signed, err := dsig.NewDefaultSigningContext(i.keys).SignEnveloped(el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+//response returns the base64 encoded response to the request having the elements
+func response(t *testing.T, requestID string, elements ...*etree.Element) string {
+	doc := etree.NewDocument()
+	res := doc.CreateElement("samlp:Response")
+	res.CreateAttr("xmlns:samlp", protocolNamespace)
+	res.CreateAttr("ID", newID(t))
+	res.CreateAttr("Version", "2.0")
+	res.CreateAttr("InResponseTo", requestID)
+	res.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", statusSuccess)
+	for _, el := range elements {
+		res.AddChild(el)
+	}
+	s, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestUserInfo(t *testing.T) {
+	i := newTestIdP(t)
+	requestID := "id-" + newID(t)
+	info, err := i.UserInfo(context.Background(), requestID, response(t, requestID, i.assertion(t, i.params(t, requestID), true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Email != "jdoe@example.com" || !info.EmailVerified {
+		t.Errorf("unexpected user info %+v", info)
+	}
+}
+
+func TestUserInfoRejected(t *testing.T) {
+	i := newTestIdP(t)
+	requestID := "id-" + newID(t)
+
+	cases := []struct {
+		name     string
+		response func() string
+	}{
+		{"unsigned assertion", func() string {
+			return response(t, requestID, i.assertion(t, i.params(t, requestID), false))
+		}},
+		{"injected unsigned assertion", func() string {
+			evil := i.params(t, requestID)
+			evil.email = "attacker@example.com"
+			return response(t, requestID, i.assertion(t, i.params(t, requestID), true), i.assertion(t, evil, false))
+		}},
+		{"signed assertion wrapped away from an injected one", func() string {
+			evil := i.params(t, requestID)
+			evil.email = "attacker@example.com"
+			wrapper := etree.NewElement("samlp:Extensions")
+			wrapper.AddChild(i.assertion(t, i.params(t, requestID), true))
+			return response(t, requestID, wrapper, i.assertion(t, evil, false))
+		}},
+		{"tampered signed assertion", func() string {
+			el := i.assertion(t, i.params(t, requestID), true)
+			el.FindElement("./AttributeStatement/Attribute/AttributeValue").SetText("attacker@example.com")
+			return response(t, requestID, el)
+		}},
+		{"assertion signed by another key", func() string {
+			other := newTestIdP(t)
+			return response(t, requestID, other.assertion(t, i.params(t, requestID), true))
+		}},
+		{"wrong audience", func() string {
+			p := i.params(t, requestID)
+			p.audience = "https://other.example.com/metadata"
+			return response(t, requestID, i.assertion(t, p, true))
+		}},
+		{"wrong recipient", func() string {
+			p := i.params(t, requestID)
+			p.recipient = "https://other.example.com/acs"
+			return response(t, requestID, i.assertion(t, p, true))
+		}},
+		{"response to another request", func() string {
+			other := "id-" + newID(t)
+			return response(t, other, i.assertion(t, i.params(t, other), true))
+		}},
+		{"assertion for another request", func() string {
+			return response(t, requestID, i.assertion(t, i.params(t, "id-"+newID(t)), true))
+		}},
+		{"expired assertion", func() string {
+			p := i.params(t, requestID)
+			p.notOnOrAfter = time.Now().Add(-10 * time.Minute)
+			return response(t, requestID, i.assertion(t, p, true))
+		}},
+	}
+	for _, c := range cases {
+		if info, err := i.UserInfo(context.Background(), requestID, c.response()); err == nil {
+			t.Errorf("%s was accepted for %s", c.name, info.Email)
+		}
+	}
+}
+
+func TestUserInfoReplay(t *testing.T) {
+	i := newTestIdP(t)
+	requestID := "id-" + newID(t)
+	res := response(t, requestID, i.assertion(t, i.params(t, requestID), true))
+	if _, err := i.UserInfo(context.Background(), requestID, res); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.UserInfo(context.Background(), requestID, res); err == nil {
+		t.Error("replayed assertion was accepted")
+	}
+}
+
+//postResponse returns the request posting the response to the assertion consumer service
+func postResponse(relayState string, samlResponse string) *http.Request {
+	form := url.Values{"RelayState": {relayState}, "SAMLResponse": {samlResponse}}
+	r := httptest.NewRequest(http.MethodPost, "/acs", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestCallbackRelayState(t *testing.T) {
+	i := newTestIdP(t)
+	ctx := context.Background()
+
+	//starting the login from the session of the user
+	user := &config.AppContext{Log: log.NewLogger(0)}
+	u, err := i.AuthnRequestURL(ctx, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginURL, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayState := loginURL.Query().Get("RelayState")
+	requestID := RequestID(user.Session.OAuthStates[relayState])
+	res := response(t, requestID, i.assertion(t, i.params(t, requestID), true))
+
+	//the response can't complete the login of another session
+	other := &config.AppContext{Log: log.NewLogger(0)}
+	_, err = i.callback(ctx, other, postResponse(relayState, res))
+	if cErr, ok := err.(*oauth.CallbackError); !ok || cErr.Status != http.StatusForbidden {
+		t.Fatalf("relay state of another session gave %v, expected a forbidden callback error", err)
+	}
+
+	//the session which started the login completes it once
+	info, err := i.callback(ctx, user, postResponse(relayState, res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Email != "jdoe@example.com" || user.Session.User == nil || user.Session.User.AuthAgent != i.AgentName() {
+		t.Errorf("unexpected login of %+v", info)
+	}
+	if _, err := i.callback(ctx, user, postResponse(relayState, res)); err == nil {
+		t.Error("relay state was accepted again")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package secretbox encrypts the secrets stored in the database with AES-256-GCM.
+//A box has a list of keys. The secrets are sealed with the first key and opened with any of them
+//so that the keys can be rotated by adding a new key in front of the old ones
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+/*
+ * This file contains the encryption of the secrets
+ */
+
+//KeyLength is the length of the keys in bytes
+const KeyLength = 32
+
+//ErrOpen is returned when the secret couldn't be opened with any of the keys
+var ErrOpen = errors.New("Couldn't open the secret with any of the keys")
+
+//Box seals and opens the secrets
+type Box struct {
+	aeads []cipher.AEAD
+}
+
+//New returns a box with the given keys. The first key is used for sealing
+func New(keys ...[]byte) (*Box, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("No key given for the secret box")
+	}
+	b := &Box{}
+	for _, k := range keys {
+		if len(k) != KeyLength {
+			return nil, errors.New("Secret box keys have to be 32 bytes long")
+		}
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		b.aeads = append(b.aeads, aead)
+	}
+	return b, nil
+}
+
+//Parse returns a box with the comma separated list of base64 encoded keys
+func Parse(keys string) (*Box, error) {
+	ks := [][]byte{}
+	for _, k := range strings.Split(keys, ",") {
+		if k = strings.TrimSpace(k); len(k) == 0 {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, errors.New("Secret box key is not base64 encoded")
+		}
+		ks = append(ks, key)
+	}
+	return New(ks...)
+}
+
+//Seal encrypts the secret with the first key and returns the base64 encoded nonce and cipher text.
+//Empty secrets are sealed as empty strings
+func (b *Box) Seal(secret string) (string, error) {
+	if len(secret) == 0 {
+		return "", nil
+	}
+	aead := b.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+//Open decrypts the sealed secret with the keys of the box
+func (b *Box) Open(sealed string) (string, error) {
+	if len(sealed) == 0 {
+		return "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", ErrOpen
+	}
+	for _, aead := range b.aeads {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+		secret, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+		if err == nil {
+			return string(secret), nil
+		}
+	}
+	return "", ErrOpen
+}
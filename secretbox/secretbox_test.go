@@ -0,0 +1,102 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package secretbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+/*
+ * This file contains the tests of the secret box
+ */
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeyLength)
+}
+
+func TestSealOpen(t *testing.T) {
+	b, err := New(key(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := b.Seal("ya29.token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed == "ya29.token" {
+		t.Fatal("secret is not sealed")
+	}
+	other, _ := b.Seal("ya29.token")
+	if other == sealed {
+		t.Error("sealing twice gave the same cipher text")
+	}
+	secret, err := b.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "ya29.token" {
+		t.Errorf("expected ya29.token, got %s", secret)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	b, _ := New(key(1))
+	sealed, err := b.Seal("")
+	if err != nil || sealed != "" {
+		t.Errorf("expected empty secret to be sealed as empty, got %q %v", sealed, err)
+	}
+	secret, err := b.Open("")
+	if err != nil || secret != "" {
+		t.Errorf("expected empty secret to be opened as empty, got %q %v", secret, err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old, _ := New(key(1))
+	sealed, _ := old.Seal("refresh")
+	rotated, err := New(key(2), key(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := rotated.Open(sealed)
+	if err != nil || secret != "refresh" {
+		t.Errorf("expected the old secret to be opened after rotation, got %q %v", secret, err)
+	}
+	sealed, _ = rotated.Seal("refresh")
+	if _, err := old.Open(sealed); err != ErrOpen {
+		t.Errorf("expected the new secret not to be opened with the old key, got %v", err)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	b, _ := New(key(1))
+	sealed, _ := b.Seal("secret")
+	data, _ := base64.RawURLEncoding.DecodeString(sealed)
+	data[len(data)-1] ^= 1
+	if _, err := b.Open(base64.RawURLEncoding.EncodeToString(data)); err != ErrOpen {
+		t.Errorf("expected tampered secret to fail, got %v", err)
+	}
+	if _, err := b.Open("not base64!"); err != ErrOpen {
+		t.Errorf("expected invalid secret to fail, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	k := base64.StdEncoding.EncodeToString(key(3))
+	if _, err := Parse(k + ", " + base64.StdEncoding.EncodeToString(key(4))); err != nil {
+		t.Error(err)
+	}
+	if _, err := Parse(""); err == nil {
+		t.Error("expected no keys to fail")
+	}
+	if _, err := Parse(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("expected short key to fail")
+	}
+	if _, err := Parse("%%%"); err == nil {
+		t.Error("expected invalid base64 to fail")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package totp has the implementation of the time based one time passwords (RFC 6238) used as the second factor
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the generation and the validation of the totp codes
+ */
+
+const (
+	//Period is the number of seconds for which a code is valid
+	Period = 30
+	//Digits is the number of digits in a code
+	Digits = 6
+	//Skew is the number of periods before and after the current one for which the codes are accepted
+	Skew = 1
+	//SecretLength is the length of the generated secrets in bytes
+	SecretLength = 20
+)
+
+var (
+	//ErrInvalidCode is returned when the code doesn't match
+	ErrInvalidCode = errors.New("Invalid code")
+	//ErrReused is returned when the code was already used
+	ErrReused = errors.New("Code has already been used")
+)
+
+//encoding is the base32 encoding of the secrets
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//GenerateSecret returns a new random base32 encoded secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, SecretLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+//ProvisioningURI returns the otpauth uri of the secret. Authenticator apps enroll the secret by scanning its QR code
+func ProvisioningURI(issuer string, account string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+//Code returns the code of the secret at the given time
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, step(t)), nil
+}
+
+//Validate validates the code of the secret at the given time. Codes of the steps not after the last used step are
+//rejected so that a code can't be used twice. It returns the step of the code which has to be stored as the last used step
+func Validate(secret string, c string, t time.Time, lastStep int64) (int64, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+	c = strings.TrimSpace(c)
+	if len(c) != Digits {
+		return 0, ErrInvalidCode
+	}
+	current := step(t)
+	for s := current - Skew; s <= current+Skew; s++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, s)), []byte(c)) != 1 {
+			continue
+		}
+		if s <= lastStep {
+			return 0, ErrReused
+		}
+		return s, nil
+	}
+	return 0, ErrInvalidCode
+}
+
+//step returns the step of the given time
+func step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+//code returns the code of the key at the given step (RFC 4226)
+func code(key []byte, s int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(s))
+	m := hmac.New(sha1.New, key)
+	m.Write(msg)
+	sum := m.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, v%mod)
+}
+
+//decodeSecret decodes the base32 encoded secret ignoring the case, spaces and padding
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(strings.TrimRight(secret, "="), " ", "", -1))
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return nil, errors.New("Invalid totp secret")
+	}
+	return key, nil
+}
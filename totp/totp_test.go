@@ -0,0 +1,74 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * This file contains the tests of the totp codes
+ */
+
+//rfcSecret is the base32 encoding of the sha1 secret of the RFC 6238 test vectors
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	//RFC 6238 test vectors truncated to 6 digits
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		got, err := Code(rfcSecret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.code {
+			t.Errorf("%d: expected %s, got %s", c.unix, c.code, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	s, err := Validate(rfcSecret, "050471", now, 0)
+	if err != nil || s != 1111111111/Period {
+		t.Fatalf("expected the current code to be valid, got %d %v", s, err)
+	}
+	if _, err = Validate(rfcSecret, "050471", now, s); err != ErrReused {
+		t.Errorf("expected the used code to be rejected, got %v", err)
+	}
+	prev, _ := Code(rfcSecret, now.Add(-Period*time.Second))
+	if _, err = Validate(rfcSecret, prev, now, 0); err != nil {
+		t.Errorf("expected the code of the previous step to be valid, got %v", err)
+	}
+	old, _ := Code(rfcSecret, now.Add(-2*Period*time.Second))
+	if _, err = Validate(rfcSecret, old, now, 0); err != ErrInvalidCode {
+		t.Errorf("expected the old code to be rejected, got %v", err)
+	}
+	if _, err = Validate(rfcSecret, "12345", now, 0); err != ErrInvalidCode {
+		t.Errorf("expected the short code to be rejected, got %v", err)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := ProvisioningURI("Cuttle.ai", "jdoe@example.com", secret)
+	if !strings.HasPrefix(u, "otpauth://totp/Cuttle.ai:jdoe@example.com?") || !strings.Contains(u, "secret="+secret) {
+		t.Errorf("unexpected provisioning uri %s", u)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+/*
+ * This file contains the parsing of the authenticator data
+ */
+
+//authenticator data flags
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+//authenticatorData is the data returned by the authenticator in the ceremonies
+type authenticatorData struct {
+	//rpIDHash is the sha-256 hash of the relying party id the credential is scoped to
+	rpIDHash []byte
+	//flags of the authenticator data
+	flags byte
+	//signCount is the signature counter of the credential
+	signCount uint32
+	//credentialID is the id of the credential created. It is present only in the registration
+	credentialID []byte
+	//publicKey is the cose encoded public key of the credential created. It is present only in the registration
+	publicKey []byte
+}
+
+//parseAuthenticatorData parses the authenticator data
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	/*
+	 * We will parse the fixed length header
+	 * If the attested credential data is present, we will parse the credential id and the public key
+	 */
+	if len(b) < 37 {
+		return nil, errors.New("Authenticator data is too short")
+	}
+	a := &authenticatorData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if a.flags&flagAttestedCredentialData == 0 {
+		return a, nil
+	}
+
+	//parsing the attested credential data. aaguid is skipped since attestation is not verified
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("Attested credential data is too short")
+	}
+	l := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if l == 0 || l > 1023 || len(rest) < l {
+		return nil, errors.New("Invalid credential id length")
+	}
+	a.credentialID = rest[:l]
+	rest = rest[l:]
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, err
+	}
+	a.publicKey = rest[:len(rest)-len(after)]
+	return a, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+/*
+ * This file contains the minimal cbor decoder required for the attestation objects and the cose keys
+ */
+
+//maxCBORDepth is the maximum nesting of the cbor data items decoded
+const maxCBORDepth = 16
+
+//errCBOR is returned when the cbor data is malformed or uses a feature not supported
+var errCBOR = errors.New("Malformed or unsupported CBOR data")
+
+//decodeCBOR decodes the first cbor data item in b and returns it along with the remaining bytes.
+//Integers are decoded as int64, byte strings as []byte, text strings as string, arrays as []interface{}
+//and maps as map[interface{}]interface{} with int64 or string keys. Indefinite lengths are not supported
+func decodeCBOR(b []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (interface{}, []byte, error) {
+	if len(b) == 0 || depth > maxCBORDepth {
+		return nil, nil, errCBOR
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	n, rest, err := cborArg(info, b[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(n), rest, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(n), rest, nil
+	case 2, 3:
+		if n > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		if major == 3 {
+			return string(rest[:n]), rest[n:], nil
+		}
+		return append([]byte{}, rest[:n]...), rest[n:], nil
+	case 4:
+		if n > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		arr := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var v interface{}
+			v, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, rest, nil
+	case 5:
+		if n > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v interface{}
+			k, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			v, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, rest, nil
+	case 6:
+		//tags are ignored and the tagged item is returned
+		return decodeCBORItem(rest, depth+1)
+	}
+
+	//major type 7 has the simple values. Floats are not used by webauthn and are not supported
+	switch info {
+	case 20:
+		return false, rest, nil
+	case 21:
+		return true, rest, nil
+	case 22, 23:
+		return nil, rest, nil
+	}
+	return nil, nil, errCBOR
+}
+
+//cborArg returns the argument of the data item with the given additional info
+func cborArg(info byte, b []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24 && len(b) >= 1:
+		return uint64(b[0]), b[1:], nil
+	case info == 25 && len(b) >= 2:
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26 && len(b) >= 4:
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27 && len(b) >= 8:
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	}
+	return 0, nil, errCBOR
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package webauthn has the implementation of the webauthn relying party for logging in with the passkeys.
+//Attestation is not verified, so the authenticators are trusted on their first use
+package webauthn
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the relying party
+ */
+
+const (
+	//RPIDKey is the key storing the relying party id. It is the domain of the frontend. Webauthn is enabled when it is set
+	RPIDKey = "WEBAUTHN_RP_ID"
+	//RPNameKey is the key storing the display name of the relying party
+	RPNameKey = "WEBAUTHN_RP_NAME"
+	//OriginsKey is the key storing the comma separated origins from which the ceremonies are allowed
+	OriginsKey = "WEBAUTHN_ORIGINS"
+	//TimeoutKey is the key storing the timeout of the ceremonies in milliseconds
+	TimeoutKey = "WEBAUTHN_TIMEOUT"
+)
+
+//Config is the configuration of the relying party
+type Config struct {
+	//RPID is the relying party id
+	RPID string
+	//RPName is the display name of the relying party
+	RPName string
+	//Origins are the origins from which the ceremonies are allowed
+	Origins []string
+	//Timeout of the ceremonies
+	Timeout time.Duration
+
+	//pending has the ceremonies in progress mapped to their challenge
+	pending map[string]ceremony
+	lock    sync.Mutex
+}
+
+//RelyingParty is the relying party of the auth service. It is nil if webauthn is not enabled
+var RelyingParty *Config
+
+func init() {
+	/*
+	 * If the relying party id is not set we won't enable webauthn
+	 * Then we will init the relying party from the environment
+	 */
+	if len(os.Getenv(RPIDKey)) == 0 {
+		return
+	}
+	c := &Config{
+		RPID:    os.Getenv(RPIDKey),
+		RPName:  "Cuttle.ai",
+		Timeout: time.Duration(60000 * time.Millisecond),
+	}
+	if len(os.Getenv(RPNameKey)) != 0 {
+		c.RPName = os.Getenv(RPNameKey)
+	}
+	for _, o := range strings.Split(os.Getenv(OriginsKey), ",") {
+		if o = strings.TrimSpace(o); len(o) != 0 {
+			c.Origins = append(c.Origins, o)
+		}
+	}
+	if len(c.Origins) == 0 {
+		c.Origins = []string{"https://" + c.RPID}
+	}
+	if t, err := strconv.Atoi(os.Getenv(TimeoutKey)); err == nil && t > 0 {
+		c.Timeout = time.Duration(t) * time.Millisecond
+	}
+	RelyingParty = c
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+/*
+ * This file contains the parsing of the cose keys of the credentials and the signature verification
+ */
+
+//COSE algorithm identifiers supported
+const (
+	//AlgES256 is ecdsa with p-256 and sha-256
+	AlgES256 = -7
+	//AlgES384 is ecdsa with p-384 and sha-384
+	AlgES384 = -35
+	//AlgEdDSA is ed25519
+	AlgEdDSA = -8
+	//AlgRS256 is rsassa pkcs1 v1.5 with sha-256
+	AlgRS256 = -257
+)
+
+//SupportedAlgorithms are the cose algorithms accepted for the credentials in the order of preference
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgES384, AlgRS256}
+
+//cose key map labels
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+)
+
+//publicKey is the public key of a credential along with its algorithm
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+//parsePublicKey parses the cose encoded public key
+func parsePublicKey(cose []byte) (*publicKey, error) {
+	v, _, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("COSE key is not a map")
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	switch alg {
+	case AlgES256, AlgES384:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		curve := elliptic.P256()
+		if alg == AlgES384 {
+			curve = elliptic.P384()
+		}
+		if kty != 2 || (alg == AlgES256 && crv != 1) || (alg == AlgES384 && crv != 2) {
+			return nil, errors.New("COSE key type doesn't match the algorithm")
+		}
+		k := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(k.X, k.Y) {
+			return nil, errors.New("COSE key point is not on the curve")
+		}
+		return &publicKey{alg: alg, key: k}, nil
+	case AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if kty != 1 || crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("COSE key type doesn't match the algorithm")
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if kty != 3 || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("COSE key type doesn't match the algorithm")
+		}
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	}
+	return nil, errors.New("Unsupported COSE algorithm")
+}
+
+//verify verifies the signature of the data
+func (p *publicKey) verify(data, sig []byte) error {
+	switch k := p.key.(type) {
+	case *ecdsa.PublicKey:
+		var s struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(sig, &s); err != nil || len(rest) != 0 {
+			return errors.New("Malformed ECDSA signature")
+		}
+		var digest []byte
+		if p.alg == AlgES384 {
+			h := sha512.Sum384(data)
+			digest = h[:]
+		} else {
+			h := sha256.Sum256(data)
+			digest = h[:]
+		}
+		if !ecdsa.Verify(k, digest, s.R, s.S) {
+			return errors.New("Invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, data, sig) {
+			return errors.New("Invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		h := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig)
+	}
+	return errors.New("Unsupported public key")
+}
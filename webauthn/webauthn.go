@@ -0,0 +1,406 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the registration and the authentication ceremonies
+ */
+
+var (
+	//ErrUnknownChallenge is returned when the challenge of the response is not pending. It may have expired
+	ErrUnknownChallenge = errors.New("Unknown or expired webauthn challenge")
+	//ErrUnknownCredential is returned when the credential of the assertion is not registered
+	ErrUnknownCredential = errors.New("Unknown webauthn credential")
+	//ErrSignCount is returned when the sign count of the assertion didn't increase. The credential may have been cloned
+	ErrSignCount = errors.New("Webauthn sign count didn't increase. The credential may have been cloned")
+)
+
+//ceremony kinds
+const (
+	registration = "registration"
+	login        = "login"
+)
+
+//now returns the current time. It is swapped in the tests
+var now = time.Now
+
+//ceremony is a ceremony in progress
+type ceremony struct {
+	//kind of the ceremony
+	kind string
+	//userID is the id of the user the ceremony is for. It is 0 for the login with a discoverable credential
+	userID uint
+	//userVerification tells whether the user verification is required
+	userVerification bool
+	//expires is the time at which the ceremony expires
+	expires time.Time
+}
+
+//Credential is a public key credential registered by a user
+type Credential struct {
+	//ID is the credential id
+	ID []byte
+	//PublicKey is the cose encoded public key of the credential
+	PublicKey []byte
+	//SignCount is the last sign count seen for the credential
+	SignCount uint32
+	//UserID is the id of the user who owns the credential
+	UserID uint
+}
+
+//Descriptor identifies a credential in the ceremony options
+type Descriptor struct {
+	//Type is always public-key
+	Type string `json:"type"`
+	//ID is the base64url encoded credential id
+	ID string `json:"id"`
+}
+
+//Entity is the relying party or the user entity in the creation options
+type Entity struct {
+	//ID of the entity. It is base64url encoded for the user
+	ID string `json:"id,omitempty"`
+	//Name of the entity
+	Name string `json:"name"`
+	//DisplayName of the user
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+//Parameter is the credential type and the algorithm accepted
+type Parameter struct {
+	//Type is always public-key
+	Type string `json:"type"`
+	//Alg is the cose algorithm identifier
+	Alg int `json:"alg"`
+}
+
+//AuthenticatorSelection has the requirements of the authenticator in the registration
+type AuthenticatorSelection struct {
+	//ResidentKey tells whether a discoverable credential has to be created
+	ResidentKey string `json:"residentKey"`
+	//RequireResidentKey is the legacy version of ResidentKey
+	RequireResidentKey bool `json:"requireResidentKey"`
+	//UserVerification tells whether the user verification is required
+	UserVerification string `json:"userVerification"`
+}
+
+//CreationOptions are the options for navigator.credentials.create with the binary values base64url encoded
+type CreationOptions struct {
+	PublicKey struct {
+		Challenge              string                 `json:"challenge"`
+		RP                     Entity                 `json:"rp"`
+		User                   Entity                 `json:"user"`
+		PubKeyCredParams       []Parameter            `json:"pubKeyCredParams"`
+		Timeout                int64                  `json:"timeout"`
+		ExcludeCredentials     []Descriptor           `json:"excludeCredentials"`
+		AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                 `json:"attestation"`
+	} `json:"publicKey"`
+}
+
+//RequestOptions are the options for navigator.credentials.get with the binary values base64url encoded
+type RequestOptions struct {
+	PublicKey struct {
+		Challenge        string       `json:"challenge"`
+		Timeout          int64        `json:"timeout"`
+		RPID             string       `json:"rpId"`
+		AllowCredentials []Descriptor `json:"allowCredentials"`
+		UserVerification string       `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+//response is the public key credential returned by the browser with the binary values base64url encoded
+type response struct {
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+//clientData is the client data collected by the browser
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+//BeginRegistration starts the registration of a new discoverable credential for the user so that the user can
+//log in without telling who they are. Credentials already registered by the user are excluded so that an
+//authenticator is not registered twice
+func (c *Config) BeginRegistration(userID uint, name string, displayName string, exclude [][]byte) (*CreationOptions, error) {
+	challenge, err := c.begin(ceremony{kind: registration, userID: userID})
+	if err != nil {
+		return nil, err
+	}
+	o := &CreationOptions{}
+	o.PublicKey.Challenge = challenge
+	o.PublicKey.RP = Entity{ID: c.RPID, Name: c.RPName}
+	o.PublicKey.User = Entity{ID: encode(userHandle(userID)), Name: name, DisplayName: displayName}
+	for _, alg := range SupportedAlgorithms {
+		o.PublicKey.PubKeyCredParams = append(o.PublicKey.PubKeyCredParams, Parameter{Type: "public-key", Alg: alg})
+	}
+	o.PublicKey.Timeout = int64(c.Timeout / time.Millisecond)
+	o.PublicKey.ExcludeCredentials = descriptors(exclude)
+	o.PublicKey.AuthenticatorSelection = AuthenticatorSelection{ResidentKey: "required", RequireResidentKey: true, UserVerification: "preferred"}
+	o.PublicKey.Attestation = "none"
+	return o, nil
+}
+
+//FinishRegistration verifies the attestation response of the registration started for the user
+//and returns the credential to be stored
+func (c *Config) FinishRegistration(userID uint, body []byte) (*Credential, error) {
+	/*
+	 * We will decode the response and verify the client data
+	 * We will decode the attestation object
+	 * We will verify the authenticator data
+	 * Then we will parse the public key of the credential
+	 */
+	//verifying the client data
+	res := &response{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	cer, err := c.verifyClientData(res.Response.ClientDataJSON, "webauthn.create", registration)
+	if err != nil {
+		return nil, err
+	}
+	if cer.userID != userID {
+		return nil, ErrUnknownChallenge
+	}
+
+	//decoding the attestation object
+	raw, err := decode(res.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	v, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	att, _ := v.(map[interface{}]interface{})
+	authData, _ := att["authData"].([]byte)
+
+	//verifying the authenticator data
+	a, err := c.verifyAuthenticatorData(authData, cer)
+	if err != nil {
+		return nil, err
+	}
+	if a.credentialID == nil {
+		return nil, errors.New("Attested credential data is missing")
+	}
+
+	//parsing the public key
+	if _, err = parsePublicKey(a.publicKey); err != nil {
+		return nil, err
+	}
+	return &Credential{ID: a.credentialID, PublicKey: a.publicKey, SignCount: a.signCount, UserID: userID}, nil
+}
+
+//BeginLogin starts the authentication with the given credentials of the user. If the user id is 0, any
+//discoverable credential is allowed. User verification has to be required when the passkey is the only factor
+func (c *Config) BeginLogin(userID uint, allow [][]byte, userVerification bool) (*RequestOptions, error) {
+	cer := ceremony{kind: login, userID: userID, userVerification: userVerification}
+	challenge, err := c.begin(cer)
+	if err != nil {
+		return nil, err
+	}
+	o := &RequestOptions{}
+	o.PublicKey.Challenge = challenge
+	o.PublicKey.Timeout = int64(c.Timeout / time.Millisecond)
+	o.PublicKey.RPID = c.RPID
+	o.PublicKey.AllowCredentials = descriptors(allow)
+	o.PublicKey.UserVerification = "preferred"
+	if cer.userVerification {
+		o.PublicKey.UserVerification = "required"
+	}
+	return o, nil
+}
+
+//FinishLogin verifies the assertion response of the authentication. get returns the registered credential
+//of the given id. The credential returned has the new sign count to be stored
+func (c *Config) FinishLogin(body []byte, get func(id []byte) *Credential) (*Credential, error) {
+	/*
+	 * We will decode the response and verify the client data
+	 * We will get the credential and make sure it belongs to the user of the ceremony
+	 * We will verify the authenticator data
+	 * We will verify the signature
+	 * Then we will check the sign count
+	 */
+	//verifying the client data
+	res := &response{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	cer, err := c.verifyClientData(res.Response.ClientDataJSON, "webauthn.get", login)
+	if err != nil {
+		return nil, err
+	}
+
+	//getting the credential
+	id, err := decode(res.RawID)
+	if err != nil {
+		return nil, err
+	}
+	cred := get(id)
+	if cred == nil {
+		return nil, ErrUnknownCredential
+	}
+	if cer.userID != 0 && cer.userID != cred.UserID {
+		return nil, ErrUnknownCredential
+	}
+	if len(res.Response.UserHandle) != 0 {
+		h, err := decode(res.Response.UserHandle)
+		if err != nil || !bytes.Equal(h, userHandle(cred.UserID)) {
+			return nil, ErrUnknownCredential
+		}
+	}
+
+	//verifying the authenticator data
+	authData, err := decode(res.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	a, err := c.verifyAuthenticatorData(authData, cer)
+	if err != nil {
+		return nil, err
+	}
+
+	//verifying the signature
+	sig, err := decode(res.Response.Signature)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataJSON, _ := decode(res.Response.ClientDataJSON)
+	hash := sha256.Sum256(clientDataJSON)
+	if err = pub.verify(append(append([]byte{}, authData...), hash[:]...), sig); err != nil {
+		return nil, err
+	}
+
+	//checking the sign count. Authenticators not supporting the counter always send 0
+	if (a.signCount != 0 || cred.SignCount != 0) && a.signCount <= cred.SignCount {
+		return nil, ErrSignCount
+	}
+	updated := *cred
+	updated.SignCount = a.signCount
+	return &updated, nil
+}
+
+//begin stores the ceremony and returns its challenge
+func (c *Config) begin(cer ceremony) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	challenge := encode(b)
+	cer.expires = now().Add(c.Timeout)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.pending == nil {
+		c.pending = map[string]ceremony{}
+	}
+	for k, v := range c.pending {
+		if v.expires.Before(now()) {
+			delete(c.pending, k)
+		}
+	}
+	c.pending[challenge] = cer
+	return challenge, nil
+}
+
+//verifyClientData verifies the type and the origin of the client data and consumes the pending ceremony of its challenge
+func (c *Config) verifyClientData(encoded string, typ string, kind string) (ceremony, error) {
+	raw, err := decode(encoded)
+	if err != nil {
+		return ceremony{}, err
+	}
+	cd := clientData{}
+	if err = json.Unmarshal(raw, &cd); err != nil {
+		return ceremony{}, err
+	}
+	if cd.Type != typ {
+		return ceremony{}, errors.New("Unexpected client data type " + cd.Type)
+	}
+	allowed := false
+	for _, o := range c.Origins {
+		allowed = allowed || o == cd.Origin
+	}
+	if !allowed {
+		return ceremony{}, errors.New("Webauthn origin " + cd.Origin + " is not allowed")
+	}
+
+	//consuming the ceremony so that the challenge can't be replayed
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cer, ok := c.pending[cd.Challenge]
+	if !ok || cer.kind != kind || cer.expires.Before(now()) {
+		return ceremony{}, ErrUnknownChallenge
+	}
+	delete(c.pending, cd.Challenge)
+	return cer, nil
+}
+
+//verifyAuthenticatorData verifies the relying party and the flags of the authenticator data
+func (c *Config) verifyAuthenticatorData(b []byte, cer ceremony) (*authenticatorData, error) {
+	a, err := parseAuthenticatorData(b)
+	if err != nil {
+		return nil, err
+	}
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(a.rpIDHash, rpIDHash[:]) {
+		return nil, errors.New("Credential is not scoped to the relying party")
+	}
+	if a.flags&flagUserPresent == 0 {
+		return nil, errors.New("User was not present")
+	}
+	if cer.userVerification && a.flags&flagUserVerified == 0 {
+		return nil, errors.New("User was not verified")
+	}
+	return a, nil
+}
+
+//userHandle returns the user handle of the user. It is the user id so that no personal info is given to the authenticator
+func userHandle(userID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(userID), 10))
+}
+
+//descriptors returns the descriptors of the credential ids
+func descriptors(ids [][]byte) []Descriptor {
+	d := []Descriptor{}
+	for _, id := range ids {
+		d = append(d, Descriptor{Type: "public-key", ID: encode(id)})
+	}
+	return d
+}
+
+//encode returns the base64url encoding of b without the padding
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//decode decodes the base64url encoded string with or without the padding
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
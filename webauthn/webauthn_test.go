@@ -0,0 +1,234 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+/*
+ * This file contains the tests of the ceremonies against a software authenticator
+ */
+
+//cborHead encodes the head of a cbor data item
+func cborHead(major byte, n int) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 256:
+		return []byte{major<<5 | 24, byte(n)}
+	}
+	return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+}
+
+//cborInt encodes an integer
+func cborInt(n int) []byte {
+	if n < 0 {
+		return cborHead(1, -1-n)
+	}
+	return cborHead(0, n)
+}
+
+//cborBytes encodes a byte string
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, len(b)), b...)
+}
+
+//cborText encodes a text string
+func cborText(s string) []byte {
+	return append(cborHead(3, len(s)), s...)
+}
+
+//cborMap encodes a map from the already encoded keys and values
+func cborMap(kv ...[]byte) []byte {
+	b := cborHead(5, len(kv)/2)
+	for _, v := range kv {
+		b = append(b, v...)
+	}
+	return b
+}
+
+//authenticator is a software authenticator with a single p-256 credential
+type authenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	rpID      string
+	origin    string
+	signCount uint32
+	flags     byte
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{key: k, id: []byte("credential-1"), rpID: "example.com", origin: "https://example.com", flags: flagUserPresent | flagUserVerified}
+}
+
+func (a *authenticator) coseKey() []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	xb, yb := a.key.X.Bytes(), a.key.Y.Bytes()
+	copy(x[32-len(xb):], xb)
+	copy(y[32-len(yb):], yb)
+	return cborMap(cborInt(coseKty), cborInt(2), cborInt(coseAlg), cborInt(AlgES256),
+		cborInt(coseCrv), cborInt(1), cborInt(coseX), cborBytes(x), cborInt(coseY), cborBytes(y))
+}
+
+func (a *authenticator) authData(attested bool) []byte {
+	h := sha256.Sum256([]byte(a.rpID))
+	b := append([]byte{}, h[:]...)
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedCredentialData
+	}
+	b = append(b, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[33:], a.signCount)
+	if attested {
+		b = append(b, make([]byte, 16)...)
+		b = append(b, byte(len(a.id)>>8), byte(len(a.id)))
+		b = append(b, a.id...)
+		b = append(b, a.coseKey()...)
+	}
+	return b
+}
+
+func (a *authenticator) clientData(typ, challenge string) []byte {
+	b, _ := json.Marshal(clientData{Type: typ, Challenge: challenge, Origin: a.origin})
+	return b
+}
+
+//create returns the attestation response for the creation options
+func (a *authenticator) create(o *CreationOptions) []byte {
+	res := response{RawID: encode(a.id), Type: "public-key"}
+	res.Response.ClientDataJSON = encode(a.clientData("webauthn.create", o.PublicKey.Challenge))
+	res.Response.AttestationObject = encode(cborMap(cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(), cborText("authData"), cborBytes(a.authData(true))))
+	b, _ := json.Marshal(res)
+	return b
+}
+
+//get returns the assertion response for the request options
+func (a *authenticator) get(t *testing.T, o *RequestOptions) []byte {
+	a.signCount++
+	authData := a.authData(false)
+	cd := a.clientData("webauthn.get", o.PublicKey.Challenge)
+	h := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), h[:]...))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := asn1.Marshal(struct{ R, S interface{} }{r, s})
+	res := response{RawID: encode(a.id), Type: "public-key"}
+	res.Response.ClientDataJSON = encode(cd)
+	res.Response.AuthenticatorData = encode(authData)
+	res.Response.Signature = encode(sig)
+	b, _ := json.Marshal(res)
+	return b
+}
+
+func testConfig() *Config {
+	return &Config{RPID: "example.com", RPName: "Example", Origins: []string{"https://example.com"}, Timeout: time.Minute}
+}
+
+//register registers the credential of the authenticator for the user
+func register(t *testing.T, c *Config, a *authenticator, userID uint) *Credential {
+	o, err := c.BeginRegistration(userID, "jdoe@example.com", "John Doe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := c.FinishRegistration(userID, a.create(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cred
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	c, a := testConfig(), newAuthenticator(t)
+	cred := register(t, c, a, 7)
+	if string(cred.ID) != "credential-1" || cred.UserID != 7 {
+		t.Fatalf("unexpected credential %+v", cred)
+	}
+	get := func(id []byte) *Credential {
+		if string(id) == string(cred.ID) {
+			return cred
+		}
+		return nil
+	}
+
+	o, err := c.BeginLogin(7, [][]byte{cred.ID}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := a.get(t, o)
+	updated, err := c.FinishLogin(body, get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.SignCount != 1 {
+		t.Errorf("expected the sign count to be updated, got %d", updated.SignCount)
+	}
+	if _, err = c.FinishLogin(body, get); err != ErrUnknownChallenge {
+		t.Errorf("expected the replayed assertion to be rejected, got %v", err)
+	}
+}
+
+func TestLoginSignCount(t *testing.T) {
+	c, a := testConfig(), newAuthenticator(t)
+	cred := register(t, c, a, 7)
+	cred.SignCount = 10
+	o, _ := c.BeginLogin(7, [][]byte{cred.ID}, false)
+	_, err := c.FinishLogin(a.get(t, o), func(id []byte) *Credential { return cred })
+	if err != ErrSignCount {
+		t.Errorf("expected the sign count to be rejected, got %v", err)
+	}
+}
+
+func TestLoginOtherUser(t *testing.T) {
+	c, a := testConfig(), newAuthenticator(t)
+	cred := register(t, c, a, 7)
+	o, _ := c.BeginLogin(8, nil, false)
+	_, err := c.FinishLogin(a.get(t, o), func(id []byte) *Credential { return cred })
+	if err != ErrUnknownCredential {
+		t.Errorf("expected the credential of other user to be rejected, got %v", err)
+	}
+}
+
+func TestDiscoverableLoginRequiresUserVerification(t *testing.T) {
+	c, a := testConfig(), newAuthenticator(t)
+	cred := register(t, c, a, 7)
+	a.flags = flagUserPresent
+	o, _ := c.BeginLogin(0, nil, true)
+	if _, err := c.FinishLogin(a.get(t, o), func(id []byte) *Credential { return cred }); err == nil {
+		t.Error("expected the login without user verification to be rejected")
+	}
+}
+
+func TestRegisterRejectsOtherOrigins(t *testing.T) {
+	c := testConfig()
+	cases := []func(a *authenticator){
+		func(a *authenticator) { a.origin = "https://evil.com" },
+		func(a *authenticator) { a.rpID = "evil.com" },
+		func(a *authenticator) { a.flags = 0 },
+	}
+	for i, f := range cases {
+		a := newAuthenticator(t)
+		f(a)
+		o, _ := c.BeginRegistration(7, "jdoe@example.com", "John Doe", nil)
+		if _, err := c.FinishRegistration(7, a.create(o)); err == nil {
+			t.Errorf("case %d: expected the registration to be rejected", i)
+		}
+	}
+}